@@ -0,0 +1,48 @@
+package main
+
+import (
+	"aws/pkg/auditEC2"
+	"aws/pkg/teardownEC2"
+	"flag"
+	"fmt"
+	"time"
+)
+
+var auditCmd = &command{
+	name:    "audit",
+	summary: "find unused resources (security groups, key pairs, volumes, Elastic IPs, stopped instances) and their cost",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		stoppedDays := fs.Int("stopped-days", 30, "report the instances stopped for more days")
+		deleteFound := fs.Bool("delete", false, "delete the resources found (asks for confirmation, unless --yes)")
+		flags := deletionFlags(fs)
+
+		return func(args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("audit takes no arguments")
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			result, err := auditEC2.Audit(ec2client, auditEC2.AuditOptions{StoppedFor: time.Duration(*stoppedDays) * 24 * time.Hour})
+			if err != nil {
+				return err
+			}
+			if !*deleteFound {
+				fmt.Fprintf(a.messages(), "%d unused resources, estimated cost: $%.2f per month\n", len(result.Findings), result.TotalMonthlyCost)
+				return a.print(result)
+			}
+
+			deleteOptions, err := flags.options()
+			if err != nil {
+				return err
+			}
+			deleted, err := teardownEC2.DeleteResources(ec2client, result.Plan(), flags.confirmer(a), teardownEC2.Options{Delete: deleteOptions, DryRun: a.dryRun, Output: a.messages()})
+			// the result is printed even if some resources couldn't be deleted
+			if deleted != nil {
+				a.print(deleted)
+			}
+			return err
+		}
+	},
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"aws/pkg/backupEC2"
+	"flag"
+	"fmt"
+)
+
+var backupCmd = &command{
+	name:    "backup",
+	summary: "snapshot volumes and prune the old snapshots according to retention policies (ex: keep 7 daily, 4 weekly, 6 monthly)",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		policyFile := fs.String("policy-file", "", "YAML file of the retention policies (see README)")
+		name := fs.String("policy", "", "without --policy-file: name of the policy (recorded in the tag "+backupEC2.PolicyTag+" of its snapshots)")
+		sel := fs.String("select", "", "without --policy-file: selector expression of the instances whose volumes are snapshotted (ex: tag:env=prod)")
+		volumes := fs.String("volumes", "", "without --policy-file: tag of the volumes snapshotted (key=value)")
+		keep := fs.String("keep", "", "without --policy-file: snapshots kept (ex: \"7 daily, 4 weekly, 6 monthly\"; periods: hourly, daily, weekly, monthly, yearly)")
+		noCreate := fs.Bool("no-create", false, "don't create snapshots, only delete the old ones")
+
+		return func(args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("backup takes no arguments")
+			}
+			var policies []*backupEC2.Policy
+			switch {
+			case *policyFile != "" && (*name != "" || *sel != "" || *volumes != "" || *keep != ""):
+				return fmt.Errorf("--policy-file can't be used with --policy, --select, --volumes or --keep")
+			case *policyFile != "":
+				var err error
+				if policies, err = backupEC2.LoadPolicies(*policyFile); err != nil {
+					return err
+				}
+			default:
+				policy, err := backupEC2.NewPolicy(*name, *sel, *volumes, *keep)
+				if err != nil {
+					fs.Usage()
+					return err
+				}
+				policies = append(policies, policy)
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+
+			// the report is printed even if some snapshots couldn't be created or deleted
+			report, err := backupEC2.Run(ec2client, policies, backupEC2.Options{NoCreate: *noCreate, DryRun: a.dryRun, Output: a.messages()})
+			a.print(report)
+			return err
+		}
+	},
+}
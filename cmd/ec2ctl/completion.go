@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var completionCmd = &command{
+	name:    "completion",
+	args:    "bash|zsh|fish",
+	summary: "print the shell completion script (ex: source <(ec2ctl completion bash))",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		return func(args []string) error {
+			if len(args) != 1 {
+				fs.Usage()
+				return fmt.Errorf("expected a shell name")
+			}
+			switch args[0] {
+			case "bash":
+				bashCompletion(os.Stdout)
+			case "zsh":
+				// zsh can use the bash completion script
+				fmt.Println("#compdef ec2ctl")
+				fmt.Println("autoload -U +X bashcompinit && bashcompinit")
+				bashCompletion(os.Stdout)
+			case "fish":
+				fishCompletion(os.Stdout)
+			default:
+				return fmt.Errorf("unsupported shell %q (expected bash, zsh or fish)", args[0])
+			}
+			return nil
+		}
+	},
+}
+
+// Returns the flags of the command c (including the global flags).
+func commandFlags(c *command) []*flag.Flag {
+	fs, _ := (&app{}).flagSet(c)
+	var flags []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+	return flags
+}
+
+// Returns true if the flag f expects a value (ie, isn't a boolean flag).
+func takesValue(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+func bashCompletion(w io.Writer) {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	// global flags expecting a value: the word following them isn't the command name
+	var globalFlags, valueFlags []string
+	global := flag.NewFlagSet("ec2ctl", flag.ContinueOnError)
+	(&app{}).globalFlags(global)
+	global.VisitAll(func(f *flag.Flag) {
+		globalFlags = append(globalFlags, "--"+f.Name)
+		if takesValue(f) {
+			valueFlags = append(valueFlags, "--"+f.Name)
+		}
+	})
+
+	fmt.Fprintln(w, "_ec2ctl() {")
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" cmd="" skip="" i`)
+	fmt.Fprintln(w, `	for ((i = 1; i < COMP_CWORD; i++)); do`)
+	fmt.Fprintln(w, `		local word="${COMP_WORDS[i]}"`)
+	fmt.Fprintln(w, `		if [[ -n $skip ]]; then skip=""; continue; fi`)
+	fmt.Fprintf(w, "\t\tcase \"$word\" in\n\t\t%s) skip=1 ;;\n\t\t-*) ;;\n\t\t*) cmd=\"$word\"; break ;;\n\t\tesac\n", strings.Join(valueFlags, "|"))
+	fmt.Fprintln(w, `	done`)
+	fmt.Fprintln(w, `	case "$cmd" in`)
+	fmt.Fprintf(w, "\t\"\") COMPREPLY=($(compgen -W \"%s %s\" -- \"$cur\")) ;;\n", strings.Join(names, " "), strings.Join(globalFlags, " "))
+	for _, c := range commands {
+		var flags []string
+		for _, f := range commandFlags(c) {
+			flags = append(flags, "--"+f.Name)
+		}
+		fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", c.name, strings.Join(flags, " "))
+	}
+	fmt.Fprintln(w, `	esac`)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -F _ec2ctl ec2ctl")
+}
+
+func fishCompletion(w io.Writer) {
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c ec2ctl -f -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.summary))
+	}
+	for _, c := range commands {
+		for _, f := range commandFlags(c) {
+			line := fmt.Sprintf("complete -c ec2ctl -n '__fish_seen_subcommand_from %s' -l %s -d %s", c.name, f.Name, fishQuote(f.Usage))
+			if takesValue(f) {
+				line += " -r"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", `\'`) + "'"
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"aws/pkg/copyEC2"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var cpCmd = &command{
+	name:    "cp",
+	args:    "<source> <destination>",
+	summary: "copy files over SFTP between the local machine and an instance, given as <instance-id|name>:<path> (ex: cp build/app.tar.gz web:/tmp/), and verify their checksums",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		recursive := fs.Bool("recursive", false, "copy directories and their content")
+		fs.BoolVar(recursive, "r", false, "shorthand for --recursive")
+		noVerify := fs.Bool("no-verify", false, "don't compare the SHA-256 checksums of the files copied with the files on the instance")
+		ssh := sshFlags(fs)
+
+		return func(args []string) error {
+			if len(args) != 2 {
+				fs.Usage()
+				return fmt.Errorf("expected a source and a destination")
+			}
+			sourceInstance, source := splitRemote(args[0])
+			destinationInstance, destination := splitRemote(args[1])
+			var instance, direction string
+			switch {
+			case sourceInstance == "" && destinationInstance != "":
+				instance, direction = destinationInstance, copyEC2.Upload
+			case sourceInstance != "" && destinationInstance == "":
+				instance, direction = sourceInstance, copyEC2.Download
+			default:
+				return fmt.Errorf("expected either the source or the destination on an instance (<instance-id|name>:<path>)")
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+
+			options := copyEC2.Options{SSH: ssh.options(), Recursive: *recursive, NoVerify: *noVerify, DryRun: a.dryRun, Output: a.messages()}
+			// the progress of the files is only shown on a terminal
+			if term.IsTerminal(int(os.Stderr.Fd())) {
+				options.Progress = os.Stderr
+			}
+			// the result is printed even if some files couldn't be copied
+			result, err := copyEC2.Copy(ec2client, instance, direction, source, destination, options)
+			if result != nil {
+				a.print(result)
+			}
+			return err
+		}
+	},
+}
+
+// Splits an argument of cp into the instance and the path on it, or returns
+// an empty instance for a local path (ex: ./build, /tmp/a:b or C:\build).
+func splitRemote(arg string) (string, string) {
+	instance, p, ok := strings.Cut(arg, ":")
+	if !ok || instance == "" || strings.ContainsAny(instance, `/\`) || len(instance) == 1 {
+		return "", arg
+	}
+	return instance, p
+}
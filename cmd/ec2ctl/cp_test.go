@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestSplitRemote(t *testing.T) {
+	tests := []struct {
+		arg      string
+		instance string
+		path     string
+	}{
+		{"web-1:/tmp/build", "web-1", "/tmp/build"},
+		{"i-0123456789abcdef0:~/app", "i-0123456789abcdef0", "~/app"},
+		{"web-1:", "web-1", ""},
+		{"web-1:a:b", "web-1", "a:b"},
+		// local paths
+		{"build", "", "build"},
+		{"./build", "", "./build"},
+		{"/tmp/a:b", "", "/tmp/a:b"},
+		{"dir/web-1:x", "", "dir/web-1:x"},
+		{`C:\build`, "", `C:\build`},
+		{"C:build", "", "C:build"},
+		{":build", "", ":build"},
+	}
+	for _, test := range tests {
+		instance, p := splitRemote(test.arg)
+		if instance != test.instance || p != test.path {
+			t.Errorf("splitRemote(%q) = %q, %q, expected %q, %q", test.arg, instance, p, test.instance, test.path)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"aws/pkg/confirm"
+	"aws/pkg/deleteEC2"
+	"flag"
+	"fmt"
+)
+
+var deleteCmd = &command{
+	name:    "delete",
+	args:    "[<instance-id>...]",
+	summary: "permanently delete instances (by ID, by name, tag or selector expression, or all of them)",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		selection := selectionFlags(fs, "delete")
+		all := fs.Bool("all", false, "delete **ALL** instances of the account (asks for confirmation, unless --yes)")
+		flags := deletionFlags(fs)
+		wait := fs.Bool("wait", false, "wait until the deleted instances are terminated, and print their final state")
+		waitTimeout := fs.Duration("wait-timeout", deleteEC2.DefaultWaitTimeout, "with --wait: maximum time waited for the termination")
+
+		return func(args []string) error {
+			selectors := 0
+			for _, set := range []bool{len(args) > 0, selection.set(), *all} {
+				if set {
+					selectors++
+				}
+			}
+			if selectors != 1 {
+				fs.Usage()
+				return fmt.Errorf("expected either instance IDs, a selection (--name, --tag, --select) or --all")
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+
+			options, err := flags.options()
+			if err != nil {
+				return err
+			}
+			options.Wait = *wait
+			options.WaitTimeout = *waitTimeout
+			options.DryRun = a.dryRun
+			options.Output = a.messages()
+			if len(args) > 0 {
+				// the instances given by ID are deleted without guardrails
+				result, err := deleteEC2.DeleteInstances(ec2client, args, options)
+				a.print(result)
+				return err
+			}
+
+			var result *deleteEC2.DeleteResult
+			if *all {
+				result, err = deleteEC2.DeleteAllInstances(ec2client, flags.confirmer(a), options)
+			} else {
+				sel, selErr := selection.selector()
+				if selErr != nil {
+					return selErr
+				}
+				result, err = deleteEC2.DeleteSelected(ec2client, sel, flags.confirmer(a), options)
+			}
+			// the result is printed even if some instances couldn't be deleted
+			if result != nil {
+				a.print(result)
+			}
+			return err
+		}
+	},
+}
+
+// Flags of the instances that must never be deleted, stopped... (protection tag, policy).
+type protection struct {
+	protectionTag *string
+	policyFile    *string
+}
+
+// Defines the flags of the protected instances in fs.
+func protectionFlags(fs *flag.FlagSet) *protection {
+	return &protection{
+		protectionTag: fs.String("protection-tag", deleteEC2.DefaultProtectionTag, `"key=value" tag of the protected instances ("-" for none; not checked for instances given by ID)`),
+		policyFile:    fs.String("policy", "", "YAML file with the allow/deny selector expressions of the instances that can be deleted (or stopped, rebooted)"),
+	}
+}
+
+// Returns the guardrails given by the flags (without threshold).
+func (p *protection) guardrails() (deleteEC2.Guardrails, error) {
+	guardrails := deleteEC2.Guardrails{ProtectionTag: *p.protectionTag}
+	if *p.policyFile != "" {
+		policy, err := deleteEC2.LoadPolicy(*p.policyFile)
+		if err != nil {
+			return guardrails, err
+		}
+		guardrails.Policy = policy
+	}
+	return guardrails, nil
+}
+
+// Flags of the commands deleting multiple instances (delete, teardown, audit):
+// concurrency, retries and guardrails.
+type deletion struct {
+	*protection
+	concurrency       *int
+	retries           *int
+	disableProtection *bool
+	maxInstances      *int
+	force             *bool
+	confirmAccount    *bool
+}
+
+// Defines the flags of the commands deleting multiple instances in fs.
+func deletionFlags(fs *flag.FlagSet) *deletion {
+	return &deletion{
+		protection:        protectionFlags(fs),
+		concurrency:       concurrencyFlag(fs),
+		retries:           fs.Int("retries", 0, "number of times the deletions that failed with a retryable error are retried"),
+		disableProtection: fs.Bool("disable-protection", false, "disable the termination protection of protected instances to delete them (else asked after the first confirmation, or refused with --yes)"),
+		maxInstances:      fs.Int("max-instances", 50, "refuse to delete more instances at once, unless --force (0: no limit; not checked for instances given by ID)"),
+		force:             fs.Bool("force", false, "delete more instances than --max-instances"),
+		confirmAccount:    fs.Bool("confirm-account", false, "confirm by typing the AWS account ID, instead of the number of instances (or resources) to delete"),
+	}
+}
+
+// Returns the deletion options given by the flags.
+func (d *deletion) options() (deleteEC2.DeleteOptions, error) {
+	guardrails, err := d.guardrails()
+	guardrails.MaxInstances = *d.maxInstances
+	guardrails.Force = *d.force
+	guardrails.ConfirmAccountID = *d.confirmAccount
+	options := deleteEC2.DeleteOptions{
+		Concurrency: *d.concurrency,
+		Retries:     *d.retries,
+		Guardrails:  guardrails,
+	}
+	return options, err
+}
+
+// Returns the confirmer of the deletion: disabling termination protection
+// needs its own explicit confirmation (--yes doesn't answer it).
+func (d *deletion) confirmer(a *app) confirm.Confirmer {
+	confirmer := confirm.Policy{Fallback: a.confirmer()}
+	if *d.disableProtection {
+		confirmer.Rules = map[string]bool{confirm.ActionDisableProtection: true}
+	} else if a.yes {
+		confirmer.Rules = map[string]bool{confirm.ActionDisableProtection: false}
+	}
+	return confirmer
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"aws/pkg/eipEC2"
+	"flag"
+	"fmt"
+)
+
+var eipCmd = &command{
+	name:    "eip",
+	args:    "list | allocate | associate <eip> <instance-id> | disassociate <eip> | release <eip>",
+	summary: "manage the Elastic IPs (given by allocation ID or public IP), so that instances keep the same address",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		name := fs.String("name", "", "allocate: name of the Elastic IP (tag Name)")
+		reassociate := fs.Bool("reassociate", false, "associate: move the Elastic IP if it's associated with another instance")
+		disassociate := fs.Bool("disassociate", false, "release: disassociate the Elastic IP first if it's associated")
+
+		return func(args []string) error {
+			expected := map[string]int{"list": 1, "allocate": 1, "associate": 3, "disassociate": 2, "release": 2}
+			if len(args) == 0 || expected[args[0]] != len(args) {
+				fs.Usage()
+				return fmt.Errorf("expected \"eip list\", \"eip allocate\", \"eip associate <eip> <instance-id>\", \"eip disassociate <eip>\" or \"eip release <eip>\"")
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+
+			options := eipEC2.Options{DryRun: a.dryRun, Output: a.messages()}
+			var result any
+			switch args[0] {
+			case "list":
+				result, err = eipEC2.List(ec2client)
+			case "allocate":
+				result, err = eipEC2.Allocate(ec2client, *name, "", options)
+			case "associate":
+				result, err = eipEC2.Associate(ec2client, args[1], args[2], *reassociate, options)
+			case "disassociate":
+				result, err = eipEC2.Disassociate(ec2client, args[1], options)
+			case "release":
+				result, err = eipEC2.Release(ec2client, args[1], *disassociate, options)
+			}
+			if err != nil {
+				return err
+			}
+			return a.print(result)
+		}
+	},
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"aws/pkg/describeEC2"
+	"aws/pkg/execEC2"
+	"aws/pkg/fleet"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var execCmd = &command{
+	name:    "exec",
+	args:    "-- <command...>",
+	summary: "run a command with SSH on the running instances selected by name, tag or selector expression, and print a summary of the exit codes",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		selection := selectionFlags(fs, "run the command on")
+		concurrency := fs.Int("concurrency", fleet.DefaultConcurrency, "maximum number of instances running the command at the same time")
+		timeout := fs.Duration("timeout", 0, "maximum duration of the command on each instance (ex: 5m; default: no limit)")
+		stopOnFailure := fs.Bool("stop-on-failure", false, "don't start the command on the remaining instances once it failed on one (default: run it on all instances)")
+		ssh := sshFlags(fs)
+
+		return func(args []string) error {
+			if len(args) == 0 {
+				fs.Usage()
+				return fmt.Errorf("expected a command")
+			}
+			if !selection.set() {
+				fs.Usage()
+				return fmt.Errorf("expected a selection of the instances (--name, --tag or --select)")
+			}
+			sel, err := selection.selector()
+			if err != nil {
+				return err
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			instances, err := describeEC2.ListInstances(ec2client, selectorOptions(sel))
+			if err != nil {
+				return err
+			}
+			var running []describeEC2.Instance
+			for _, instance := range instances {
+				if instance.State == "running" {
+					running = append(running, instance)
+				}
+			}
+			if ignored := len(instances) - len(running); ignored > 0 {
+				fmt.Fprintf(a.messages(), "%d selected instances are not running and are ignored.\n", ignored)
+			}
+			if len(running) == 0 {
+				fmt.Fprintln(a.messages(), "No instance found.")
+				return nil
+			}
+
+			command := strings.Join(args, " ")
+			fmt.Fprintf(a.messages(), "Running %q on %d instances:\n", command, len(running))
+			// the output of the instances is printed with the messages,
+			// to keep stdout for the result with --output json or yaml
+			result, err := execEC2.Run(ec2client, running, command, execEC2.Options{
+				SSH:           ssh.options(),
+				Concurrency:   *concurrency,
+				Timeout:       *timeout,
+				StopOnFailure: *stopOnFailure,
+				Stdout:        a.messages(),
+				Stderr:        os.Stderr,
+				DryRun:        a.dryRun,
+				Output:        a.messages(),
+			})
+			if result != nil {
+				a.print(result)
+			}
+			return err
+		}
+	},
+}
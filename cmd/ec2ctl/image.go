@@ -0,0 +1,129 @@
+package main
+
+import (
+	"aws/pkg/imageEC2"
+	"flag"
+	"fmt"
+	"time"
+)
+
+var imageCmd = &command{
+	name:    "image",
+	args:    "create <instance-id> | list | deregister <image>",
+	summary: "create an AMI from an instance (usable by launch --ami), list the AMIs of the account, or deregister an AMI and delete its snapshots",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		name := fs.String("name", "", "create: name of the image (required)")
+		noReboot := fs.Bool("no-reboot", false, "create: don't reboot the instance before creating the image (the consistency of the file systems isn't guaranteed)")
+		image := imageFlags(fs)
+		keepSnapshots := fs.Bool("keep-snapshots", false, "deregister: don't delete the snapshots of the image")
+
+		return func(args []string) error {
+			expected := map[string]int{"create": 2, "list": 1, "deregister": 2}
+			if len(args) == 0 || expected[args[0]] != len(args) {
+				fs.Usage()
+				return fmt.Errorf("expected \"image create <instance-id>\", \"image list\" or \"image deregister <image>\"")
+			}
+			if args[0] == "create" && *name == "" {
+				return fmt.Errorf("the name of the image is required (--name)")
+			}
+			options, err := image.options()
+			if err != nil {
+				return err
+			}
+			options.NoReboot = *noReboot
+			options.DryRun = a.dryRun
+			options.Output = a.messages()
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+
+			switch args[0] {
+			case "create":
+				result, err := imageEC2.CreateImage(ec2client, args[1], *name, options)
+				if result != nil {
+					// the image may still be pending: print it, to know its ID
+					a.print(result)
+				}
+				return err
+			case "list":
+				result, err := imageEC2.ListImages(ec2client)
+				if err != nil {
+					return err
+				}
+				return a.print(result)
+			}
+			imageId, err := imageEC2.Resolve(ec2client, args[1])
+			if err != nil {
+				return err
+			}
+			// the result is printed even if some snapshots couldn't be deleted
+			result, err := imageEC2.Deregister(ec2client, imageId, *keepSnapshots, options)
+			if result != nil {
+				a.print(result)
+			}
+			return err
+		}
+	},
+}
+
+var snapshotCmd = &command{
+	name:    "snapshot",
+	args:    "create <instance-id>",
+	summary: "create a snapshot of each EBS volume of an instance",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		image := imageFlags(fs)
+
+		return func(args []string) error {
+			if len(args) != 2 || args[0] != "create" {
+				fs.Usage()
+				return fmt.Errorf("expected \"snapshot create <instance-id>\"")
+			}
+			options, err := image.options()
+			if err != nil {
+				return err
+			}
+			options.DryRun = a.dryRun
+			options.Output = a.messages()
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			result, err := imageEC2.CreateSnapshots(ec2client, args[1], options)
+			if result != nil {
+				a.print(result)
+			}
+			return err
+		}
+	},
+}
+
+// Flags shared by the image and snapshot commands.
+type imageOptions struct {
+	description *string
+	tags        stringList
+	noWait      *bool
+	waitTimeout *time.Duration
+}
+
+func imageFlags(fs *flag.FlagSet) *imageOptions {
+	o := &imageOptions{}
+	o.description = fs.String("description", "", "description of the image or snapshots")
+	fs.Var(&o.tags, "tag", "tag of the image and its snapshots (key=value), can be given multiple times")
+	o.noWait = fs.Bool("no-wait", false, "don't wait for the image or snapshots to be available")
+	o.waitTimeout = fs.Duration("wait-timeout", imageEC2.DefaultWaitTimeout, "maximum time waited for the image or snapshots to be available")
+	return o
+}
+
+func (o *imageOptions) options() (imageEC2.Options, error) {
+	tags, err := parseTags(o.tags)
+	if err != nil {
+		return imageEC2.Options{}, err
+	}
+	return imageEC2.Options{
+		Description: *o.description,
+		Tags:        tags,
+		NoWait:      *o.noWait,
+		WaitTimeout: *o.waitTimeout,
+	}, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"aws/pkg/launchEC2"
+	"flag"
+	"fmt"
+)
+
+var keyCmd = &command{
+	name:    "key",
+	args:    "create <name>",
+	summary: "create an EC2 key pair if it doesn't exist, and download it in <name>.pem",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		return func(args []string) error {
+			if len(args) != 2 || args[0] != "create" {
+				fs.Usage()
+				return fmt.Errorf("expected \"key create <name>\"")
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			result, err := launchEC2.ConfigureAccessKey(ec2client, args[1], a.confirmer(), launchEC2.Options{DryRun: a.dryRun, Output: a.messages()})
+			if err != nil {
+				return err
+			}
+			return a.print(result)
+		}
+	},
+}
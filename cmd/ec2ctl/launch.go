@@ -0,0 +1,108 @@
+package main
+
+import (
+	"aws/pkg/confirm"
+	"aws/pkg/describeEC2"
+	"aws/pkg/eipEC2"
+	"aws/pkg/expiry"
+	"aws/pkg/imageEC2"
+	"aws/pkg/launchEC2"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Maximum time waited for a launched instance to be running, before associating its Elastic IP.
+const eipWaitTimeout = 5 * time.Minute
+
+var launchCmd = &command{
+	name:    "launch",
+	summary: "launch an instance (creating its security group and key if needed)",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		name := fs.String("name", "myEC2instance", "name of the instance")
+		instanceType := fs.String("type", "t2.micro", "instance type")
+		ami := fs.String("ami", "ami-0fda19674ff597992", "ID of the AMI, or name of an AMI of the account created with \"ec2ctl image create\" (default: amazon linux)")
+		securityGroup := fs.String("sg", "mySecurityGroup", "name of the security group")
+		key := fs.String("key", "myEC2key", "name of the EC2 key pair (downloaded in <key>.pem if created)")
+		noCreateKey := fs.Bool("no-create-key", false, "never create the key pair (fails if it doesn't exist)")
+		ttl := fs.String("ttl", "", "make the instance expire after this time (ex: 8h, 7d): it's then stopped or terminated by \"ec2ctl reap\"")
+		noWait := fs.Bool("no-wait", false, "don't wait for the public IP of the instance")
+		eip := fs.Bool("eip", false, "allocate an Elastic IP and associate it with the instance, so that it keeps its public IP when stopped (released by \"ec2ctl teardown\")")
+
+		return func(args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("launch takes no arguments")
+			}
+			var expiresAfter time.Duration
+			if *ttl != "" {
+				var err error
+				expiresAfter, err = expiry.ParseTTL(*ttl)
+				if err != nil {
+					return err
+				}
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+
+			// creates a security group to define authorized traffic rules to the instance
+			_, err = launchEC2.ConfigureSecurityGroup(ec2client, *securityGroup, launchEC2.Options{DryRun: a.dryRun, Output: a.messages()})
+			if err != nil {
+				return err
+			}
+
+			// if the EC2 access key doesn't exist, creates and downloads one.
+			confirmer := a.confirmer()
+			if *noCreateKey {
+				confirmer = confirm.AutoNo{}
+			}
+			_, err = launchEC2.ConfigureAccessKey(ec2client, *key, confirmer, launchEC2.Options{DryRun: a.dryRun, Output: a.messages()})
+			if err != nil {
+				return err
+			}
+
+			amiID, err := imageEC2.Resolve(ec2client, *ami)
+			if err != nil {
+				return err
+			}
+			result, err := launchEC2.LaunchInstance(ec2client, *instanceType, amiID, *securityGroup, *key, *name, expiresAfter, launchEC2.Options{DryRun: a.dryRun, Output: a.messages()})
+			if err != nil {
+				return err
+			}
+
+			// allocates an Elastic IP, once the instance is running
+			if *eip {
+				if !result.DryRun {
+					_, err = describeEC2.WaitState(ec2client, []string{result.InstanceID}, types.InstanceStateNameRunning, eipWaitTimeout, a.messages())
+				}
+				if err == nil {
+					var address *eipEC2.Address
+					address, err = eipEC2.AllocateFor(ec2client, result.InstanceID, *name, eipEC2.Options{DryRun: a.dryRun, Output: a.messages()})
+					if address != nil {
+						result.PublicIP, result.AllocationID = address.PublicIP, address.AllocationID
+					}
+				}
+				if err != nil {
+					// the instance is launched: still print it
+					a.print(result)
+					return err
+				}
+				return a.print(result)
+			}
+
+			// fetches the public IP of the newly created instance.
+			if !*noWait && !result.DryRun {
+				result.PublicIP, err = launchEC2.GetPublicIP(ec2client, result.InstanceID, a.messages())
+				if err != nil {
+					// the instance is launched: still print it
+					a.print(result)
+					return err
+				}
+			}
+			return a.print(result)
+		}
+	},
+}
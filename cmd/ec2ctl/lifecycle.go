@@ -0,0 +1,141 @@
+package main
+
+import (
+	"aws/pkg/confirm"
+	"aws/pkg/fleet"
+	"aws/pkg/lifecycleEC2"
+	"aws/pkg/selector"
+	"flag"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+var stopCmd = lifecycleCommand(lifecycleEC2.ActionStop, "stop instances (by ID, by name, tag or selector expression)", lifecycleEC2.Stop, lifecycleEC2.StopSelected)
+
+var startCmd = lifecycleCommand(lifecycleEC2.ActionStart, "start stopped instances (by ID, by name, tag or selector expression)", lifecycleEC2.Start, nil)
+
+var rebootCmd = lifecycleCommand(lifecycleEC2.ActionReboot, "reboot instances (by ID, by name, tag or selector expression)", lifecycleEC2.Reboot, lifecycleEC2.RebootSelected)
+
+// Returns the command running the lifecycle operation on the instances given by ID,
+// or on the selected instances. If guarded isn't nil, it runs the operation on the
+// selected instances with the guardrails (protection tag, policy, threshold) and
+// a confirmation, like delete.
+func lifecycleCommand(action string, summary string,
+	operation func(*ec2.Client, []string, lifecycleEC2.Options) (*lifecycleEC2.Result, error),
+	guarded func(*ec2.Client, *selector.Selector, confirm.Confirmer, lifecycleEC2.Options) (*lifecycleEC2.Result, error)) *command {
+	return &command{
+		name:    action,
+		args:    "[<instance-id>...]",
+		summary: summary,
+		setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+			selection := selectionFlags(fs, action)
+			concurrency := concurrencyFlag(fs)
+			wait := fs.Bool("wait", false, "wait until the instances are stopped (stop) or running (start, reboot), and print their final state")
+			waitTimeout := fs.Duration("wait-timeout", lifecycleEC2.DefaultWaitTimeout, "with --wait: maximum time waited")
+			var force, hibernate *bool
+			if action == lifecycleEC2.ActionStop {
+				force = fs.Bool("force", false, "force the instances to stop, without flushing their file system caches (for instances stuck in the stopping state; --force-max-instances overrides --max-instances)")
+				hibernate = fs.Bool("hibernate", false, "hibernate the instances (they must have been launched with hibernation enabled)")
+			}
+			var guardrails *protection
+			var maxInstances *int
+			var confirmAccount, forceMaxInstances *bool
+			if guarded != nil {
+				guardrails = protectionFlags(fs)
+				maxInstances = fs.Int("max-instances", 50, fmt.Sprintf("refuse to %s more selected instances at once, unless --force-max-instances (0: no limit; not checked for instances given by ID)", action))
+				// like --force of delete (--force of stop is the AWS force stop)
+				forceMaxInstances = fs.Bool("force-max-instances", false, fmt.Sprintf("%s more selected instances than --max-instances", action))
+				confirmAccount = fs.Bool("confirm-account", false, fmt.Sprintf("confirm by typing the AWS account ID, instead of the number of instances to %s", action))
+			}
+
+			return func(args []string) error {
+				if (len(args) > 0) == selection.set() {
+					fs.Usage()
+					return fmt.Errorf("expected either instance IDs or a selection (--name, --tag, --select)")
+				}
+				ec2client, err := a.ec2client()
+				if err != nil {
+					return err
+				}
+				options := lifecycleEC2.Options{
+					Wait:        *wait,
+					WaitTimeout: *waitTimeout,
+					Fleet:       fleet.Options{Concurrency: *concurrency},
+					DryRun:      a.dryRun,
+					Output:      a.messages(),
+				}
+				if force != nil {
+					options.Force = *force
+					options.Hibernate = *hibernate
+				}
+				if guarded != nil && selection.set() {
+					sel, err := selection.selector()
+					if err != nil {
+						return err
+					}
+					if options.Guardrails, err = guardrails.guardrails(); err != nil {
+						return err
+					}
+					options.Guardrails.MaxInstances = *maxInstances
+					options.Guardrails.Force = *forceMaxInstances
+					options.Guardrails.ConfirmAccountID = *confirmAccount
+					// the result is printed even if the operation failed on some instances
+					result, err := guarded(ec2client, sel, a.confirmer(), options)
+					if result != nil {
+						a.print(result)
+					}
+					return err
+				}
+
+				instanceIDs, err := a.selectInstances(ec2client, args, selection, action)
+				if err != nil || len(instanceIDs) == 0 {
+					return err
+				}
+				// the result is printed even if the operation failed on some instances
+				result, err := operation(ec2client, instanceIDs, options)
+				a.print(result)
+				return err
+			}
+		},
+	}
+}
+
+var resizeCmd = &command{
+	name:    lifecycleEC2.ActionResize,
+	args:    "[<instance-id>]",
+	summary: "change the type of an instance (by ID, by name, tag or selector expression), stopping and starting it if it's running",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		selection := selectionFlags(fs, lifecycleEC2.ActionResize)
+		instanceType := fs.String("type", "", "new instance type (ex: t3.large)")
+		waitTimeout := fs.Duration("wait-timeout", lifecycleEC2.DefaultWaitTimeout, "maximum time waited for the instance to be stopped, and then to pass its status checks")
+		noStatusChecks := fs.Bool("no-status-checks", false, "don't wait for the status checks after the start, only for the running state")
+
+		return func(args []string) error {
+			if len(args) > 1 || (len(args) > 0) == selection.set() {
+				fs.Usage()
+				return fmt.Errorf("expected either an instance ID or a selection (--name, --tag, --select)")
+			}
+			if *instanceType == "" {
+				fs.Usage()
+				return fmt.Errorf("--type is required")
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			instanceIDs, err := a.selectInstances(ec2client, args, selection, lifecycleEC2.ActionResize)
+			if err != nil || len(instanceIDs) == 0 {
+				return err
+			}
+			if len(instanceIDs) > 1 {
+				return fmt.Errorf("%d instances selected: resize changes one instance at a time", len(instanceIDs))
+			}
+
+			options := lifecycleEC2.ResizeOptions{WaitTimeout: *waitTimeout, NoStatusChecks: *noStatusChecks, DryRun: a.dryRun, Output: a.messages()}
+			result, err := lifecycleEC2.Resize(ec2client, instanceIDs[0], *instanceType, options)
+			a.print(result)
+			return err
+		}
+	},
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"aws/pkg/describeEC2"
+	"aws/pkg/output"
+	"aws/pkg/selector"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+var listCmd = &command{
+	name:    "list",
+	summary: "list the instances (all, or selected by name, tag or selector expression)",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		selection := selectionFlags(fs, "only list")
+		allStates := fs.Bool("all-states", false, "also list the shutting-down and terminated instances")
+		table := tableFlags(fs, describeEC2.DefaultColumns)
+
+		return func(args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("list takes no arguments")
+			}
+			if err := table.check(); err != nil {
+				return err
+			}
+			sel, err := selection.selector()
+			if err != nil {
+				return err
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			options := selectorOptions(sel)
+			options.IncludeTerminated = *allStates
+			options.SortBy = table.sortBy
+			instances, err := describeEC2.ListInstances(ec2client, options)
+			if err != nil {
+				return err
+			}
+			if len(instances) == 0 {
+				fmt.Fprintln(a.messages(), "No instance found.")
+			}
+			return a.print(table.list(instances))
+		}
+	},
+}
+
+// Flags selecting instances.
+type selection struct {
+	name string
+	tag  string
+	expr string
+}
+
+// Defines the flags --name, --tag and --select, for the commands that
+// do something (verb, ex: "list") on the selected instances.
+func selectionFlags(fs *flag.FlagSet, verb string) *selection {
+	s := &selection{}
+	fs.StringVar(&s.name, "name", "", verb+" the instances with exactly this name (use --select for wildcards)")
+	fs.StringVar(&s.tag, "tag", "", verb+" the instances with exactly this tag (key=value)")
+	fs.StringVar(&s.expr, "select", "", verb+" the instances selected by this expression (ex: tag:env=dev,state=running,launched<7d,name~web-*)")
+	return s
+}
+
+// Returns true if one of the selection flags is set.
+func (s *selection) set() bool {
+	return s.name != "" || s.tag != "" || s.expr != ""
+}
+
+// Returns the selector combining the selection flags
+// (which selects all instances if none is set).
+func (s *selection) selector() (*selector.Selector, error) {
+	sel, err := selector.Parse(s.expr)
+	if err != nil {
+		return nil, err
+	}
+	// the name and the tag are matched exactly, and not parsed as expressions
+	// (a name such as "web,state=stopped" or "web-*" is a name)
+	if s.name != "" {
+		if err := sel.AddExact("name", s.name); err != nil {
+			return nil, err
+		}
+	}
+	if s.tag != "" {
+		key, value, ok := strings.Cut(s.tag, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid tag %q (expected key=value)", s.tag)
+		}
+		if err := sel.AddExact("tag:"+key, value); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// Returns the IDs of the instances given in args, or if args is empty,
+// of the instances selected by the selection flags.
+// The selected instances are shown before the action (verb, ex: "delete") is done on them.
+func (a *app) selectInstances(ec2client *ec2.Client, args []string, s *selection, verb string) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+	sel, err := s.selector()
+	if err != nil {
+		return nil, err
+	}
+	instances, err := describeEC2.ListInstances(ec2client, selectorOptions(sel))
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) == 0 {
+		fmt.Fprintln(a.messages(), "No instance found.")
+		return nil, nil
+	}
+	fmt.Fprintf(a.messages(), "%d instances to %s:\n", len(instances), verb)
+	list := &describeEC2.InstanceList{Instances: instances}
+	header, rows := list.Table()
+	output.PrintTable(a.messages(), header, rows)
+	return list.IDs(), nil
+}
+
+// Returns the options listing the instances selected by sel.
+func selectorOptions(sel *selector.Selector) describeEC2.ListOptions {
+	return describeEC2.ListOptions{Filters: sel.Filters, Match: sel.Match}
+}
+
+// Flags choosing how instance tables are printed.
+type instanceTable struct {
+	columns string
+	sortBy  string
+}
+
+// Defines the flags --columns and --sort, with the columns printed by default.
+func tableFlags(fs *flag.FlagSet, defaultColumns []string) *instanceTable {
+	t := &instanceTable{}
+	var names []string
+	for _, column := range describeEC2.Columns {
+		names = append(names, column.Name)
+	}
+	fs.StringVar(&t.columns, "columns", strings.Join(defaultColumns, ","), "columns of the table, separated by commas (among: "+strings.Join(names, ", ")+")")
+	fs.StringVar(&t.sortBy, "sort", "", "column to sort the instances by (prefix with \"-\" for descending order, ex: -launched)")
+	return t
+}
+
+// Checks that the selected columns exist.
+func (t *instanceTable) check() error {
+	for _, name := range strings.Split(t.columns, ",") {
+		if _, err := describeEC2.FindColumn(name); err != nil {
+			return err
+		}
+	}
+	if t.sortBy != "" {
+		if _, err := describeEC2.FindColumn(strings.TrimPrefix(t.sortBy, "-")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *instanceTable) list(instances []describeEC2.Instance) *describeEC2.InstanceList {
+	return &describeEC2.InstanceList{Instances: instances, Columns: strings.Split(t.columns, ",")}
+}
+
+var describeCmd = &command{
+	name:    "describe",
+	args:    "<instance-id>...",
+	summary: "print the details of instances",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		// describe prints all the columns by default
+		var all []string
+		for _, column := range describeEC2.Columns {
+			all = append(all, column.Name)
+		}
+		table := tableFlags(fs, all)
+
+		return func(args []string) error {
+			if len(args) == 0 {
+				fs.Usage()
+				return fmt.Errorf("expected at least one instance ID")
+			}
+			if err := table.check(); err != nil {
+				return err
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			instances, err := describeEC2.ListInstances(ec2client, describeEC2.ListOptions{
+				InstanceIDs:       args,
+				IncludeTerminated: true,
+				SortBy:            table.sortBy,
+			})
+			if err != nil {
+				return err
+			}
+			return a.print(table.list(instances))
+		}
+	},
+}
@@ -0,0 +1,269 @@
+/*
+ec2ctl launches, lists and deletes EC2 instances from the command line.
+
+Usage:
+
+	ec2ctl [global flags] <command> [flags] [arguments]
+
+Run "ec2ctl help" to list the commands.
+*/
+package main
+
+import (
+	"aws/pkg/confirm"
+	"aws/pkg/fleet"
+	"aws/pkg/output"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// A subcommand of the CLI.
+type command struct {
+	name    string
+	args    string // usage of the positional arguments (ex: "<instance-id>...")
+	summary string
+	// setup defines the command flags in fs,
+	// and returns the function running the command with the positional arguments.
+	setup func(a *app, fs *flag.FlagSet) func(args []string) error
+}
+
+// All the subcommands, in the order they're printed in the help.
+// (set in init, as the completion command reads this list)
+var commands []*command
+
+func init() {
+	commands = []*command{
+		launchCmd,
+		listCmd,
+		describeCmd,
+		deleteCmd,
+		teardownCmd,
+		stopCmd,
+		startCmd,
+		rebootCmd,
+		resizeCmd,
+		scheduleCmd,
+		auditCmd,
+		reapCmd,
+		extendCmd,
+		tagCmd,
+		eipCmd,
+		imageCmd,
+		snapshotCmd,
+		backupCmd,
+		sgCmd,
+		keyCmd,
+		waitCmd,
+		sshCmd,
+		execCmd,
+		cpCmd,
+		completionCmd,
+	}
+}
+
+// Global state of the CLI: global flags and the EC2 client.
+type app struct {
+	profile string
+	region  string
+	output  string
+	yes     bool
+	dryRun  bool
+
+	format output.Format // parsed from --output
+	client *ec2.Client
+}
+
+// Defines the global flags in fs. The current values are used as defaults,
+// so global flags can be given before or after the command name.
+func (a *app) globalFlags(fs *flag.FlagSet) {
+	fs.StringVar(&a.profile, "profile", a.profile, "AWS profile to use (from ~/.aws/config)")
+	fs.StringVar(&a.region, "region", a.region, "AWS region to use (ex: eu-west-3)")
+	fs.StringVar(&a.output, "output", a.output, "output format: "+output.FormatNames())
+	fs.BoolVar(&a.yes, "yes", a.yes, "answer yes to every confirmation")
+	fs.BoolVar(&a.dryRun, "dry-run", a.dryRun, "print what would be done, without doing it (AWS still checks the permissions)")
+}
+
+// Defines the flag --concurrency, for the commands acting on multiple instances at the same time.
+func concurrencyFlag(fs *flag.FlagSet) *int {
+	return fs.Int("concurrency", fleet.DefaultConcurrency, "maximum number of requests sent at the same time")
+}
+
+// A flag that can be given multiple times (ex: --set a=1 --set b=2).
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Returns the EC2 client, created at first use with the --profile and --region flags.
+func (a *app) ec2client() (*ec2.Client, error) {
+	if a.client != nil {
+		return a.client, nil
+	}
+	// loads AWS user configuration from the files ~/.aws/config (to retrieve the AWS region)
+	// and ~/.aws/credentials (to retrieve the user AWS access key)
+	var opts []func(*config.LoadOptions) error
+	if a.profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(a.profile))
+	}
+	if a.region != "" {
+		opts = append(opts, config.WithRegion(a.region))
+	}
+	cfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load AWS configuration: %w", err)
+	}
+	a.client = ec2.NewFromConfig(cfg)
+	return a.client, nil
+}
+
+// Returns the confirmer answering the questions asked by the commands:
+// always yes with --yes, else the user is asked.
+func (a *app) confirmer() confirm.Confirmer {
+	if a.yes {
+		return confirm.AutoYes{}
+	}
+	return &confirm.Terminal{In: os.Stdin, Out: a.messages()}
+}
+
+// Returns where the messages for the user are printed: stdout,
+// or stderr if stdout is kept for the results (JSON, YAML, IDs).
+func (a *app) messages() io.Writer {
+	if a.format.Machine() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// Prints the result of a command on stdout, in the --output format.
+func (a *app) print(result any) error {
+	return output.Print(os.Stdout, a.format, result)
+}
+
+// Parses the flags of fs, which can be mixed with the positional arguments,
+// and returns the positional arguments. The arguments after "--" are all positional
+// (ex: ssh web -- ls -l).
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		args = rest
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// Returns the flag set of the command c, with its flags and the global flags defined.
+func (a *app) flagSet(c *command) (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet("ec2ctl "+c.name, flag.ContinueOnError)
+	run := c.setup(a, fs)
+	a.globalFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: ec2ctl %s [flags] %s\n\n%s\n\nFlags:\n", c.name, c.args, c.summary)
+		fs.PrintDefaults()
+	}
+	return fs, run
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "ec2ctl launches, lists and deletes EC2 instances.")
+	fmt.Fprintln(w, "\nUsage: ec2ctl [global flags] <command> [flags] [arguments]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w, "\nGlobal flags:")
+	fs := flag.NewFlagSet("ec2ctl", flag.ContinueOnError)
+	fs.SetOutput(w)
+	(&app{output: string(output.Table)}).globalFlags(fs)
+	fs.PrintDefaults()
+	fmt.Fprintln(w, "\nRun \"ec2ctl help <command>\" for the flags of a command.")
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("ec2ctl: ")
+
+	a := &app{output: string(output.Table)}
+
+	// parses the global flags given before the command name
+	root := flag.NewFlagSet("ec2ctl", flag.ContinueOnError)
+	a.globalFlags(root)
+	root.Usage = func() { usage(os.Stderr) }
+	if err := root.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		os.Exit(2)
+	}
+	if root.NArg() == 0 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	name, args := root.Arg(0), root.Args()[1:]
+	if name == "help" {
+		if len(args) > 0 && findCommand(args[0]) != nil {
+			fs, _ := a.flagSet(findCommand(args[0]))
+			fs.SetOutput(os.Stdout)
+			fs.Usage()
+			return
+		}
+		usage(os.Stdout)
+		return
+	}
+	c := findCommand(name)
+	if c == nil {
+		log.Printf("unknown command %q", name)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	// parses the command flags (and global flags given after the command name)
+	fs, run := a.flagSet(c)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return
+		}
+		os.Exit(2)
+	}
+	a.format, err = output.ParseFormat(a.output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(args); err != nil {
+		log.Fatal(err)
+	}
+}
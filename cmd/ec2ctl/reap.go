@@ -0,0 +1,95 @@
+package main
+
+import (
+	"aws/pkg/expiry"
+	"aws/pkg/reapEC2"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+var reapCmd = &command{
+	name:    "reap",
+	summary: "stop or terminate the expired instances (launched with --ttl, or tagged expires-at or ttl)",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		action := fs.String("action", reapEC2.ActionStop, "what is done to the expired instances: stop or terminate")
+		webhook := fs.String("webhook", "", "URL receiving a warning before the instances are stopped or terminated")
+		warnBefore := fs.Duration("warn-before", time.Hour, "with --webhook: send the warning this long before the expiry")
+		interval := fs.Duration("interval", 0, "run as a daemon, reaping the instances at this interval (ex: 5m)")
+		protection := protectionFlags(fs)
+
+		return func(args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("reap takes no arguments")
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			guardrails, err := protection.guardrails()
+			if err != nil {
+				return err
+			}
+			options := reapEC2.Options{
+				Action:     *action,
+				Webhook:    *webhook,
+				WarnBefore: *warnBefore,
+				Guardrails: guardrails,
+				DryRun:     a.dryRun,
+				Output:     a.messages(),
+			}
+
+			if *interval <= 0 {
+				result, err := reapEC2.Reap(ec2client, options)
+				if result != nil {
+					a.print(result)
+				}
+				return err
+			}
+			// daemon mode: the errors are logged, and the reaper runs again
+			for {
+				result, err := reapEC2.Reap(ec2client, options)
+				if result != nil && len(result.Instances) > 0 {
+					a.print(result)
+				}
+				if err != nil {
+					log.Print(err)
+				}
+				time.Sleep(*interval)
+			}
+		}
+	},
+}
+
+var extendCmd = &command{
+	name:    "extend",
+	args:    "[<instance-id>...]",
+	summary: "extend the time to live of instances (by ID, by name, tag or selector expression)",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		selection := selectionFlags(fs, "extend")
+		by := fs.String("by", "8h", "time added to the expiry date of the instances (ex: 4h, 2d)")
+
+		return func(args []string) error {
+			if (len(args) > 0) == selection.set() {
+				fs.Usage()
+				return fmt.Errorf("expected either instance IDs or a selection (--name, --tag, --select)")
+			}
+			ttl, err := expiry.ParseTTL(*by)
+			if err != nil {
+				return err
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			instanceIDs, err := a.selectInstances(ec2client, args, selection, "extend")
+			if err != nil || len(instanceIDs) == 0 {
+				return err
+			}
+			result, err := reapEC2.Extend(ec2client, instanceIDs, ttl, reapEC2.Options{DryRun: a.dryRun, Output: a.messages()})
+			a.print(result)
+			return err
+		}
+	},
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"aws/pkg/fleet"
+	"aws/pkg/lifecycleEC2"
+	"aws/pkg/scheduleEC2"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+var scheduleCmd = &command{
+	name:    "schedule",
+	summary: "start and stop the instances tagged schedule (ex: schedule=weekdays 08:00-19:00 Europe/Paris) according to their schedule",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		at := fs.String("at", "", "evaluate the schedules at this time (RFC 3339, ex: 2024-03-15T19:00:00+01:00) instead of now, to preview the transitions (requires --dry-run)")
+		interval := fs.Duration("interval", 0, "run as a daemon, reconciling the instances at this interval (ex: 5m)")
+		concurrency := concurrencyFlag(fs)
+
+		return func(args []string) error {
+			if len(args) > 0 {
+				return fmt.Errorf("schedule takes no arguments")
+			}
+			options := scheduleEC2.Options{Lifecycle: lifecycleEC2.Options{Fleet: fleet.Options{Concurrency: *concurrency}}, DryRun: a.dryRun, Output: a.messages()}
+			if *at != "" {
+				if *interval > 0 {
+					return fmt.Errorf("--at and --interval can't be used together")
+				}
+				// the instances would really be started and stopped at the wrong time
+				if !a.dryRun {
+					return fmt.Errorf("--at can only be used with --dry-run")
+				}
+				t, err := time.Parse(time.RFC3339, *at)
+				if err != nil {
+					return fmt.Errorf("invalid --at %q (expected RFC 3339, ex: 2024-03-15T19:00:00Z): %w", *at, err)
+				}
+				options.Now = func() time.Time { return t }
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+
+			if *interval <= 0 {
+				result, err := scheduleEC2.Reconcile(ec2client, options)
+				if result != nil {
+					a.print(result)
+				}
+				return err
+			}
+			// daemon mode: the errors are logged, and the scheduler runs again
+			for {
+				result, err := scheduleEC2.Reconcile(ec2client, options)
+				if result != nil {
+					a.print(result)
+				}
+				if err != nil {
+					log.Print(err)
+				}
+				time.Sleep(*interval)
+			}
+		}
+	},
+}
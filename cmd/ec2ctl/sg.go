@@ -0,0 +1,30 @@
+package main
+
+import (
+	"aws/pkg/launchEC2"
+	"flag"
+	"fmt"
+)
+
+var sgCmd = &command{
+	name:    "sg",
+	args:    "create <name>",
+	summary: "create a security group allowing SSH (port 22) and TCP port 8080",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		return func(args []string) error {
+			if len(args) != 2 || args[0] != "create" {
+				fs.Usage()
+				return fmt.Errorf("expected \"sg create <name>\"")
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			result, err := launchEC2.ConfigureSecurityGroup(ec2client, args[1], launchEC2.Options{DryRun: a.dryRun, Output: a.messages()})
+			if err != nil {
+				return err
+			}
+			return a.print(result)
+		}
+	},
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"aws/pkg/sshEC2"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var sshCmd = &command{
+	name:    "ssh",
+	args:    "<instance-id|name> [-- command...]",
+	summary: "open an interactive SSH session on an instance, or run a command on it and exit with its exit code (the key <key>.pem and the login user of the AMI are chosen automatically)",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		ssh := sshFlags(fs)
+
+		return func(args []string) error {
+			if len(args) == 0 {
+				fs.Usage()
+				return fmt.Errorf("expected an instance ID or name")
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			options := ssh.options()
+			options.Output = a.messages()
+			target, err := sshEC2.Resolve(ec2client, args[0], options)
+			if err != nil {
+				return err
+			}
+			command := strings.Join(args[1:], " ")
+			if a.dryRun {
+				if command == "" {
+					fmt.Fprintf(a.messages(), "Dry run: would open a shell on %s (instance %s, port %d) with key %s\n", target, target.InstanceID, target.Port, target.KeyFile)
+				} else {
+					fmt.Fprintf(a.messages(), "Dry run: would run %q on %s (instance %s, port %d) with key %s\n", command, target, target.InstanceID, target.Port, target.KeyFile)
+				}
+				return nil
+			}
+
+			client, err := sshEC2.Dial(target, options)
+			if err != nil {
+				return err
+			}
+			var code int
+			if command == "" {
+				code, err = sshEC2.Shell(client)
+			} else {
+				code, err = sshEC2.Run(client, command, os.Stdin, os.Stdout, os.Stderr)
+			}
+			client.Close()
+			if err != nil {
+				return err
+			}
+			// propagates the exit code of the remote command
+			if code != 0 {
+				os.Exit(code)
+			}
+			return nil
+		}
+	},
+}
+
+// Flags of the commands connecting to instances with SSH.
+type sshOptions struct {
+	user       *string
+	keyDir     *string
+	port       *int
+	privateIP  *bool
+	knownHosts *string
+	insecure   *bool
+	timeout    *time.Duration
+}
+
+func sshFlags(fs *flag.FlagSet) *sshOptions {
+	o := &sshOptions{}
+	o.user = fs.String("user", "", "login user on the instances (default: depends on the AMI: ec2-user, ubuntu, admin...)")
+	o.keyDir = fs.String("key-dir", ".", "directory containing the .pem key files")
+	o.port = fs.Int("port", 22, "SSH port of the instances")
+	o.privateIP = fs.Bool("private-ip", false, "connect to the private IP of the instances (ex: from the same VPC)")
+	o.knownHosts = fs.String("known-hosts", "", "known host keys file; the keys of new hosts are added to it (default: ~/.ssh/known_hosts)")
+	o.insecure = fs.Bool("insecure-ignore-host-key", false, "don't check the host keys of the instances")
+	o.timeout = fs.Duration("connect-timeout", sshEC2.DefaultTimeout, "timeout of the SSH connection")
+	return o
+}
+
+func (o *sshOptions) options() sshEC2.Options {
+	return sshEC2.Options{
+		User:                  *o.user,
+		KeyDir:                *o.keyDir,
+		Port:                  *o.port,
+		PrivateIP:             *o.privateIP,
+		KnownHosts:            *o.knownHosts,
+		InsecureIgnoreHostKey: *o.insecure,
+		Timeout:               *o.timeout,
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"aws/pkg/fleet"
+	"aws/pkg/tagEC2"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var tagCmd = &command{
+	name:    "tag",
+	args:    "[<instance-id>...]",
+	summary: "add or remove tags on instances (by ID, by name, tag or selector expression)",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		selection := selectionFlags(fs, "tag")
+		var set, remove stringList
+		fs.Var(&set, "set", "tag to add or replace (key=value), can be given multiple times")
+		fs.Var(&remove, "remove", "key of a tag to remove, can be given multiple times")
+		concurrency := concurrencyFlag(fs)
+
+		return func(args []string) error {
+			if (len(args) > 0) == selection.set() {
+				fs.Usage()
+				return fmt.Errorf("expected either instance IDs or a selection (--name, --tag, --select)")
+			}
+			if len(set) == 0 && len(remove) == 0 {
+				return fmt.Errorf("expected tags to add (--set) or remove (--remove)")
+			}
+			tags, err := parseTags(set)
+			if err != nil {
+				return err
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+
+			instanceIDs, err := a.selectInstances(ec2client, args, selection, "tag")
+			if err != nil || len(instanceIDs) == 0 {
+				return err
+			}
+			// the result is printed even if some instances couldn't be tagged
+			result, err := tagEC2.TagInstances(ec2client, instanceIDs, tags, remove, tagEC2.Options{Fleet: fleet.Options{Concurrency: *concurrency}, DryRun: a.dryRun, Output: a.messages()})
+			a.print(result)
+			return err
+		}
+	},
+}
+
+// Parses tags given as key=value.
+func parseTags(list stringList) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, tag := range list {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tag %q (expected key=value)", tag)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"aws/pkg/teardownEC2"
+	"flag"
+	"fmt"
+)
+
+var teardownCmd = &command{
+	name:    "teardown",
+	summary: "delete instances and the resources they use (Elastic IPs, volumes, security groups, key pairs)",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		selection := selectionFlags(fs, "tear down")
+		flags := deletionFlags(fs)
+		removeKeyFiles := fs.Bool("remove-key-files", false, "also remove the local files of the deleted key pairs (<key>.pem)")
+		keyDir := fs.String("key-dir", ".", "directory of the key files")
+		plan := fs.Bool("plan", false, "only print the resources that would be deleted")
+
+		return func(args []string) error {
+			if len(args) > 0 || !selection.set() {
+				fs.Usage()
+				return fmt.Errorf("expected a selection (--name, --tag, --select)")
+			}
+			sel, err := selection.selector()
+			if err != nil {
+				return err
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			deleteOptions, err := flags.options()
+			if err != nil {
+				return err
+			}
+			options := teardownEC2.Options{Delete: deleteOptions, RemoveKeyFiles: *removeKeyFiles, KeyDir: *keyDir, DryRun: a.dryRun, Output: a.messages()}
+
+			if *plan {
+				resources, err := teardownEC2.FindResources(ec2client, sel, options)
+				if err != nil {
+					return err
+				}
+				return a.print(resources)
+			}
+			result, err := teardownEC2.Teardown(ec2client, sel, flags.confirmer(a), options)
+			// the result is printed even if some resources couldn't be deleted
+			if result != nil {
+				a.print(result)
+			}
+			return err
+		}
+	},
+}
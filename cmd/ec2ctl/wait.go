@@ -0,0 +1,68 @@
+package main
+
+import (
+	"aws/pkg/fleet"
+	"aws/pkg/launchEC2"
+	"flag"
+	"fmt"
+)
+
+var waitCmd = &command{
+	name:    "wait",
+	args:    "<instance-id>...",
+	summary: "wait until instances have a public IP, and print them",
+	setup: func(a *app, fs *flag.FlagSet) func(args []string) error {
+		concurrency := concurrencyFlag(fs)
+
+		return func(args []string) error {
+			if len(args) == 0 {
+				fs.Usage()
+				return fmt.Errorf("expected at least one instance ID")
+			}
+			ec2client, err := a.ec2client()
+			if err != nil {
+				return err
+			}
+			results, err := launchEC2.GetPublicIPs(ec2client, args, fleet.Options{Concurrency: *concurrency}, a.messages())
+			ips := publicIPs{}
+			for _, result := range results {
+				ip := publicIP{InstanceID: result.Item, PublicIP: result.Value}
+				if result.Err != nil {
+					ip.Error = result.Err.Error()
+				}
+				ips = append(ips, ip)
+			}
+			// the IPs are printed even if some couldn't be retrieved
+			a.print(ips)
+			return err
+		}
+	},
+}
+
+// Result of the wait command for one instance.
+type publicIP struct {
+	InstanceID string `json:"instance_id" yaml:"instance_id"`
+	PublicIP   string `json:"public_ip,omitempty" yaml:"public_ip,omitempty"`
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type publicIPs []publicIP
+
+func (l publicIPs) Table() ([]string, [][]string) {
+	rows := make([][]string, len(l))
+	for i, ip := range l {
+		rows[i] = []string{ip.InstanceID, ip.PublicIP, ip.Error}
+	}
+	return []string{"INSTANCE ID", "PUBLIC IP", "ERROR"}, rows
+}
+
+// Returns the IPs, to be used by scripts (ex: ssh ec2-user@$(ec2ctl wait --output ids i-...)).
+func (l publicIPs) IDs() []string {
+	var ips []string
+	for _, ip := range l {
+		if ip.PublicIP != "" {
+			ips = append(ips, ip.PublicIP)
+		}
+	}
+	return ips
+}
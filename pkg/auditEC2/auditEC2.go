@@ -0,0 +1,321 @@
+package auditEC2
+
+import (
+	"aws/pkg/describeEC2"
+	"aws/pkg/teardownEC2"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Estimated monthly price of one GB of EBS volume, by volume type (USD, us-east-1).
+// The prices of the other regions are close, and the IOPS/throughput are not counted.
+var VolumePrices = map[types.VolumeType]float64{
+	types.VolumeTypeGp2:      0.10,
+	types.VolumeTypeGp3:      0.08,
+	types.VolumeTypeIo1:      0.125,
+	types.VolumeTypeIo2:      0.125,
+	types.VolumeTypeSt1:      0.045,
+	types.VolumeTypeSc1:      0.015,
+	types.VolumeTypeStandard: 0.05,
+}
+
+// Estimated monthly price of an Elastic IP (USD: 0.005 per hour).
+var ElasticIPPrice = 0.005 * 730
+
+// An unused resource found by Audit.
+type Finding struct {
+	Type        string     `json:"type" yaml:"type"` // see the resource types of package teardownEC2
+	ID          string     `json:"id" yaml:"id"`
+	Name        string     `json:"name,omitempty" yaml:"name,omitempty"`
+	Reason      string     `json:"reason" yaml:"reason"`
+	Since       *time.Time `json:"since,omitempty" yaml:"since,omitempty"` // creation (or stop) date, if known
+	Age         string     `json:"age,omitempty" yaml:"age,omitempty"`
+	MonthlyCost float64    `json:"monthly_cost" yaml:"monthly_cost"` // estimated, in USD
+}
+
+// Result of Audit.
+type AuditResult struct {
+	Findings         []Finding `json:"findings" yaml:"findings"`
+	TotalMonthlyCost float64   `json:"total_monthly_cost" yaml:"total_monthly_cost"`
+}
+
+func (r *AuditResult) Table() ([]string, [][]string) {
+	rows := make([][]string, len(r.Findings))
+	for i, f := range r.Findings {
+		rows[i] = []string{f.Type, f.ID, f.Name, f.Reason, f.Age, fmt.Sprintf("$%.2f", f.MonthlyCost)}
+	}
+	return []string{"TYPE", "ID", "NAME", "REASON", "AGE", "MONTHLY COST"}, rows
+}
+
+func (r *AuditResult) IDs() []string {
+	ids := make([]string, len(r.Findings))
+	for i, f := range r.Findings {
+		ids[i] = f.ID
+	}
+	return ids
+}
+
+// Returns the plan deleting the unused resources (see teardownEC2.DeleteResources).
+func (r *AuditResult) Plan() *teardownEC2.Plan {
+	plan := &teardownEC2.Plan{}
+	for _, f := range r.Findings {
+		plan.Resources = append(plan.Resources, teardownEC2.Resource{Type: f.Type, ID: f.ID, Name: f.Name})
+	}
+	return plan
+}
+
+// Options of an audit. The zero value uses the defaults.
+type AuditOptions struct {
+	// stopped instances are reported if they're stopped for longer (default: 30 days)
+	StoppedFor time.Duration
+	// returns the current time (default: time.Now)
+	Now func() time.Time
+}
+
+// Finds the resources of the account that are probably unused: security groups
+// attached to no network interface, key pairs used by no instance, unattached EBS
+// volumes, unassociated Elastic IPs and instances stopped for a long time.
+// Each resource is reported with its age (when AWS gives it) and its estimated monthly cost.
+func Audit(ec2client *ec2.Client, options AuditOptions) (*AuditResult, error) {
+	if options.StoppedFor <= 0 {
+		options.StoppedFor = 30 * 24 * time.Hour
+	}
+	now := time.Now()
+	if options.Now != nil {
+		now = options.Now()
+	}
+	a := &auditor{ec2client: ec2client, now: now, result: &AuditResult{Findings: []Finding{}}}
+
+	steps := []func() error{
+		func() error { return a.instances(options.StoppedFor) },
+		a.volumes,
+		a.addresses,
+		a.securityGroups,
+		a.keyPairs,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range a.result.Findings {
+		a.result.TotalMonthlyCost += f.MonthlyCost
+	}
+	return a.result, nil
+}
+
+// State of an audit.
+type auditor struct {
+	ec2client *ec2.Client
+	now       time.Time
+	result    *AuditResult
+
+	keyNames   map[string]bool           // key pairs used by non-terminated instances
+	attached   map[string][]types.Volume // volumes attached to each instance
+	unattached []types.Volume
+}
+
+func (a *auditor) add(f Finding) {
+	if f.Since != nil {
+		f.Age = formatAge(a.now.Sub(*f.Since))
+	}
+	a.result.Findings = append(a.result.Findings, f)
+}
+
+// Returns the duration in days (ex: "12d"), or hours if it's less than a day.
+func formatAge(d time.Duration) string {
+	if d < 24*time.Hour {
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// Returns the estimated monthly cost of a volume.
+func volumeCost(volume types.Volume) float64 {
+	if volume.Size == nil {
+		return 0
+	}
+	return float64(*volume.Size) * VolumePrices[volume.VolumeType]
+}
+
+// Date in the state transition reason of a stopped instance
+// (ex: "User initiated (2024-01-02 15:04:05 GMT)").
+var stopDate = regexp.MustCompile(`\((\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) GMT\)`)
+
+// Returns when the instance was stopped, or its launch time if unknown.
+func stoppedSince(instance types.Instance) *time.Time {
+	if instance.StateTransitionReason != nil {
+		if match := stopDate.FindStringSubmatch(*instance.StateTransitionReason); match != nil {
+			if date, err := time.Parse("2006-01-02 15:04:05", match[1]); err == nil {
+				return &date
+			}
+		}
+	}
+	return instance.LaunchTime
+}
+
+// Finds the volumes (fetching all the volumes of the account),
+// then the instances stopped for longer than stoppedFor.
+func (a *auditor) instances(stoppedFor time.Duration) error {
+	a.attached = make(map[string][]types.Volume)
+	paginator := ec2.NewDescribeVolumesPaginator(a.ec2client, &ec2.DescribeVolumesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return fmt.Errorf("error fetching volumes info: %w", err)
+		}
+		for _, volume := range page.Volumes {
+			if len(volume.Attachments) == 0 {
+				a.unattached = append(a.unattached, volume)
+			}
+			for _, attachment := range volume.Attachments {
+				if attachment.InstanceId != nil {
+					a.attached[*attachment.InstanceId] = append(a.attached[*attachment.InstanceId], volume)
+				}
+			}
+		}
+	}
+
+	a.keyNames = make(map[string]bool)
+	return describeEC2.EachInstance(a.ec2client, &ec2.DescribeInstancesInput{}, func(instance types.Instance) error {
+		if describeEC2.IsTerminated(instance) {
+			return nil
+		}
+		if instance.KeyName != nil {
+			a.keyNames[*instance.KeyName] = true
+		}
+		if instance.State == nil || instance.State.Name != types.InstanceStateNameStopped {
+			return nil
+		}
+		since := stoppedSince(instance)
+		if since == nil || a.now.Sub(*since) < stoppedFor {
+			return nil
+		}
+		// a stopped instance costs the storage of its volumes
+		cost := 0.0
+		for _, volume := range a.attached[*instance.InstanceId] {
+			cost += volumeCost(volume)
+		}
+		a.add(Finding{
+			Type:        teardownEC2.Instance,
+			ID:          *instance.InstanceId,
+			Name:        describeEC2.NewInstance(instance).Name,
+			Reason:      "stopped",
+			Since:       since,
+			MonthlyCost: cost,
+		})
+		return nil
+	})
+}
+
+// Reports the volumes attached to no instance (found by instances).
+func (a *auditor) volumes() error {
+	for _, volume := range a.unattached {
+		name := ""
+		for _, tag := range volume.Tags {
+			if tag.Key != nil && *tag.Key == "Name" && tag.Value != nil {
+				name = *tag.Value
+			}
+		}
+		a.add(Finding{
+			Type:        teardownEC2.Volume,
+			ID:          *volume.VolumeId,
+			Name:        name,
+			Reason:      fmt.Sprintf("unattached (%s)", volume.VolumeType),
+			Since:       volume.CreateTime,
+			MonthlyCost: volumeCost(volume),
+		})
+	}
+	return nil
+}
+
+// Reports the Elastic IPs associated to nothing.
+func (a *auditor) addresses() error {
+	describeOutput, err := a.ec2client.DescribeAddresses(context.TODO(), &ec2.DescribeAddressesInput{})
+	if err != nil {
+		return fmt.Errorf("error fetching Elastic IPs info: %w", err)
+	}
+	for _, address := range describeOutput.Addresses {
+		if address.AssociationId != nil || address.AllocationId == nil {
+			continue
+		}
+		a.add(Finding{
+			Type:        teardownEC2.ElasticIP,
+			ID:          *address.AllocationId,
+			Name:        *address.PublicIp,
+			Reason:      "not associated",
+			MonthlyCost: ElasticIPPrice,
+		})
+	}
+	return nil
+}
+
+// Reports the security groups attached to no network interface
+// (except the default groups of the VPCs, which can't be deleted).
+func (a *auditor) securityGroups() error {
+	used := make(map[string]bool)
+	interfaces := ec2.NewDescribeNetworkInterfacesPaginator(a.ec2client, &ec2.DescribeNetworkInterfacesInput{})
+	for interfaces.HasMorePages() {
+		page, err := interfaces.NextPage(context.TODO())
+		if err != nil {
+			return fmt.Errorf("error fetching network interfaces info: %w", err)
+		}
+		for _, networkInterface := range page.NetworkInterfaces {
+			for _, group := range networkInterface.Groups {
+				if group.GroupId != nil {
+					used[*group.GroupId] = true
+				}
+			}
+		}
+	}
+
+	var unused []types.SecurityGroup
+	groups := ec2.NewDescribeSecurityGroupsPaginator(a.ec2client, &ec2.DescribeSecurityGroupsInput{})
+	for groups.HasMorePages() {
+		page, err := groups.NextPage(context.TODO())
+		if err != nil {
+			return fmt.Errorf("error fetching security groups info: %w", err)
+		}
+		for _, group := range page.SecurityGroups {
+			if !used[*group.GroupId] && *group.GroupName != "default" {
+				unused = append(unused, group)
+			}
+		}
+	}
+	sort.Slice(unused, func(i, j int) bool { return *unused[i].GroupName < *unused[j].GroupName })
+	for _, group := range unused {
+		a.add(Finding{
+			Type:   teardownEC2.SecurityGroup,
+			ID:     *group.GroupId,
+			Name:   *group.GroupName,
+			Reason: "attached to no network interface",
+		})
+	}
+	return nil
+}
+
+// Reports the key pairs used by no instance (found by instances).
+func (a *auditor) keyPairs() error {
+	describeOutput, err := a.ec2client.DescribeKeyPairs(context.TODO(), &ec2.DescribeKeyPairsInput{})
+	if err != nil {
+		return fmt.Errorf("error fetching key pairs info: %w", err)
+	}
+	for _, key := range describeOutput.KeyPairs {
+		if key.KeyName == nil || a.keyNames[*key.KeyName] {
+			continue
+		}
+		a.add(Finding{
+			Type:   teardownEC2.KeyPair,
+			ID:     *key.KeyName,
+			Reason: "used by no instance",
+			Since:  key.CreateTime,
+		})
+	}
+	return nil
+}
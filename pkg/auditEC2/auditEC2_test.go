@@ -0,0 +1,51 @@
+package auditEC2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestStoppedSince(t *testing.T) {
+	launched := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		instance types.Instance
+		expected *time.Time
+	}{
+		{"stop date", types.Instance{StateTransitionReason: aws.String("User initiated (2024-01-02 15:04:05 GMT)"), LaunchTime: &launched}, aws.Time(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC))},
+		{"no stop date", types.Instance{StateTransitionReason: aws.String("Server.ScheduledStop"), LaunchTime: &launched}, &launched},
+		{"invalid stop date", types.Instance{StateTransitionReason: aws.String("User initiated (2024-13-02 15:04:05 GMT)"), LaunchTime: &launched}, &launched},
+		{"no reason", types.Instance{LaunchTime: &launched}, &launched},
+		{"nothing", types.Instance{}, nil},
+	}
+	for _, test := range tests {
+		since := stoppedSince(test.instance)
+		switch {
+		case since == nil && test.expected == nil:
+		case since == nil || test.expected == nil || !since.Equal(*test.expected):
+			t.Errorf("%s: stoppedSince() = %v, expected %v", test.name, since, test.expected)
+		}
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		age      time.Duration
+		expected string
+	}{
+		{0, "0h"},
+		{90 * time.Minute, "1h"},
+		{23*time.Hour + 59*time.Minute, "23h"},
+		{24 * time.Hour, "1d"},
+		{47 * time.Hour, "1d"},
+		{30 * 24 * time.Hour, "30d"},
+	}
+	for _, test := range tests {
+		if age := formatAge(test.age); age != test.expected {
+			t.Errorf("formatAge(%s) = %q, expected %q", test.age, age, test.expected)
+		}
+	}
+}
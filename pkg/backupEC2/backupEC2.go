@@ -0,0 +1,458 @@
+package backupEC2
+
+import (
+	"aws/pkg/describeEC2"
+	"aws/pkg/dryrun"
+	"aws/pkg/retention"
+	"aws/pkg/selector"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"gopkg.in/yaml.v3"
+)
+
+// Tag of the snapshots created by a policy, whose value is the name of the policy.
+// Only the snapshots with this tag are deleted by the policy.
+const PolicyTag = "retention-policy"
+
+// A retention policy: the volumes it snapshots, and the snapshots it keeps.
+type Policy struct {
+	Name string `yaml:"name"`
+	// selector expression of the instances whose volumes are snapshotted (see package selector)
+	Select string `yaml:"select,omitempty"`
+	// tag of the volumes snapshotted (key=value), besides the volumes of the instances
+	Volumes string `yaml:"volumes,omitempty"`
+	// retention rules, ex: "7 daily, 4 weekly, 6 monthly" (see package retention)
+	Keep string `yaml:"keep"`
+
+	sel   *selector.Selector
+	rules retention.Rules
+}
+
+// Returns the policy of name, instance selector, volume tag and rules given in parameter
+// (see Policy).
+func NewPolicy(name string, sel string, volumes string, keep string) (*Policy, error) {
+	policy := &Policy{Name: name, Select: sel, Volumes: volumes, Keep: keep}
+	if err := policy.compile(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Reads the policies of a YAML file:
+//
+//	policies:
+//	  - name: web
+//	    select: tag:env=prod
+//	    keep: 7 daily, 4 weekly, 6 monthly
+//	  - name: data
+//	    volumes: backup=daily
+//	    keep: 14 daily
+func LoadPolicies(path string) ([]*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read policy file: %w", err)
+	}
+	var file struct {
+		Policies []*Policy `yaml:"policies"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	if len(file.Policies) == 0 {
+		return nil, fmt.Errorf("invalid policy file %s: no policies", path)
+	}
+	names := make(map[string]bool)
+	for _, policy := range file.Policies {
+		if err := policy.compile(); err != nil {
+			return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+		}
+		if names[policy.Name] {
+			return nil, fmt.Errorf("invalid policy file %s: policy %q defined twice", path, policy.Name)
+		}
+		names[policy.Name] = true
+	}
+	return file.Policies, nil
+}
+
+// Parses the selector and the rules of the policy.
+func (p *Policy) compile() error {
+	if p.Name == "" {
+		return fmt.Errorf("policy without name")
+	}
+	if p.Select == "" && p.Volumes == "" {
+		return fmt.Errorf("policy %q: expected the instances (select) or the volumes (volumes) to snapshot", p.Name)
+	}
+	if p.Volumes != "" && !strings.Contains(p.Volumes, "=") {
+		return fmt.Errorf("policy %q: invalid volumes %q (expected a tag key=value)", p.Name, p.Volumes)
+	}
+	var err error
+	if p.Select != "" {
+		if p.sel, err = selector.Parse(p.Select); err != nil {
+			return fmt.Errorf("policy %q: %w", p.Name, err)
+		}
+	}
+	if p.rules, err = retention.ParseRules(p.Keep); err != nil {
+		return fmt.Errorf("policy %q: %w", p.Name, err)
+	}
+	return nil
+}
+
+// Options of Run. The zero value uses the defaults.
+type Options struct {
+	// if true, no snapshot is created: the old snapshots are only pruned
+	NoCreate bool
+	// returns the current time (default: time.Now)
+	Now func() time.Time
+	// if true, the requests creating and deleting snapshots are sent with DryRun=true:
+	// AWS checks the permissions without changing anything, and the report tells what
+	// would be created, kept and deleted (see package dryrun)
+	DryRun bool
+	// writer where the progress messages are printed (default: os.Stdout; set it
+	// to os.Stderr to keep stdout for the results, or to io.Discard)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// What a policy did to one snapshot.
+type SnapshotResult struct {
+	Policy     string `json:"policy" yaml:"policy"`
+	SnapshotID string `json:"snapshot_id,omitempty" yaml:"snapshot_id,omitempty"` // empty for a snapshot that would be created (dry run)
+	VolumeID   string `json:"volume_id" yaml:"volume_id"`
+	StartTime  string `json:"start_time" yaml:"start_time"`
+	Action     string `json:"action" yaml:"action"`                       // created, kept, deleted, or failed (creation)
+	Reason     string `json:"reason,omitempty" yaml:"reason,omitempty"`   // rules keeping the snapshot (ex: daily, weekly)
+	DryRun     bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"` // true if it would have been created or deleted
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+
+	time time.Time
+}
+
+// Result of Run.
+type Report struct {
+	Snapshots []SnapshotResult `json:"snapshots" yaml:"snapshots"`
+}
+
+func (r *Report) Table() ([]string, [][]string) {
+	rows := make([][]string, len(r.Snapshots))
+	for i, s := range r.Snapshots {
+		action := s.Action
+		if s.DryRun {
+			action += " (dry run)"
+		}
+		rows[i] = []string{s.Policy, s.SnapshotID, s.VolumeID, s.StartTime, action, s.Reason, s.Error}
+	}
+	return []string{"POLICY", "SNAPSHOT ID", "VOLUME ID", "START TIME", "ACTION", "KEPT BY", "ERROR"}, rows
+}
+
+// Returns the IDs of the snapshots kept or created.
+func (r *Report) IDs() []string {
+	var ids []string
+	for _, s := range r.Snapshots {
+		if s.Action != "deleted" && s.SnapshotID != "" {
+			ids = append(ids, s.SnapshotID)
+		}
+	}
+	return ids
+}
+
+// Runs the policies: snapshots the volumes of each policy (unless options.NoCreate),
+// tagged with PolicyTag, then deletes the snapshots of the policy that its rules
+// don't keep (the rules apply to the snapshots of each volume). The snapshots that
+// aren't completed yet are always kept. The report lists every snapshot of the
+// policies, and is returned even if some snapshots couldn't be created or deleted.
+// With options.DryRun, nothing is changed: the report tells what would be done.
+func Run(ec2client *ec2.Client, policies []*Policy, options Options) (*Report, error) {
+	now := time.Now()
+	if options.Now != nil {
+		now = options.Now()
+	}
+	report := &Report{Snapshots: []SnapshotResult{}}
+	var errs []error
+	for _, policy := range policies {
+		var created []SnapshotResult
+		if !options.NoCreate {
+			var err error
+			created, err = policy.snapshot(ec2client, now, options)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		snapshots, err := policy.prune(ec2client, created, options)
+		report.Snapshots = append(report.Snapshots, snapshots...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// Snapshots the volumes of the instances and the volumes selected by the policy.
+func (p *Policy) snapshot(ec2client *ec2.Client, now time.Time, options Options) ([]SnapshotResult, error) {
+	var results []SnapshotResult
+	var errs []error
+	covered := make(map[string]bool) // volumes snapshotted with their instance
+
+	if p.sel != nil {
+		var instances []types.Instance
+		err := describeEC2.EachInstance(ec2client, &ec2.DescribeInstancesInput{Filters: p.sel.Filters}, func(instance types.Instance) error {
+			if p.sel.Match(instance) && !describeEC2.IsTerminated(instance) {
+				instances = append(instances, instance)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: fetching info on instances selected by %q failed: %w", p.Name, p.Select, err)
+		}
+		for _, instance := range instances {
+			var volumes []string
+			for _, mapping := range instance.BlockDeviceMappings {
+				if mapping.Ebs != nil && mapping.Ebs.VolumeId != nil {
+					covered[*mapping.Ebs.VolumeId] = true
+					volumes = append(volumes, *mapping.Ebs.VolumeId)
+				}
+			}
+			created, err := p.snapshotInstance(ec2client, *instance.InstanceId, volumes, now, options)
+			results = append(results, created...)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if p.Volumes != "" {
+		key, value, _ := strings.Cut(p.Volumes, "=")
+		filterName := "tag:" + key
+		paginator := ec2.NewDescribeVolumesPaginator(ec2client, &ec2.DescribeVolumesInput{
+			Filters: []types.Filter{{Name: &filterName, Values: []string{value}}},
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return results, fmt.Errorf("policy %s: fetching info on volumes tagged %s failed: %w", p.Name, p.Volumes, err)
+			}
+			for _, volume := range page.Volumes {
+				if covered[*volume.VolumeId] {
+					continue
+				}
+				created, err := p.snapshotVolume(ec2client, *volume.VolumeId, now, options)
+				results = append(results, created)
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// Returns the tags of the snapshots created by the policy.
+func (p *Policy) tags() []types.TagSpecification {
+	key := PolicyTag
+	return []types.TagSpecification{{ResourceType: types.ResourceTypeSnapshot, Tags: []types.Tag{{Key: &key, Value: &p.Name}}}}
+}
+
+func (p *Policy) description() *string {
+	description := "created by retention policy " + p.Name
+	return &description
+}
+
+// Snapshots the volumes of the instance (crash-consistent across the volumes).
+func (p *Policy) snapshotInstance(ec2client *ec2.Client, instanceId string, volumes []string, now time.Time, options Options) ([]SnapshotResult, error) {
+	output, err := ec2client.CreateSnapshots(context.TODO(), &ec2.CreateSnapshotsInput{
+		InstanceSpecification: &types.InstanceSpecification{InstanceId: &instanceId},
+		CopyTagsFromSource:    types.CopyTagsFromSourceVolume,
+		Description:           p.description(),
+		TagSpecifications:     p.tags(),
+		DryRun:                &options.DryRun,
+	})
+	var results []SnapshotResult
+	if options.DryRun && dryrun.Succeeded(err) {
+		fmt.Fprintf(options.messages(), "Dry run: policy %s would snapshot the %d volumes of instance %s.\n", p.Name, len(volumes), instanceId)
+		for _, volume := range volumes {
+			results = append(results, p.created("", volume, now))
+		}
+		return results, nil
+	}
+	if err != nil {
+		// none of the volumes is snapshotted
+		for _, volume := range volumes {
+			results = append(results, p.failed(volume, now, err))
+		}
+		return results, fmt.Errorf("policy %s: couldn't snapshot the volumes of instance %s: %w", p.Name, instanceId, err)
+	}
+	for _, s := range output.Snapshots {
+		start := now
+		if s.StartTime != nil {
+			start = *s.StartTime
+		}
+		results = append(results, p.created(*s.SnapshotId, *s.VolumeId, start))
+	}
+	fmt.Fprintf(options.messages(), "Policy %s: %d snapshots of the volumes of instance %s started.\n", p.Name, len(results), instanceId)
+	return results, nil
+}
+
+// Snapshots the volume of ID given in parameter.
+func (p *Policy) snapshotVolume(ec2client *ec2.Client, volumeId string, now time.Time, options Options) (SnapshotResult, error) {
+	output, err := ec2client.CreateSnapshot(context.TODO(), &ec2.CreateSnapshotInput{
+		VolumeId:          &volumeId,
+		Description:       p.description(),
+		TagSpecifications: p.tags(),
+		DryRun:            &options.DryRun,
+	})
+	if options.DryRun && dryrun.Succeeded(err) {
+		fmt.Fprintf(options.messages(), "Dry run: policy %s would snapshot volume %s.\n", p.Name, volumeId)
+		return p.created("", volumeId, now), nil
+	}
+	if err != nil {
+		return p.failed(volumeId, now, err), fmt.Errorf("policy %s: couldn't snapshot volume %s: %w", p.Name, volumeId, err)
+	}
+	start := now
+	if output.StartTime != nil {
+		start = *output.StartTime
+	}
+	fmt.Fprintf(options.messages(), "Policy %s: snapshot %s of volume %s started.\n", p.Name, *output.SnapshotId, volumeId)
+	return p.created(*output.SnapshotId, volumeId, start), nil
+}
+
+// Returns the result of a snapshot created at time t (or that would be created, without ID).
+func (p *Policy) created(snapshotId string, volumeId string, t time.Time) SnapshotResult {
+	return SnapshotResult{
+		Policy:     p.Name,
+		SnapshotID: snapshotId,
+		VolumeID:   volumeId,
+		StartTime:  t.UTC().Format(time.RFC3339),
+		Action:     "created",
+		DryRun:     snapshotId == "",
+		time:       t,
+	}
+}
+
+// Returns the result of a snapshot of the volume that couldn't be created at time t.
+func (p *Policy) failed(volumeId string, t time.Time, err error) SnapshotResult {
+	result := p.created("", volumeId, t)
+	result.Action, result.Error, result.DryRun = "failed", err.Error(), false
+	return result
+}
+
+// Deletes the snapshots of the policy not kept by its rules, counting the snapshots
+// just created. Returns the results of all the snapshots of the policy.
+func (p *Policy) prune(ec2client *ec2.Client, created []SnapshotResult, options Options) ([]SnapshotResult, error) {
+	filterName := "tag:" + PolicyTag
+	paginator := ec2.NewDescribeSnapshotsPaginator(ec2client, &ec2.DescribeSnapshotsInput{
+		OwnerIds: []string{"self"},
+		Filters:  []types.Filter{{Name: &filterName, Values: []string{p.Name}}},
+	})
+	// snapshots of each volume
+	byVolume := make(map[string][]SnapshotResult)
+	isNew := make(map[string]bool)
+	var failed []SnapshotResult
+	for _, s := range created {
+		if s.Action != "created" {
+			failed = append(failed, s)
+			continue
+		}
+		byVolume[s.VolumeID] = append(byVolume[s.VolumeID], s)
+		isNew[s.SnapshotID] = s.SnapshotID != ""
+	}
+	pending := make(map[string]bool)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return created, fmt.Errorf("policy %s: fetching info on its snapshots failed: %w", p.Name, err)
+		}
+		for _, snapshot := range page.Snapshots {
+			if isNew[*snapshot.SnapshotId] {
+				continue
+			}
+			volume := ""
+			if snapshot.VolumeId != nil {
+				volume = *snapshot.VolumeId
+			}
+			result := SnapshotResult{Policy: p.Name, SnapshotID: *snapshot.SnapshotId, VolumeID: volume, Action: "kept"}
+			if snapshot.StartTime != nil {
+				result.time = *snapshot.StartTime
+				result.StartTime = snapshot.StartTime.UTC().Format(time.RFC3339)
+			}
+			pending[result.SnapshotID] = snapshot.State == types.SnapshotStatePending
+			byVolume[volume] = append(byVolume[volume], result)
+		}
+	}
+
+	volumes := make([]string, 0, len(byVolume))
+	for volume := range byVolume {
+		volumes = append(volumes, volume)
+	}
+	sort.Strings(volumes)
+	results := failed
+	var errs []error
+	for _, volume := range volumes {
+		snapshots := byVolume[volume]
+		times := make([]time.Time, len(snapshots))
+		for i, s := range snapshots {
+			times[i] = s.time
+		}
+		kept := p.rules.Keep(times)
+		for i, s := range snapshots {
+			s.Reason = kept[i]
+			switch {
+			case s.Action != "kept":
+				// created: kept even if the rules don't keep it
+			case s.Reason != "":
+			case pending[s.SnapshotID]:
+				s.Reason = "pending"
+			default:
+				s.Action = "deleted"
+				if err := deleteSnapshot(ec2client, &s, options); err != nil {
+					errs = append(errs, fmt.Errorf("policy %s: %w", p.Name, err))
+				}
+			}
+			results = append(results, s)
+		}
+	}
+	// most recent first, for each volume
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].VolumeID != results[j].VolumeID {
+			return results[i].VolumeID < results[j].VolumeID
+		}
+		return results[i].time.After(results[j].time)
+	})
+	return results, errors.Join(errs...)
+}
+
+// Deletes the snapshot, recording the failure in its result
+// (a snapshot used by an AMI can't be deleted: it's then kept).
+func deleteSnapshot(ec2client *ec2.Client, s *SnapshotResult, options Options) error {
+	_, err := ec2client.DeleteSnapshot(context.TODO(), &ec2.DeleteSnapshotInput{SnapshotId: &s.SnapshotID, DryRun: &options.DryRun})
+	var apiErr smithy.APIError
+	switch {
+	case options.DryRun && dryrun.Succeeded(err):
+		s.DryRun = true
+		fmt.Fprintf(options.messages(), "Dry run: snapshot %s of volume %s would be deleted.\n", s.SnapshotID, s.VolumeID)
+	case errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidSnapshot.NotFound":
+		fmt.Fprintf(options.messages(), "Snapshot %s was already deleted.\n", s.SnapshotID)
+	case err != nil:
+		s.Action = "kept"
+		s.Error = err.Error()
+		return fmt.Errorf("couldn't delete snapshot %s: %w", s.SnapshotID, err)
+	default:
+		fmt.Fprintf(options.messages(), "Snapshot %s of volume %s deleted.\n", s.SnapshotID, s.VolumeID)
+	}
+	return nil
+}
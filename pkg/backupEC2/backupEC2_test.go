@@ -0,0 +1,141 @@
+package backupEC2
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+var now = time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+// Returns an EC2 client sending its requests to a fake EC2 API: handle
+// receives the action and the parameters of each request, and returns
+// the HTTP status and the XML body of the answer.
+func fakeEC2(t *testing.T, handle func(action string, params url.Values) (int, string)) *ec2.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		status, body := handle(r.Form.Get("Action"), r.Form)
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return ec2.New(ec2.Options{Region: "us-east-1", BaseEndpoint: &server.URL, RetryMaxAttempts: 1})
+}
+
+// Returns the answer of a request that failed with the AWS error code given in parameter.
+func failed(code string) (int, string) {
+	return http.StatusBadRequest, "<Response><Errors><Error><Code>" + code + "</Code><Message>" + code + "</Message></Error></Errors></Response>"
+}
+
+func TestSnapshotInstanceFailed(t *testing.T) {
+	ec2client := fakeEC2(t, func(action string, params url.Values) (int, string) {
+		return failed("UnauthorizedOperation")
+	})
+	policy, err := NewPolicy("web", "tag:env=prod", "", "keep 7 daily")
+	if err != nil {
+		t.Fatalf("NewPolicy() = %v", err)
+	}
+	results, err := policy.snapshotInstance(ec2client, "i-1", []string{"vol-1", "vol-2"}, now, Options{Output: io.Discard})
+	if err == nil {
+		t.Errorf("snapshotInstance(): expected an error")
+	}
+	// one failed row per volume of the instance
+	var volumes []string
+	for _, result := range results {
+		if result.Action != "failed" || result.Error == "" || result.DryRun {
+			t.Errorf("result of %s = %+v, expected failed with the error", result.VolumeID, result)
+		}
+		volumes = append(volumes, result.VolumeID)
+	}
+	if !reflect.DeepEqual(volumes, []string{"vol-1", "vol-2"}) {
+		t.Errorf("snapshotInstance() failed volumes = %v, expected [vol-1 vol-2]", volumes)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	// snapshots of the policy, by ID: start time (hours before now) and state
+	snapshots := []struct {
+		id    string
+		hours int
+		state string
+	}{
+		{"snap-new", 2, "pending"},
+		{"snap-1", 1, "completed"},
+		{"snap-old", 3, "completed"},
+		{"snap-pending", 4, "pending"},
+	}
+	var mu sync.Mutex
+	var deleted []string
+	ec2client := fakeEC2(t, func(action string, params url.Values) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch action {
+		case "DescribeSnapshots":
+			var items strings.Builder
+			for _, s := range snapshots {
+				fmt.Fprintf(&items, "<item><snapshotId>%s</snapshotId><volumeId>vol-1</volumeId><status>%s</status><startTime>%s</startTime></item>",
+					s.id, s.state, now.Add(-time.Duration(s.hours)*time.Hour).Format(time.RFC3339))
+			}
+			return http.StatusOK, "<DescribeSnapshotsResponse><snapshotSet>" + items.String() + "</snapshotSet></DescribeSnapshotsResponse>"
+		case "DeleteSnapshot":
+			if params.Get("DryRun") == "true" {
+				return failed("DryRunOperation")
+			}
+			deleted = append(deleted, params.Get("SnapshotId"))
+			return http.StatusOK, "<DeleteSnapshotResponse><return>true</return></DeleteSnapshotResponse>"
+		}
+		t.Errorf("unexpected request %s", action)
+		return failed("InvalidAction")
+	})
+	policy, err := NewPolicy("web", "tag:env=prod", "", "keep 1 daily")
+	if err != nil {
+		t.Fatalf("NewPolicy() = %v", err)
+	}
+	// the snapshot just created is older than snap-1 (its start time is given by AWS):
+	// the rules keep snap-1 only
+	created := []SnapshotResult{policy.created("snap-new", "vol-1", now.Add(-2*time.Hour))}
+
+	for _, dryRun := range []bool{false, true} {
+		deleted = nil
+		results, err := policy.prune(ec2client, created, Options{DryRun: dryRun, Output: io.Discard})
+		if err != nil {
+			t.Fatalf("prune(dry run: %v) = %v", dryRun, err)
+		}
+		actions := make(map[string]string)
+		for _, result := range results {
+			actions[result.SnapshotID] = result.Action + " " + result.Reason
+			if result.DryRun != (dryRun && result.Action == "deleted") {
+				t.Errorf("dry run: %v: result of %s = %+v", dryRun, result.SnapshotID, result)
+			}
+		}
+		expected := map[string]string{
+			"snap-1":       "kept daily",
+			"snap-new":     "created ",
+			"snap-old":     "deleted ",
+			"snap-pending": "kept pending",
+		}
+		if !reflect.DeepEqual(actions, expected) {
+			t.Errorf("dry run: %v: prune() = %v, expected %v", dryRun, actions, expected)
+		}
+		// in dry run mode, nothing is deleted
+		expectedDeleted := []string{"snap-old"}
+		if dryRun {
+			expectedDeleted = nil
+		}
+		if !reflect.DeepEqual(deleted, expectedDeleted) {
+			t.Errorf("dry run: %v: deleted snapshots %v, expected %v", dryRun, deleted, expectedDeleted)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package confirm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Identifiers of the actions that ask for a confirmation.
+// They are given to the Confirmer so that a Policy can answer
+// differently depending on the action.
+const (
+	ActionCreateKey      = "create-key"      // create a missing EC2 key pair
+	ActionDeleteAll      = "delete-all"      // delete all the instances of the account
+	ActionDeleteSelected = "delete-selected" // delete the instances selected by name, tag...
+	ActionTeardown       = "teardown"        // delete instances and the resources they use
+	ActionStopSelected   = "stop-selected"   // stop the instances selected by name, tag...
+	ActionRebootSelected = "reboot-selected" // reboot the instances selected by name, tag...
+	// disable the termination protection of instances, to delete them
+	ActionDisableProtection = "disable-termination-protection"
+)
+
+// A Confirmer answers the yes/no questions asked before an action is performed.
+// action identifies the kind of question (see the Action constants above),
+// and message is the human readable question.
+type Confirmer interface {
+	Confirm(action string, message string) (bool, error)
+}
+
+// A TypedConfirmer asks the user to type an expected answer to confirm
+// (ex: the number of instances that will be deleted), for the most dangerous actions.
+type TypedConfirmer interface {
+	ConfirmTyped(action string, message string, expected string) (bool, error)
+}
+
+// Asks the confirmer to confirm by typing the expected answer, if it's a TypedConfirmer,
+// or else asks a yes/no question (ex: AutoYes confirms without typing anything).
+func Typed(confirmer Confirmer, action string, message string, expected string) (bool, error) {
+	if typed, ok := confirmer.(TypedConfirmer); ok {
+		return typed.ConfirmTyped(action, message, expected)
+	}
+	return confirmer.Confirm(action, message)
+}
+
+// Terminal asks the question to the user and reads the answer (Y/N).
+// This is the interactive behaviour of the programs.
+type Terminal struct {
+	In  io.Reader
+	Out io.Writer
+}
+
+// Returns a Terminal confirmer reading from stdin and writing to stdout.
+func NewTerminal() *Terminal {
+	return &Terminal{In: os.Stdin, Out: os.Stdout}
+}
+
+func (t *Terminal) Confirm(action string, message string) (bool, error) {
+	fmt.Fprintf(t.Out, "> %s (Y/N): ", message)
+	// prompt loop (until user gives an valid answer)
+	for {
+		var answer string
+		_, err := fmt.Fscan(t.In, &answer)
+		if err != nil {
+			return false, fmt.Errorf("error reading user input: %w", err)
+		}
+
+		switch strings.ToUpper(answer) {
+		case "Y":
+			return true, nil
+		case "N":
+			return false, nil
+		default:
+			fmt.Fprintln(t.Out, "Invalid output, please answer \"Y\" or \"N\": ")
+		}
+	}
+}
+
+// Asks the user to type the expected answer. Any other answer refuses.
+func (t *Terminal) ConfirmTyped(action string, message string, expected string) (bool, error) {
+	fmt.Fprintf(t.Out, "> %s (type %q to confirm): ", message, expected)
+	var answer string
+	_, err := fmt.Fscan(t.In, &answer)
+	if err != nil {
+		return false, fmt.Errorf("error reading user input: %w", err)
+	}
+	if answer != expected {
+		fmt.Fprintf(t.Out, "Answer %q doesn't match %q.\n", answer, expected)
+		return false, nil
+	}
+	return true, nil
+}
+
+// AutoYes answers yes to every question without asking anything
+// (for scripts, CI, etc.).
+type AutoYes struct{}
+
+func (AutoYes) Confirm(action string, message string) (bool, error) {
+	return true, nil
+}
+
+// AutoNo answers no to every question without asking anything.
+type AutoNo struct{}
+
+func (AutoNo) Confirm(action string, message string) (bool, error) {
+	return false, nil
+}
+
+// Policy answers according to a fixed answer per action.
+// Actions that have no rule are forwarded to Fallback,
+// or refused if Fallback is nil.
+//
+// Example: create missing keys but ask for everything else:
+//
+//	confirm.Policy{
+//		Rules:    map[string]bool{confirm.ActionCreateKey: true},
+//		Fallback: confirm.NewTerminal(),
+//	}
+type Policy struct {
+	Rules    map[string]bool
+	Fallback Confirmer
+}
+
+func (p Policy) Confirm(action string, message string) (bool, error) {
+	if answer, ok := p.Rules[action]; ok {
+		return answer, nil
+	}
+	if p.Fallback == nil {
+		return false, nil
+	}
+	return p.Fallback.Confirm(action, message)
+}
+
+func (p Policy) ConfirmTyped(action string, message string, expected string) (bool, error) {
+	if answer, ok := p.Rules[action]; ok {
+		return answer, nil
+	}
+	if p.Fallback == nil {
+		return false, nil
+	}
+	return Typed(p.Fallback, action, message, expected)
+}
@@ -0,0 +1,169 @@
+package confirm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTerminal(t *testing.T) {
+	tests := []struct {
+		input  string
+		answer bool
+		asked  int // number of times the answer was asked again
+	}{
+		{"Y\n", true, 0},
+		{"y\n", true, 0},
+		{"N\n", false, 0},
+		{"n\n", false, 0},
+		{"maybe\nyes\nY\n", true, 2},
+		{"\n\nN\n", false, 0}, // empty lines are skipped
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		terminal := &Terminal{In: strings.NewReader(test.input), Out: &out}
+		answer, err := terminal.Confirm(ActionDeleteAll, "Delete everything?")
+		if err != nil {
+			t.Errorf("Confirm() with input %q: %v", test.input, err)
+			continue
+		}
+		if answer != test.answer {
+			t.Errorf("Confirm() with input %q = %v, expected %v", test.input, answer, test.answer)
+		}
+		if !strings.HasPrefix(out.String(), "> Delete everything? (Y/N): ") {
+			t.Errorf("Confirm() with input %q printed %q, expected the question first", test.input, out.String())
+		}
+		if asked := strings.Count(out.String(), "please answer"); asked != test.asked {
+			t.Errorf("Confirm() with input %q asked again %d times, expected %d", test.input, asked, test.asked)
+		}
+	}
+}
+
+func TestTerminalEndOfInput(t *testing.T) {
+	var out strings.Builder
+	terminal := &Terminal{In: strings.NewReader("maybe\n"), Out: &out}
+	if answer, err := terminal.Confirm(ActionDeleteAll, "Delete everything?"); err == nil || answer {
+		t.Errorf("Confirm() at the end of the input = %v, %v, expected an error", answer, err)
+	}
+}
+
+// Records the questions it's asked, and answers answer.
+type recorder struct {
+	answer  bool
+	err     error
+	actions []string
+}
+
+func (r *recorder) Confirm(action string, message string) (bool, error) {
+	r.actions = append(r.actions, action)
+	return r.answer, r.err
+}
+
+func TestPolicy(t *testing.T) {
+	fallback := &recorder{answer: true}
+	policy := Policy{
+		Rules:    map[string]bool{ActionCreateKey: true, ActionDeleteAll: false},
+		Fallback: fallback,
+	}
+	for _, test := range []struct {
+		action string
+		answer bool
+	}{
+		{ActionCreateKey, true},
+		{ActionDeleteAll, false},
+		{"other", true}, // answered by the fallback
+	} {
+		answer, err := policy.Confirm(test.action, "?")
+		if err != nil || answer != test.answer {
+			t.Errorf("Confirm(%q) = %v, %v, expected %v", test.action, answer, err, test.answer)
+		}
+	}
+	if len(fallback.actions) != 1 || fallback.actions[0] != "other" {
+		t.Errorf("fallback asked for %q, expected only for the action without rule", fallback.actions)
+	}
+
+	// the error of the fallback is returned
+	failing := Policy{Fallback: &recorder{answer: true, err: errors.New("no terminal")}}
+	if _, err := failing.Confirm(ActionDeleteAll, "?"); err == nil {
+		t.Errorf("Confirm() with a failing fallback: expected an error")
+	}
+
+	// without fallback, the actions without rule are refused
+	if answer, err := (Policy{}).Confirm(ActionDeleteAll, "?"); answer || err != nil {
+		t.Errorf("Confirm() without rule nor fallback = %v, %v, expected false", answer, err)
+	}
+}
+
+func TestAuto(t *testing.T) {
+	if answer, err := (AutoYes{}).Confirm(ActionDeleteAll, "?"); !answer || err != nil {
+		t.Errorf("AutoYes.Confirm() = %v, %v", answer, err)
+	}
+	if answer, err := (AutoNo{}).Confirm(ActionCreateKey, "?"); answer || err != nil {
+		t.Errorf("AutoNo.Confirm() = %v, %v", answer, err)
+	}
+}
+
+func TestTerminalConfirmTyped(t *testing.T) {
+	tests := []struct {
+		input  string
+		answer bool
+	}{
+		{"12\n", true},
+		{"  12  \n", true},
+		{"y\n", false},
+		{"123\n", false},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		terminal := &Terminal{In: strings.NewReader(test.input), Out: &out}
+		answer, err := terminal.ConfirmTyped(ActionDeleteSelected, "Delete 12 instances?", "12")
+		if err != nil || answer != test.answer {
+			t.Errorf("ConfirmTyped() with input %q = %v, %v, expected %v", test.input, answer, err, test.answer)
+		}
+		if !strings.HasPrefix(out.String(), `> Delete 12 instances? (type "12" to confirm): `) {
+			t.Errorf("ConfirmTyped() with input %q printed %q, expected the question first", test.input, out.String())
+		}
+	}
+}
+
+// A recorder that can confirm by typing the expected answer.
+type typedRecorder struct {
+	recorder
+	expected []string
+}
+
+func (r *typedRecorder) ConfirmTyped(action string, message string, expected string) (bool, error) {
+	r.expected = append(r.expected, expected)
+	return r.answer, r.err
+}
+
+func TestTyped(t *testing.T) {
+	// a TypedConfirmer is asked to type the expected answer
+	typed := &typedRecorder{recorder: recorder{answer: true}}
+	if answer, err := Typed(typed, ActionDeleteSelected, "?", "12"); !answer || err != nil {
+		t.Errorf("Typed() = %v, %v, expected true", answer, err)
+	}
+	if len(typed.expected) != 1 || typed.expected[0] != "12" || len(typed.actions) != 0 {
+		t.Errorf("Typed() asked %q typed and %q yes/no, expected only \"12\" typed", typed.expected, typed.actions)
+	}
+
+	// the other confirmers are asked a yes/no question
+	if answer, err := Typed(AutoYes{}, ActionDeleteSelected, "?", "12"); !answer || err != nil {
+		t.Errorf("Typed(AutoYes) = %v, %v, expected true", answer, err)
+	}
+
+	// a Policy answers with its rules, else asks its fallback to type the answer
+	policy := Policy{Rules: map[string]bool{ActionDeleteAll: false}, Fallback: typed}
+	if answer, err := Typed(policy, ActionDeleteAll, "?", "3"); answer || err != nil {
+		t.Errorf("Typed(Policy) with a rule = %v, %v, expected false", answer, err)
+	}
+	if answer, err := Typed(policy, ActionDeleteSelected, "?", "3"); !answer || err != nil {
+		t.Errorf("Typed(Policy) without rule = %v, %v, expected true", answer, err)
+	}
+	if len(typed.expected) != 2 || typed.expected[1] != "3" {
+		t.Errorf("fallback asked to type %q, expected the answer of the action without rule", typed.expected)
+	}
+	if answer, err := Typed(Policy{}, ActionDeleteSelected, "?", "3"); answer || err != nil {
+		t.Errorf("Typed(Policy) without rule nor fallback = %v, %v, expected false", answer, err)
+	}
+}
@@ -0,0 +1,429 @@
+package copyEC2
+
+import (
+	"aws/pkg/sshEC2"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Direction of a copy.
+const (
+	Upload   = "upload"   // from the local machine to the instance
+	Download = "download" // from the instance to the local machine
+)
+
+// Options of Copy. The zero value uses the defaults.
+type Options struct {
+	// connection to the instances (SSH.Output is set to Output)
+	SSH       sshEC2.Options
+	Recursive bool // copy directories and their content
+	// if true, the SHA-256 checksums of the copied files aren't compared
+	// with the checksums of the files on the instance
+	NoVerify bool
+	// terminal where the progress of each file is shown (rewriting the same line),
+	// or nil to only print a message per file copied
+	Progress io.Writer
+	// if true, nothing is copied: only the instance and its connection
+	// parameters (login user, key file) are resolved
+	DryRun bool
+	// writer where a message is printed for each file copied (default: os.Stdout)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// A file copied.
+type File struct {
+	Source      string `json:"source" yaml:"source"`
+	Destination string `json:"destination" yaml:"destination"`
+	Size        int64  `json:"size" yaml:"size"`
+	SHA256      string `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	Verified    bool   `json:"verified" yaml:"verified"` // true if the checksum on the instance matches
+	Error       string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Result of Copy.
+type Result struct {
+	InstanceID  string `json:"instance_id" yaml:"instance_id"`
+	Direction   string `json:"direction" yaml:"direction"` // Upload or Download
+	Source      string `json:"source" yaml:"source"`
+	Destination string `json:"destination" yaml:"destination"`
+	Files       []File `json:"files" yaml:"files"`
+	DryRun      bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"` // true if the files would have been copied
+}
+
+func (r *Result) Table() ([]string, [][]string) {
+	if r.DryRun {
+		return []string{"INSTANCE ID", "DIRECTION", "SOURCE", "DESTINATION", "STATUS"},
+			[][]string{{r.InstanceID, r.Direction, r.Source, r.Destination, "dry run"}}
+	}
+	rows := make([][]string, len(r.Files))
+	for i, file := range r.Files {
+		verified := fmt.Sprint(file.Verified)
+		if file.Error == "" && !file.Verified {
+			verified = "skipped"
+		}
+		// the beginning of the checksum is enough to compare it by eye
+		checksum := file.SHA256
+		if len(checksum) > 16 {
+			checksum = checksum[:16]
+		}
+		rows[i] = []string{file.Source, file.Destination, formatSize(file.Size), checksum, verified, file.Error}
+	}
+	return []string{"SOURCE", "DESTINATION", "SIZE", "SHA256", "VERIFIED", "ERROR"}, rows
+}
+
+// Returns the ID of the instance.
+func (r *Result) IDs() []string {
+	return []string{r.InstanceID}
+}
+
+// Copies files over SFTP between the local machine and the instance of ID or name
+// given in parameter (connected to as with sshEC2.Resolve and sshEC2.Dial), in the
+// direction given in parameter (Upload or Download).
+// As with cp, if the destination is an existing directory, the source is copied into it.
+// Relative paths on the instance are relative to the home directory of the login user.
+// After each file, the SHA-256 checksum of the data sent or received is compared
+// with the checksum of the file on the instance (computed with sha256sum, or by
+// reading the file back if the instance doesn't have it).
+// The copy stops at the first error; the result lists the files copied until then.
+func Copy(ec2client *ec2.Client, instance string, direction string, source string, destination string, options Options) (*Result, error) {
+	if direction != Upload && direction != Download {
+		return nil, fmt.Errorf("invalid direction %q (expected %s or %s)", direction, Upload, Download)
+	}
+	options.SSH.Output = options.Output
+	target, err := sshEC2.Resolve(ec2client, instance, options.SSH)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{InstanceID: target.InstanceID, Direction: direction, Source: source, Destination: destination, Files: []File{}}
+	if options.DryRun {
+		fmt.Fprintf(options.messages(), "Dry run: would %s %s to %s on %s (instance %s) with key %s\n", direction, source, destination, target, target.InstanceID, target.KeyFile)
+		result.DryRun = true
+		return result, nil
+	}
+
+	sshClient, err := sshEC2.Dial(target, options.SSH)
+	if err != nil {
+		return nil, err
+	}
+	defer sshClient.Close()
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return nil, fmt.Errorf("error starting SFTP on instance %s: %w", target.InstanceID, err)
+	}
+	defer sftpClient.Close()
+
+	c := &copier{ssh: sshClient, sftp: sftpClient, options: options, result: result}
+	if direction == Upload {
+		err = c.upload(source, remotePath(destination))
+	} else {
+		err = c.download(remotePath(source), destination)
+	}
+	return result, err
+}
+
+// Returns the path on the instance, relative to the home directory
+// if it starts with "~/" (SFTP doesn't expand ~).
+func remotePath(p string) string {
+	p = strings.TrimPrefix(p, "~/")
+	if p == "~" || p == "" {
+		return "."
+	}
+	return p
+}
+
+// State of a copy.
+type copier struct {
+	ssh     *ssh.Client
+	sftp    *sftp.Client
+	options Options
+	result  *Result
+}
+
+func (c *copier) upload(source string, destination string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !c.options.Recursive {
+		return fmt.Errorf("%s is a directory (use the recursive option to copy it)", source)
+	}
+	// copies the source into the destination directory, if it exists
+	if remote, err := c.sftp.Stat(destination); err == nil && remote.IsDir() {
+		destination = path.Join(destination, filepath.Base(source))
+	}
+	if !info.IsDir() {
+		return c.uploadFile(source, destination, info)
+	}
+
+	return filepath.WalkDir(source, func(local string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, local)
+		if err != nil {
+			return err
+		}
+		remote := path.Join(destination, filepath.ToSlash(rel))
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			if err := c.sftp.MkdirAll(remote); err != nil {
+				return fmt.Errorf("error creating directory %s on the instance: %w", remote, err)
+			}
+			return nil
+		case !info.Mode().IsRegular():
+			// symbolic links, sockets... aren't copied
+			fmt.Fprintf(c.options.messages(), "Skipped %s (not a regular file).\n", local)
+			return nil
+		}
+		return c.uploadFile(local, remote, info)
+	})
+}
+
+func (c *copier) uploadFile(source string, destination string, info fs.FileInfo) error {
+	file := File{Source: source, Destination: destination, Size: info.Size()}
+	err := func() error {
+		local, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+		remote, err := c.sftp.Create(destination)
+		if err != nil {
+			return fmt.Errorf("error creating %s on the instance: %w", destination, err)
+		}
+		defer remote.Close()
+		if err := remote.Chmod(info.Mode().Perm()); err != nil {
+			return fmt.Errorf("error setting the permissions of %s on the instance: %w", destination, err)
+		}
+
+		h := sha256.New()
+		p := c.progress(source, info.Size())
+		_, err = remote.ReadFromWithConcurrency(io.TeeReader(local, io.MultiWriter(h, p)), 0)
+		p.done()
+		if err != nil {
+			return fmt.Errorf("error copying %s to the instance: %w", source, err)
+		}
+		if err := remote.Close(); err != nil {
+			return fmt.Errorf("error copying %s to the instance: %w", source, err)
+		}
+		return c.verify(&file, h)
+	}()
+	return c.record(file, err)
+}
+
+func (c *copier) download(source string, destination string) error {
+	// the paths walked are joined with path.Join, which cleans them
+	source = path.Clean(source)
+	info, err := c.sftp.Stat(source)
+	if err != nil {
+		return fmt.Errorf("error reading %s on the instance: %w", source, err)
+	}
+	if info.IsDir() && !c.options.Recursive {
+		return fmt.Errorf("%s is a directory on the instance (use the recursive option to copy it)", source)
+	}
+	// copies the source into the destination directory, if it exists
+	if local, err := os.Stat(destination); err == nil && local.IsDir() {
+		destination = filepath.Join(destination, path.Base(source))
+	}
+	if !info.IsDir() {
+		return c.downloadFile(source, destination, info)
+	}
+
+	walker := c.sftp.Walk(source)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return fmt.Errorf("error listing %s on the instance: %w", walker.Path(), err)
+		}
+		remote, info := walker.Path(), walker.Stat()
+		rel := ""
+		switch {
+		case remote == source:
+		case source == ".":
+			rel = remote
+		default:
+			rel = strings.TrimPrefix(remote, source+"/")
+		}
+		local := filepath.Join(destination, filepath.FromSlash(rel))
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(local, 0755); err != nil {
+				return err
+			}
+		case !info.Mode().IsRegular():
+			fmt.Fprintf(c.options.messages(), "Skipped %s (not a regular file).\n", remote)
+		default:
+			if err := c.downloadFile(remote, local, info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *copier) downloadFile(source string, destination string, info fs.FileInfo) error {
+	file := File{Source: source, Destination: destination, Size: info.Size()}
+	err := func() error {
+		remote, err := c.sftp.Open(source)
+		if err != nil {
+			return fmt.Errorf("error opening %s on the instance: %w", source, err)
+		}
+		defer remote.Close()
+		local, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+
+		h := sha256.New()
+		p := c.progress(source, info.Size())
+		_, err = remote.WriteTo(io.MultiWriter(local, h, p))
+		p.done()
+		if err != nil {
+			return fmt.Errorf("error copying %s from the instance: %w", source, err)
+		}
+		if err := local.Close(); err != nil {
+			return err
+		}
+		return c.verify(&file, h)
+	}()
+	return c.record(file, err)
+}
+
+// Records the copy of the file in the result, and prints it.
+func (c *copier) record(file File, err error) error {
+	if err != nil {
+		file.Error = err.Error()
+	}
+	c.result.Files = append(c.result.Files, file)
+	if err != nil {
+		return err
+	}
+	verified := ""
+	if file.Verified {
+		verified = ", checksum verified"
+	}
+	fmt.Fprintf(c.options.messages(), "Copied %s to %s (%s%s).\n", file.Source, file.Destination, formatSize(file.Size), verified)
+	return nil
+}
+
+// Sets the checksum of the file copied (the data sent or received, in h),
+// and compares it with the checksum of the file on the instance.
+func (c *copier) verify(file *File, h hash.Hash) error {
+	file.SHA256 = hex.EncodeToString(h.Sum(nil))
+	if c.options.NoVerify {
+		return nil
+	}
+	remote := file.Destination
+	if c.result.Direction == Download {
+		remote = file.Source
+	}
+	checksum, err := c.remoteChecksum(remote)
+	if err != nil {
+		return err
+	}
+	if checksum != file.SHA256 {
+		return fmt.Errorf("checksum mismatch: %s on the instance is %s, the data copied is %s", remote, checksum, file.SHA256)
+	}
+	file.Verified = true
+	return nil
+}
+
+// Returns the SHA-256 checksum of a file on the instance, computed there with
+// sha256sum (or shasum), or by reading the file over SFTP if they aren't installed.
+func (c *copier) remoteChecksum(p string) (string, error) {
+	quoted := "'" + strings.ReplaceAll(p, "'", `'\''`) + "'"
+	var stdout bytes.Buffer
+	code, err := sshEC2.Run(c.ssh, "sha256sum -- "+quoted+" 2>/dev/null || shasum -a 256 -- "+quoted, nil, &stdout, io.Discard)
+	if fields := strings.Fields(stdout.String()); err == nil && code == 0 && len(fields) > 0 && len(fields[0]) == sha256.Size*2 {
+		return fields[0], nil
+	}
+
+	remote, err := c.sftp.Open(p)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s on the instance to verify its checksum: %w", p, err)
+	}
+	defer remote.Close()
+	h := sha256.New()
+	if _, err := remote.WriteTo(h); err != nil {
+		return "", fmt.Errorf("error reading %s on the instance to verify its checksum: %w", p, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Returns the writer counting the bytes copied of a file, to show its progress
+// on options.Progress.
+func (c *copier) progress(name string, size int64) *progress {
+	return &progress{w: c.options.Progress, name: name, size: size, start: time.Now()}
+}
+
+// Progress of the copy of a file, shown at most every 200ms.
+type progress struct {
+	w      io.Writer // nil: not shown
+	name   string
+	size   int64
+	copied int64
+	start  time.Time
+	shown  time.Time
+}
+
+func (p *progress) Write(data []byte) (int, error) {
+	p.copied += int64(len(data))
+	if p.w != nil && time.Since(p.shown) >= 200*time.Millisecond {
+		p.shown = time.Now()
+		percent := int64(100)
+		if p.size > 0 {
+			percent = p.copied * 100 / p.size
+		}
+		rate := float64(p.copied) / max(time.Since(p.start).Seconds(), 0.001)
+		fmt.Fprintf(p.w, "\r\033[K%s  %3d%%  %s / %s  %s/s", p.name, percent, formatSize(p.copied), formatSize(p.size), formatSize(int64(rate)))
+	}
+	return len(data), nil
+}
+
+// Clears the progress line.
+func (p *progress) done() {
+	if p.w != nil && !p.shown.IsZero() {
+		fmt.Fprint(p.w, "\r\033[K")
+	}
+}
+
+// Returns a size in bytes in a readable unit (ex: 12.3 MB).
+func formatSize(size int64) string {
+	if size < 1000 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	for _, unit := range []string{"kB", "MB", "GB", "TB"} {
+		value /= 1000
+		if value < 1000 {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+	}
+	return fmt.Sprintf("%.1f PB", value/1000)
+}
@@ -0,0 +1,23 @@
+package copyEC2
+
+import "testing"
+
+func TestRemotePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"", "."},
+		{"~", "."},
+		{"~/", "."},
+		{"~/app/config.yaml", "app/config.yaml"},
+		{"app", "app"},
+		{"/tmp/build", "/tmp/build"},
+		{"~user/app", "~user/app"},
+	}
+	for _, test := range tests {
+		if p := remotePath(test.path); p != test.expected {
+			t.Errorf("remotePath(%q) = %q, expected %q", test.path, p, test.expected)
+		}
+	}
+}
@@ -1,85 +1,300 @@
 package deleteEC2
 
 import (
+	"aws/pkg/confirm"
+	"aws/pkg/describeEC2"
+	"aws/pkg/dryrun"
+	"aws/pkg/fleet"
+	"aws/pkg/report"
+	"aws/pkg/selector"
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
 )
 
 // Permanently deletes the EC2 instance of ID given in parameter.
-func DeleteInstance(ec2client *ec2.Client, instanceId string) error {
+// If it fails, the error is an *InstanceError.
+// In dry run mode (options.DryRun), only checks if the instance would be deleted.
+func DeleteInstance(ec2client *ec2.Client, instanceId string, options DeleteOptions) error {
 	// indicate instance ID in parameter of the termination request
-	terminateInstanceInput := &ec2.TerminateInstancesInput{InstanceIds: []string{instanceId}}
+	terminateInstanceInput := &ec2.TerminateInstancesInput{InstanceIds: []string{instanceId}, DryRun: &options.DryRun}
 	// terminates the instance
-	_, err := ec2client.TerminateInstances(context.TODO(), terminateInstanceInput)
+	output, err := ec2client.TerminateInstances(context.TODO(), terminateInstanceInput)
+	if options.DryRun && dryrun.Succeeded(err) {
+		fmt.Fprintf(options.messages(), "Dry run: instance %s would be deleted.\n", instanceId)
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("couldn't delete instance %s: %w", instanceId, err)
+		return report.NewInstanceError(actionDelete, instanceId, err)
+	}
+	// the instance is usually still shutting down: use WaitTerminated to wait for the end
+	state := "shutting-down"
+	if len(output.TerminatingInstances) > 0 && output.TerminatingInstances[0].CurrentState != nil {
+		state = string(output.TerminatingInstances[0].CurrentState.Name)
 	}
-	fmt.Printf("Instance %s successfully deleted.\n", instanceId)
+	fmt.Fprintf(options.messages(), "Instance %s successfully deleted (state: %s).\n", instanceId, state)
 	return err
 }
 
-// Permanently deletes the EC2 instances of IDs given in parameter (in a list).
-func DeleteInstances(ec2client *ec2.Client, instanceIdList []string) error {
-	success := 0
-	fails := 0
-	for _, instance := range instanceIdList {
-		err := DeleteInstance(ec2client, instance)
-		// if we couldn't delete one instance,
-		// print the error and keep going
-		if err != nil {
-			log.Printf("%s\n", err)
-			fails++
-		} else {
-			success++
+// Result of the deletion of multiple instances (its action is "delete").
+type DeleteResult struct {
+	report.Result `yaml:",inline"`
+	// selected instances kept by the guardrails (bulk deletions only)
+	Skipped []SkippedInstance `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+}
+
+// Result of the deletion of one instance (Done is true if it was deleted).
+type InstanceResult = report.InstanceResult
+
+// Error of the deletion of one instance (see report.InstanceError).
+type InstanceError = report.InstanceError
+
+// Error returned when some instances couldn't be deleted (see report.Error).
+type DeleteError = report.Error
+
+// Returns an empty result of deletion.
+func newDeleteResult() *DeleteResult {
+	return &DeleteResult{Result: *report.New(actionDelete)}
+}
+
+// Action of the results and errors of this package.
+const actionDelete = "delete"
+
+// Maximum number of instance IDs in one TerminateInstances request
+// (limit of the EC2 API).
+const MaxBatchSize = 1000
+
+// Options of the deletion of multiple instances. The zero value uses the defaults.
+type DeleteOptions struct {
+	BatchSize   int // number of IDs per TerminateInstances request (default and maximum: MaxBatchSize)
+	Concurrency int // number of requests sent at the same time (default: fleet.DefaultConcurrency)
+	// number of times the deletions that failed with a retryable error are retried (default: 0)
+	Retries int
+	// delay before the first retry, multiplied by the number of the retry (default: 2s)
+	RetryDelay time.Duration
+	// safety checks of the bulk deletions (DeleteAllInstances, DeleteSelected)
+	Guardrails Guardrails
+	// if true, waits until the deleted instances are terminated (at most WaitTimeout,
+	// default: DefaultWaitTimeout), and records their final state in the result
+	Wait        bool
+	WaitTimeout time.Duration
+	// if true, the termination requests are sent with DryRun=true: AWS checks the
+	// permissions without deleting anything, and the instances that would be
+	// deleted are printed (see package dryrun)
+	DryRun bool
+	// writer where the progress messages are printed (default: os.Stdout; set it
+	// to os.Stderr to keep stdout for the results, or to io.Discard)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o DeleteOptions) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// Terminates the instances of IDs given in parameter with one TerminateInstances request,
+// and returns the state change of each instance.
+// If the request fails because of some of its instances (the whole request fails if
+// one ID is invalid, or if an instance is protected), the batch is split in two and
+// each half is retried, until the instances that can't be deleted are alone in their
+// request: their error is then recorded, and the other instances are still deleted.
+// Any other error (ex: UnauthorizedOperation) is recorded for all the instances of
+// the batch, without splitting it.
+// The request is paced by the throttle of the caller: a throttled request is returned
+// as an error, for the caller to retry it. The requests of the halves are sent with
+// the throttle.
+func terminateBatch(ec2client *ec2.Client, instanceIds []string, throttle *fleet.Throttle, options DeleteOptions) (*report.Result, error) {
+	result := report.New(actionDelete)
+	output, err := ec2client.TerminateInstances(context.TODO(), &ec2.TerminateInstancesInput{InstanceIds: instanceIds, DryRun: &options.DryRun})
+	if fleet.IsThrottled(err) {
+		return nil, err
+	}
+	if options.DryRun && dryrun.Succeeded(err) {
+		for _, id := range instanceIds {
+			result.AddDryRun(id)
+			fmt.Fprintf(options.messages(), "Dry run: instance %s would be deleted.\n", id)
+		}
+		return result, nil
+	}
+	if err != nil {
+		if len(instanceIds) > 1 && !instanceFailure(err) {
+			// the error is the same for all the instances: record it and keep going
+			return batchError(instanceIds, err, options), nil
+		}
+		if len(instanceIds) == 1 {
+			// record the error and keep going
+			fmt.Fprintln(options.messages(), result.AddError(instanceIds[0], err))
+			return result, nil
+		}
+		half := len(instanceIds) / 2
+		for _, part := range [][]string{instanceIds[:half], instanceIds[half:]} {
+			result.Merge(terminateThrottled(ec2client, part, throttle, options))
+		}
+		return result, nil
+	}
+
+	terminated := make(map[string]bool)
+	for _, change := range output.TerminatingInstances {
+		terminated[*change.InstanceId] = true
+		result.AddDone(*change.InstanceId, &change)
+		fmt.Fprintf(options.messages(), "Instance %s successfully deleted.\n", *change.InstanceId)
+	}
+	// AWS is supposed to return a state change for each instance
+	for _, id := range instanceIds {
+		if !terminated[id] {
+			fmt.Fprintln(options.messages(), result.AddError(id, errors.New("no state change returned by AWS")))
+		}
+	}
+	return result, nil
+}
+
+// Terminates the instances like terminateBatch, sending the request with the throttle
+// (it's retried while throttled). If it's still throttled, the error is recorded for
+// all the instances.
+func terminateThrottled(ec2client *ec2.Client, instanceIds []string, throttle *fleet.Throttle, options DeleteOptions) *report.Result {
+	var result *report.Result
+	err := throttle.Do(func() (err error) {
+		result, err = terminateBatch(ec2client, instanceIds, throttle, options)
+		return err
+	})
+	if err != nil {
+		return batchError(instanceIds, err, options)
+	}
+	return result
+}
+
+// Returns the result of a batch whose request failed with the same error for all
+// its instances (ex: still throttled after the retries).
+func batchError(instanceIds []string, err error, options DeleteOptions) *report.Result {
+	result := report.New(actionDelete)
+	fmt.Fprintf(options.messages(), "couldn't delete instances %s: %s\n", strings.Join(instanceIds, ", "), err)
+	for _, id := range instanceIds {
+		result.AddError(id, err)
+	}
+	return result
+}
+
+// Returns true if the error of a TerminateInstances request may come from only some
+// of its instances: an invalid or unknown ID (InvalidInstanceID.*), or an instance with
+// termination protection (OperationNotPermitted).
+func instanceFailure(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return strings.HasPrefix(apiErr.ErrorCode(), "InvalidInstanceID.") || apiErr.ErrorCode() == "OperationNotPermitted"
+}
+
+// Terminates the instances in batches (of options.BatchSize IDs),
+// sending options.Concurrency requests at the same time.
+func terminateInstances(ec2client *ec2.Client, instanceIdList []string, options DeleteOptions) *report.Result {
+	batchSize := options.BatchSize
+	if batchSize <= 0 || batchSize > MaxBatchSize {
+		batchSize = MaxBatchSize
+	}
+	var batches [][]string
+	instanceIdList = unique(instanceIdList)
+	for start := 0; start < len(instanceIdList); start += batchSize {
+		batches = append(batches, instanceIdList[start:min(start+batchSize, len(instanceIdList))])
+	}
+
+	// each batch is paced and retried by the throttle of fleet.Run,
+	// and the requests of its halves by the same throttle
+	throttle := fleet.NewThrottle(fleet.Options{})
+	results, _ := fleet.Run(batches, fleet.Options{Concurrency: options.Concurrency, Throttle: throttle}, func(batch []string) (*report.Result, error) {
+		return terminateBatch(ec2client, batch, throttle, options)
+	})
+
+	// merges the results of the batches, in the order of the IDs
+	result := report.New(actionDelete)
+	for _, batchResult := range results {
+		if batchResult.Err != nil {
+			result.Merge(batchError(batchResult.Item, batchResult.Err, options))
+		} else if batchResult.Value != nil {
+			result.Merge(batchResult.Value)
 		}
 	}
+	return result
+}
+
+// Terminates the instances like terminateInstances, then retries the deletions
+// that failed with a retryable error (up to options.Retries times).
+func terminateWithRetries(ec2client *ec2.Client, instanceIdList []string, options DeleteOptions) *report.Result {
+	result := terminateInstances(ec2client, instanceIdList, options)
+	retryDelay := options.RetryDelay
+	if retryDelay <= 0 {
+		retryDelay = 2 * time.Second
+	}
+	for retry := 1; retry <= options.Retries; retry++ {
+		failed := result.Retryable()
+		if len(failed) == 0 {
+			break
+		}
+		time.Sleep(time.Duration(retry) * retryDelay)
+		fmt.Fprintf(options.messages(), "Retrying the deletion of %d instances (retry %d/%d)...\n", len(failed), retry, options.Retries)
+		result.Replace(failed, terminateInstances(ec2client, failed, options))
+	}
+	return result
+}
+
+// Returns the list without duplicates (a duplicate ID would fail the TerminateInstances request).
+func unique(list []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// Permanently deletes the EC2 instances of IDs given in parameter (in a list).
+// The instances are deleted in batches, several batches at the same time,
+// and the failed deletions can be retried (see DeleteOptions).
+// The result lists what happened to each instance, and is returned
+// even if some instances couldn't be deleted (along with a *DeleteError).
+func DeleteInstances(ec2client *ec2.Client, instanceIdList []string, options DeleteOptions) (*DeleteResult, error) {
+	result := &DeleteResult{Result: *terminateWithRetries(ec2client, instanceIdList, options)}
+	waitErr := result.waitTerminated(ec2client, options)
 	// at the end, if some instances coudln't be deleted,
 	// we return an error informing how many failed
-	if fails > 0 {
-		return fmt.Errorf("error deleting multiple instances: %d instances were successfully deleted, and %d instances couldn't be deleted", success, fails)
+	if result.Failed > 0 {
+		return result, errors.Join(result.Err(), waitErr)
 	}
-	fmt.Printf("%d instances were successfully deleted.\n", success)
-	return nil
+	if waitErr != nil {
+		return result, waitErr
+	}
+	if options.DryRun {
+		fmt.Fprintf(options.messages(), "Dry run: %d instances would be deleted.\n", result.Succeeded)
+		return result, nil
+	}
+	fmt.Fprintf(options.messages(), "%d instances were successfully deleted.\n", result.Succeeded)
+	return result, nil
 }
 
 // Returns a list containing the ID of all instances owned.
 // If print=true, instances ID are also printed.
 func FindAllInstanceID(ec2client *ec2.Client, print bool) ([]string, error) {
-	var instanceIDs []string
-
-	// describe all instances owned
-	describeInstanceOutput, err := ec2client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{})
-	if err != nil {
-		return instanceIDs, fmt.Errorf("fetching info on instances failed: %w", err)
-	}
-
-	// add each non-terminated/non-terminating instance found
-	// to the list
-	for _, reservation := range describeInstanceOutput.Reservations {
-		for _, instance := range reservation.Instances {
-			if instance.State.Name != "shutting-down" && instance.State.Name != "terminated" {
-				instanceIDs = append(instanceIDs, *instance.InstanceId)
-				if print {
-					fmt.Println(*instance.InstanceId)
-				}
-			}
-		}
-	}
-	// returns the list
-	return instanceIDs, nil
+	return findInstanceIDs(ec2client, &ec2.DescribeInstancesInput{}, nil, print)
 }
 
 // Returns a list containing the ID of all the instances
 // that have the tag "key=value", and not all owned instances.
 // If print=true, instances ID are also printed.
 func FindInstanceIDsByTag(ec2client *ec2.Client, tagKey string, tagValue string, print bool) ([]string, error) {
-	var instanceIDs []string
-
 	// creates a filter for the describe instances request
 	// to filter the instances that have the tag "key=value"
 	tag := "tag:" + tagKey
@@ -90,75 +305,203 @@ func FindInstanceIDsByTag(ec2client *ec2.Client, tagKey string, tagValue string,
 		},
 	}
 
-	describeInstanceInput := &ec2.DescribeInstancesInput{Filters: filters}
-
-	// make the request
-	describeInstanceOutput, err := ec2client.DescribeInstances(context.TODO(), describeInstanceInput)
+	instanceIDs, err := findInstanceIDs(ec2client, &ec2.DescribeInstancesInput{Filters: filters}, nil, print)
 	if err != nil {
 		return instanceIDs, fmt.Errorf("fetching info on instances with tag %s=%s failed: %w", tagKey, tagValue, err)
 	}
+	return instanceIDs, nil
+}
 
-	// add each non-terminated/non-terminating instance found
-	// to the list
-	for _, reservation := range describeInstanceOutput.Reservations {
-		for _, instance := range reservation.Instances {
-			if instance.State.Name != "shutting-down" && instance.State.Name != "terminated" {
-				instanceIDs = append(instanceIDs, *instance.InstanceId)
-				if print {
-					fmt.Println(*instance.InstanceId)
-				}
-			}
-		}
+// Returns a list containing the ID of all the instances selected
+// by the selector (ex: "tag:env=dev,name~web-*", see package selector).
+// If print=true, instances ID are also printed.
+func FindInstanceIDsBySelector(ec2client *ec2.Client, sel *selector.Selector, print bool) ([]string, error) {
+	instanceIDs, err := findInstanceIDs(ec2client, &ec2.DescribeInstancesInput{Filters: sel.Filters}, sel.Match, print)
+	if err != nil {
+		return instanceIDs, fmt.Errorf("fetching info on instances selected by %q failed: %w", sel, err)
 	}
-	// returns the list
 	return instanceIDs, nil
 }
 
+// Returns the IDs of the non-terminated/non-terminating instances described
+// by the request given in parameter (going through all the pages of results),
+// and for which match returns true (if match isn't nil).
+func findInstanceIDs(ec2client *ec2.Client, input *ec2.DescribeInstancesInput, match func(types.Instance) bool, print bool) ([]string, error) {
+	var instanceIDs []string
+	err := describeEC2.EachInstance(ec2client, input, func(instance types.Instance) error {
+		if match != nil && !match(instance) {
+			return nil
+		}
+		if !describeEC2.IsTerminated(instance) {
+			instanceIDs = append(instanceIDs, *instance.InstanceId)
+			if print {
+				fmt.Println(*instance.InstanceId)
+			}
+		}
+		return nil
+	})
+	return instanceIDs, err
+}
+
 // Permanently delete all the EC2 instances owned by the user.
-// This first asks the user for confirmation.
-func DeleteAllInstances(ec2client *ec2.Client) error {
-	// asks user for confirmation before deleting all the instances
-	fmt.Println("> You asked to delete **ALL** EC2 instances owned on your account. This action is non-reversible. Proceed? (Y/N): ")
-	validAnswer := false
-	// prompt loop (until user gives an valid answer)
-	for !validAnswer {
-		var answer string
-		_, err := fmt.Scan(&answer)
-		if err != nil {
-			return fmt.Errorf("error reading user input: %w", err)
+// The instances to delete are first printed, without the ones kept by the guardrails
+// (protection tag, policy; see DeleteOptions.Guardrails), and the confirmer is asked
+// to confirm by typing the number of instances (or the account ID)
+// (use confirm.NewTerminal() to ask the user).
+// If the action is aborted, the result is nil.
+// The deletion continues when some instances can't be deleted: the result lists
+// what happened to each instance, and the failed deletions can be retried (see DeleteOptions).
+// If some instances have termination protection, the confirmer is asked a second time
+// if their protection should be disabled, so that they're deleted too.
+// In dry run mode, nothing is asked and the instances that would be deleted are printed.
+func DeleteAllInstances(ec2client *ec2.Client, confirmer confirm.Confirmer, options DeleteOptions) (*DeleteResult, error) {
+	return deleteGuarded(ec2client, &ec2.DescribeInstancesInput{}, nil, confirmer, options,
+		confirm.ActionDeleteAll, "You asked to delete **ALL** EC2 instances owned on your account. This action is non-reversible.")
+}
+
+// Permanently deletes the EC2 instances selected by the selector
+// (ex: "tag:env=dev,name~web-*", see package selector), with the same
+// guardrails and confirmation as DeleteAllInstances.
+func DeleteSelected(ec2client *ec2.Client, sel *selector.Selector, confirmer confirm.Confirmer, options DeleteOptions) (*DeleteResult, error) {
+	return deleteGuarded(ec2client, &ec2.DescribeInstancesInput{Filters: sel.Filters}, sel.Match, confirmer, options,
+		confirm.ActionDeleteSelected, fmt.Sprintf("You asked to delete the EC2 instances selected by %q. This action is non-reversible.", sel))
+}
+
+// Deletes the non-terminated instances described by the request given in parameter
+// (for which match returns true, if match isn't nil), after applying the guardrails
+// and asking the confirmer to confirm the action (with the message given in parameter).
+func deleteGuarded(ec2client *ec2.Client, input *ec2.DescribeInstancesInput, match func(types.Instance) bool, confirmer confirm.Confirmer, options DeleteOptions, action string, message string) (*DeleteResult, error) {
+	// fetches the instances to delete
+	var instances []types.Instance
+	err := describeEC2.EachInstance(ec2client, input, func(instance types.Instance) error {
+		if (match == nil || match(instance)) && !describeEC2.IsTerminated(instance) {
+			instances = append(instances, instance)
 		}
-		answer = strings.ToUpper(answer)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching info on instances failed: %w", err)
+	}
 
-		switch answer {
-		case "Y":
-			validAnswer = true
-		case "N":
-			fmt.Println("Action aborted.")
-			return nil
-		default:
-			fmt.Println("Invalid output, please answer \"Y\" or \"N\": ")
+	// keeps the instances protected by tag or by the policy, and previews the deletion
+	guardrails := options.Guardrails
+	deletable, skipped, err := guardrails.Filter(instances)
+	if err != nil {
+		return nil, err
+	}
+	result := newDeleteResult()
+	result.Skipped = skipped
+	if len(deletable) == 0 {
+		printPreview(options.messages(), deletable, skipped)
+		fmt.Fprintln(options.messages(), "No instance found.")
+		fmt.Fprintln(options.messages(), "Done")
+		return result, nil
+	}
+	if err := guardrails.CheckThreshold(len(deletable)); err != nil {
+		return nil, err
+	}
+	printPreview(options.messages(), deletable, skipped)
+
+	// asks for confirmation, by typing the number of instances or the account ID
+	if options.DryRun {
+		confirmer = confirm.AutoYes{}
+	}
+	expected, err := guardrails.ExpectedAnswer(ec2client, len(deletable))
+	if err != nil {
+		return nil, err
+	}
+	proceed, err := confirm.Typed(confirmer, action, message, expected)
+	if err != nil {
+		return nil, err
+	}
+	if !proceed {
+		fmt.Fprintln(options.messages(), "Action aborted.")
+		return nil, nil
+	}
+
+	// Terminates the instances (in batches), continuing past failures
+	instanceIDs := make([]string, len(deletable))
+	for i, instance := range deletable {
+		instanceIDs[i] = *instance.InstanceId
+	}
+	deleted := terminateWithRetries(ec2client, instanceIDs, options)
+	result.Merge(deleted)
+
+	// offers to disable the termination protection of the protected instances
+	err = deleteProtected(ec2client, confirmer, result, options)
+	if err != nil {
+		fmt.Fprintln(options.messages(), result.Summary(options.DryRun))
+		return result, err
+	}
+	waitErr := result.waitTerminated(ec2client, options)
+
+	fmt.Fprintln(options.messages(), result.Summary(options.DryRun))
+	if result.Failed > 0 {
+		return result, errors.Join(result.Err(), waitErr)
+	}
+	if waitErr != nil {
+		return result, waitErr
+	}
+	fmt.Fprintln(options.messages(), "Done")
+	return result, nil
+}
+
+// Finds the instances of the result that couldn't be deleted because of their
+// termination protection, and if the confirmer agrees, disables their
+// protection and deletes them (updating the result).
+func deleteProtected(ec2client *ec2.Client, confirmer confirm.Confirmer, result *DeleteResult, options DeleteOptions) error {
+	var protected []string
+	for _, instance := range result.Instances {
+		// OperationNotPermitted can have other causes: we check the attribute
+		if instance.ErrorCode != "OperationNotPermitted" {
+			continue
+		}
+		attribute, err := ec2client.DescribeInstanceAttribute(context.TODO(), &ec2.DescribeInstanceAttributeInput{
+			InstanceId: &instance.InstanceID,
+			Attribute:  types.InstanceAttributeNameDisableApiTermination,
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't check termination protection of instance %s: %w", instance.InstanceID, err)
 		}
+		if attribute.DisableApiTermination != nil && attribute.DisableApiTermination.Value != nil && *attribute.DisableApiTermination.Value {
+			protected = append(protected, instance.InstanceID)
+		}
+	}
+	if len(protected) == 0 {
+		return nil
 	}
 
-	// Fetches the IDs of all the instances owned by user
-	instancesIDs, err := FindAllInstanceID(ec2client, false)
+	// second confirmation, specific to the protected instances
+	proceed, err := confirmer.Confirm(confirm.ActionDisableProtection, fmt.Sprintf("%d instances have termination protection: %s. Disable their termination protection and delete them too?", len(protected), strings.Join(protected, " ")))
 	if err != nil {
 		return err
 	}
+	if !proceed {
+		fmt.Fprintln(options.messages(), "Protected instances kept.")
+		return nil
+	}
 
-	// Terminates all instances found
-	if len(instancesIDs) == 0 {
-		fmt.Println("No instance found.")
-	} else {
-		for _, id := range instancesIDs {
-			err = DeleteInstance(ec2client, id)
-			if err != nil {
-				return err
-			}
+	var unprotected []string
+	for _, id := range protected {
+		disable := false
+		_, err := ec2client.ModifyInstanceAttribute(context.TODO(), &ec2.ModifyInstanceAttributeInput{
+			InstanceId:            &id,
+			DisableApiTermination: &types.AttributeBooleanValue{Value: &disable},
+			DryRun:                &options.DryRun,
+		})
+		if err != nil && !(options.DryRun && dryrun.Succeeded(err)) {
+			fmt.Fprintf(options.messages(), "Couldn't disable termination protection of instance %s: %s\n", id, err)
+			continue
 		}
-		fmt.Println("All instances successfully deleted.")
+		if options.DryRun {
+			fmt.Fprintf(options.messages(), "Dry run: termination protection of instance %s would be disabled.\n", id)
+			continue
+		}
+		fmt.Fprintf(options.messages(), "Termination protection of instance %s disabled.\n", id)
+		unprotected = append(unprotected, id)
+	}
+	if len(unprotected) > 0 {
+		result.Replace(unprotected, terminateWithRetries(ec2client, unprotected, options))
 	}
-
-	fmt.Println("Done")
 	return nil
 }
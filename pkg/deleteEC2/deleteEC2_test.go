@@ -0,0 +1,266 @@
+package deleteEC2
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/smithy-go"
+)
+
+// Returns an EC2 client sending its requests to a fake EC2 API: handle
+// receives the action and the parameters of each request, and returns
+// the HTTP status and the XML body of the answer.
+func fakeEC2(t *testing.T, handle func(action string, params url.Values) (int, string)) *ec2.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		status, body := handle(r.Form.Get("Action"), r.Form)
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return ec2.New(ec2.Options{Region: "us-east-1", BaseEndpoint: &server.URL, RetryMaxAttempts: 1})
+}
+
+// Returns the instance IDs of a TerminateInstances request.
+func instanceIDs(params url.Values) []string {
+	var ids []string
+	for i := 1; params.Has(fmt.Sprintf("InstanceId.%d", i)); i++ {
+		ids = append(ids, params.Get(fmt.Sprintf("InstanceId.%d", i)))
+	}
+	return ids
+}
+
+// Returns the answer of a successful TerminateInstances request.
+func terminated(ids []string) (int, string) {
+	var items strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&items, "<item><instanceId>%s</instanceId><currentState><code>32</code><name>shutting-down</name></currentState>"+
+			"<previousState><code>16</code><name>running</name></previousState></item>", id)
+	}
+	return http.StatusOK, "<TerminateInstancesResponse><instancesSet>" + items.String() + "</instancesSet></TerminateInstancesResponse>"
+}
+
+// Returns the answer of a request that failed with the AWS error code given in parameter.
+func failed(code string) (int, string) {
+	return http.StatusBadRequest, "<Response><Errors><Error><Code>" + code + "</Code><Message>" + code + "</Message></Error></Errors></Response>"
+}
+
+func TestDeleteResultErr(t *testing.T) {
+	result := newDeleteResult()
+	result.AddDryRun("i-1")
+	if err := result.Err(); err != nil {
+		t.Errorf("Err() without failure = %v, expected nil", err)
+	}
+
+	notFound := &smithy.GenericAPIError{Code: "InvalidInstanceID.NotFound"}
+	throttled := &smithy.GenericAPIError{Code: "RequestLimitExceeded"}
+	result.AddError("i-2", notFound)
+	result.AddError("i-3", throttled)
+	if result.Succeeded != 1 || result.Failed != 2 {
+		t.Errorf("result: %d succeeded and %d failed, expected 1 and 2", result.Succeeded, result.Failed)
+	}
+
+	err := result.Err()
+	var deleteErr *DeleteError
+	if !errors.As(err, &deleteErr) {
+		t.Fatalf("Err() = %v, expected a *DeleteError", err)
+	}
+	if deleteErr.Succeeded != 1 || len(deleteErr.Errors) != 2 {
+		t.Errorf("Err() = %d succeeded and %d errors, expected 1 and 2", deleteErr.Succeeded, len(deleteErr.Errors))
+	}
+	// the errors of the instances can be found with errors.As and errors.Is
+	var instanceErr *InstanceError
+	if !errors.As(err, &instanceErr) || instanceErr.InstanceID != "i-2" {
+		t.Errorf("errors.As(Err()) = %v, expected the error of i-2", instanceErr)
+	}
+	if !errors.Is(err, throttled) {
+		t.Errorf("errors.Is(Err(), throttled) = false, expected true")
+	}
+
+	// the error of each instance is recorded in the result
+	for i, instance := range result.Instances[1:] {
+		instanceErr := deleteErr.Errors[i]
+		if instance.Error != instanceErr.Error() || instance.ErrorCode != instanceErr.Code || instance.Retryable != instanceErr.Retryable {
+			t.Errorf("result of %s = %+v, expected the error %+v", instance.InstanceID, instance, instanceErr)
+		}
+	}
+	if ids := result.IDs(); !reflect.DeepEqual(ids, []string{"i-1"}) {
+		t.Errorf("IDs() = %v, expected [i-1]", ids)
+	}
+}
+
+func TestUnique(t *testing.T) {
+	tests := []struct {
+		list     []string
+		expected []string
+	}{
+		{nil, nil},
+		{[]string{"i-1", "i-2"}, []string{"i-1", "i-2"}},
+		{[]string{"i-1", "i-2", "i-1", "i-3", "i-2"}, []string{"i-1", "i-2", "i-3"}},
+	}
+	for _, test := range tests {
+		if result := unique(test.list); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("unique(%v) = %v, expected %v", test.list, result, test.expected)
+		}
+	}
+}
+
+func TestTerminateWithRetries(t *testing.T) {
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	ec2client := fakeEC2(t, func(action string, params url.Values) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids := instanceIDs(params)
+		if len(ids) != 1 {
+			t.Errorf("TerminateInstances(%v), expected one instance per request", ids)
+		}
+		attempts[ids[0]]++
+		switch {
+		case ids[0] == "i-gone":
+			return failed("InvalidInstanceID.NotFound")
+		case ids[0] == "i-busy" && attempts[ids[0]] < 3:
+			return failed("IncorrectInstanceState")
+		case ids[0] == "i-stuck":
+			return failed("IncorrectInstanceState")
+		}
+		return terminated(ids)
+	})
+
+	options := DeleteOptions{BatchSize: 1, Retries: 2, RetryDelay: time.Millisecond, Output: io.Discard}
+	result := terminateWithRetries(ec2client, []string{"i-ok", "i-gone", "i-busy", "i-stuck"}, options)
+
+	// only the retryable errors are retried, at most options.Retries times
+	expected := map[string]int{"i-ok": 1, "i-gone": 1, "i-busy": 3, "i-stuck": 3}
+	if !reflect.DeepEqual(attempts, expected) {
+		t.Errorf("attempts = %v, expected %v", attempts, expected)
+	}
+	if result.Succeeded != 2 || result.Failed != 2 {
+		t.Errorf("result: %d succeeded and %d failed, expected 2 and 2", result.Succeeded, result.Failed)
+	}
+	var deleted []string
+	for _, instance := range result.Instances {
+		if instance.Done {
+			deleted = append(deleted, instance.InstanceID)
+		}
+	}
+	sort.Strings(deleted)
+	if !reflect.DeepEqual(deleted, []string{"i-busy", "i-ok"}) {
+		t.Errorf("deleted instances = %v, expected [i-busy i-ok]", deleted)
+	}
+	summary := "2 instances deleted, 2 failed (IncorrectInstanceState: 1, InvalidInstanceID.NotFound: 1)"
+	if result.Summary(false) != summary {
+		t.Errorf("Summary() = %q, expected %q", result.Summary(false), summary)
+	}
+}
+
+func TestTerminateBatches(t *testing.T) {
+	var mu sync.Mutex
+	var requests [][]string
+	ec2client := fakeEC2(t, func(action string, params url.Values) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids := instanceIDs(params)
+		requests = append(requests, ids)
+		for _, id := range ids {
+			switch id {
+			case "i-gone":
+				return failed("InvalidInstanceID.NotFound")
+			case "i-protected":
+				return failed("OperationNotPermitted")
+			case "i-denied":
+				return failed("UnauthorizedOperation")
+			}
+		}
+		return terminated(ids)
+	})
+
+	tests := []struct {
+		name      string
+		ids       []string
+		batchSize int
+		requests  int      // number of TerminateInstances requests
+		failed    []string // instances whose deletion failed
+	}{
+		{"batches", []string{"i-1", "i-2", "i-3", "i-4", "i-5"}, 2, 3, nil},
+		// the batch is split until the invalid ID and the protected instance are alone
+		// in their request: [1 gone 3 protected] [1 gone] [3 protected] [1] [gone] [3] [protected]
+		{"per-instance errors", []string{"i-1", "i-gone", "i-3", "i-protected"}, 0, 7, []string{"i-gone", "i-protected"}},
+		// an error that isn't due to some instances is recorded for the whole batch
+		{"batch error", []string{"i-denied", "i-2", "i-3", "i-4"}, 0, 1, []string{"i-denied", "i-2", "i-3", "i-4"}},
+	}
+	for _, test := range tests {
+		requests = nil
+		result := terminateInstances(ec2client, test.ids, DeleteOptions{BatchSize: test.batchSize, Concurrency: 1, Output: io.Discard})
+		if len(requests) != test.requests {
+			t.Errorf("%s: %d requests %v, expected %d", test.name, len(requests), requests, test.requests)
+		}
+		var failedIDs []string
+		for _, instance := range result.Instances {
+			if instance.Error != "" {
+				failedIDs = append(failedIDs, instance.InstanceID)
+			}
+		}
+		if result.Succeeded+result.Failed != len(test.ids) || !reflect.DeepEqual(failedIDs, test.failed) {
+			t.Errorf("%s: %d succeeded, failed instances %v, expected %d and %v",
+				test.name, result.Succeeded, failedIDs, len(test.ids)-len(test.failed), test.failed)
+		}
+	}
+}
+
+func TestTerminateThrottled(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	ec2client := fakeEC2(t, func(action string, params url.Values) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			return failed("RequestLimitExceeded")
+		}
+		return terminated(instanceIDs(params))
+	})
+	// the throttled request is retried once by the throttle, and not split
+	result := terminateInstances(ec2client, []string{"i-1", "i-2"}, DeleteOptions{Output: io.Discard})
+	if requests != 2 || result.Succeeded != 2 {
+		t.Errorf("%d requests and %d instances deleted, expected 2 and 2", requests, result.Succeeded)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	result := newDeleteResult()
+	result.AddDryRun("i-1")
+	result.AddDryRun("i-2")
+	tests := []struct {
+		dryRun   bool
+		expected string
+	}{
+		{false, "2 instances deleted, 0 failed"},
+		{true, "2 instances would be deleted, 0 failed"},
+	}
+	for _, test := range tests {
+		if summary := result.Summary(test.dryRun); summary != test.expected {
+			t.Errorf("Summary(%v) = %q, expected %q", test.dryRun, summary, test.expected)
+		}
+	}
+
+	result.AddError("i-3", errors.New("connection reset by peer"))
+	expected := "2 instances deleted, 1 failed (other error: 1)"
+	if summary := result.Summary(false); summary != expected {
+		t.Errorf("Summary(false) = %q, expected %q", summary, expected)
+	}
+}
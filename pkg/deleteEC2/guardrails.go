@@ -0,0 +1,232 @@
+package deleteEC2
+
+import (
+	"aws/pkg/describeEC2"
+	"aws/pkg/output"
+	"aws/pkg/selector"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"gopkg.in/yaml.v3"
+)
+
+// Tag of the instances that are never deleted by the bulk deletions (by default).
+const DefaultProtectionTag = "do-not-delete=true"
+
+// Safety checks of the bulk deletions (all instances, or instances selected by name,
+// tag or selector expression), also applied to the stops and reboots of selected
+// instances (see lifecycleEC2.StopSelected). The zero value only honors DefaultProtectionTag.
+type Guardrails struct {
+	// maximum number of instances deleted at once, unless Force is set (0: no limit)
+	MaxInstances int
+	Force        bool
+	// "key=value" tag of the instances that are never deleted
+	// (default: DefaultProtectionTag, "-" to delete the tagged instances too)
+	ProtectionTag string
+	// rules on the instances that can be deleted (nil: all instances can be)
+	Policy *Policy
+	// if true, the user confirms by typing the ID of the AWS account,
+	// instead of the number of instances to delete
+	ConfirmAccountID bool
+}
+
+// Allowlist and denylist of the instances that can be deleted, as selector
+// expressions (ex: "tag:env=dev|test", see package selector).
+// An instance can be deleted if it matches one of the Allow expressions
+// (or if Allow is empty), and none of the Deny expressions.
+type Policy struct {
+	Allow []string `json:"allow" yaml:"allow"`
+	Deny  []string `json:"deny" yaml:"deny"`
+
+	allow []*selector.Selector
+	deny  []*selector.Selector
+}
+
+// Reads the policy file of path given in parameter (YAML or JSON), ex:
+//
+//	allow:
+//	  - tag:env=dev|test
+//	deny:
+//	  - name~*-prod*
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read policy file: %w", err)
+	}
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	if err := policy.compile(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return &policy, nil
+}
+
+// Parses the selector expressions of the policy.
+func (p *Policy) compile() error {
+	p.allow, p.deny = nil, nil
+	for _, expr := range p.Allow {
+		sel, err := selector.Parse(expr)
+		if err != nil {
+			return fmt.Errorf("allow %q: %w", expr, err)
+		}
+		p.allow = append(p.allow, sel)
+	}
+	for _, expr := range p.Deny {
+		sel, err := selector.Parse(expr)
+		if err != nil {
+			return fmt.Errorf("deny %q: %w", expr, err)
+		}
+		p.deny = append(p.deny, sel)
+	}
+	return nil
+}
+
+// Returns an empty string if the policy allows to delete the instance,
+// else the reason why it can't be deleted.
+func (p *Policy) check(instance types.Instance) (string, error) {
+	if len(p.allow) != len(p.Allow) || len(p.deny) != len(p.Deny) {
+		if err := p.compile(); err != nil {
+			return "", err
+		}
+	}
+	for i, sel := range p.deny {
+		if sel.MatchAll(instance) {
+			return fmt.Sprintf("denied by policy (%s)", p.Deny[i]), nil
+		}
+	}
+	if len(p.allow) == 0 {
+		return "", nil
+	}
+	for _, sel := range p.allow {
+		if sel.MatchAll(instance) {
+			return "", nil
+		}
+	}
+	return "not allowed by policy", nil
+}
+
+// An instance that was selected, but is kept by the guardrails.
+type SkippedInstance struct {
+	InstanceID string `json:"instance_id" yaml:"instance_id"`
+	Reason     string `json:"reason" yaml:"reason"`
+}
+
+// Splits the instances given in parameter between the ones that can be deleted
+// and the ones kept by the protection tag or the policy.
+func (g Guardrails) Filter(instances []types.Instance) ([]types.Instance, []SkippedInstance, error) {
+	protectionTag := g.ProtectionTag
+	if protectionTag == "" {
+		protectionTag = DefaultProtectionTag
+	}
+	tagKey, tagValue, _ := strings.Cut(protectionTag, "=")
+
+	var deletable []types.Instance
+	var skipped []SkippedInstance
+	for _, instance := range instances {
+		if protectionTag != "-" && hasTag(instance, tagKey, tagValue) {
+			skipped = append(skipped, SkippedInstance{InstanceID: *instance.InstanceId, Reason: "protected by tag " + protectionTag})
+			continue
+		}
+		if g.Policy != nil {
+			reason, err := g.Policy.check(instance)
+			if err != nil {
+				return nil, nil, err
+			}
+			if reason != "" {
+				skipped = append(skipped, SkippedInstance{InstanceID: *instance.InstanceId, Reason: reason})
+				continue
+			}
+		}
+		deletable = append(deletable, instance)
+	}
+	return deletable, skipped, nil
+}
+
+// Returns true if the instance has the tag "key=value" (the value is not case sensitive).
+func hasTag(instance types.Instance, key string, value string) bool {
+	for _, tag := range instance.Tags {
+		if tag.Key != nil && *tag.Key == key && tag.Value != nil && strings.EqualFold(*tag.Value, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns an error if there are more instances to delete than the threshold
+// of the guardrails (unless Force is set).
+func (g Guardrails) CheckThreshold(count int) error {
+	if err := g.CheckActionThreshold("delete", count); err != nil {
+		return fmt.Errorf("%w: force to delete them anyway", err)
+	}
+	return nil
+}
+
+// Returns an error if there are more instances than the threshold of the
+// guardrails for the action given in parameter (ex: "stop"), unless Force is set.
+func (g Guardrails) CheckActionThreshold(action string, count int) error {
+	if g.MaxInstances > 0 && count > g.MaxInstances && !g.Force {
+		return fmt.Errorf("refusing to %s %d instances at once (more than the limit of %d)", action, count, g.MaxInstances)
+	}
+	return nil
+}
+
+// Returns the answer the user has to type to confirm the deletion:
+// the number of instances, or the ID of the AWS account (see ConfirmAccountID).
+func (g Guardrails) ExpectedAnswer(ec2client *ec2.Client, count int) (string, error) {
+	if !g.ConfirmAccountID {
+		return fmt.Sprint(count), nil
+	}
+	return accountID(ec2client)
+}
+
+// Returns the ID of the AWS account used by the EC2 client (requested to STS,
+// with the same region and credentials).
+func accountID(ec2client *ec2.Client) (string, error) {
+	options := ec2client.Options()
+	stsclient := sts.New(sts.Options{
+		Region:      options.Region,
+		Credentials: options.Credentials,
+		HTTPClient:  options.HTTPClient,
+	})
+	identity, err := stsclient.GetCallerIdentity(context.TODO(), &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("couldn't get the AWS account ID: %w", err)
+	}
+	return *identity.Account, nil
+}
+
+// Prints the table of the instances that will be deleted,
+// and the instances kept by the guardrails, to w.
+func printPreview(w io.Writer, deletable []types.Instance, skipped []SkippedInstance) {
+	PrintPreview(w, "delete", deletable, skipped)
+}
+
+// Prints to w the table of the instances on which the action (ex: "stop")
+// will be done, and the instances kept by the guardrails.
+func PrintPreview(w io.Writer, action string, instances []types.Instance, skipped []SkippedInstance) {
+	if len(instances) > 0 {
+		list := &describeEC2.InstanceList{}
+		for _, instance := range instances {
+			list.Instances = append(list.Instances, describeEC2.NewInstance(instance))
+		}
+		fmt.Fprintf(w, "%d instances to %s:\n", len(instances), action)
+		header, rows := list.Table()
+		output.PrintTable(w, header, rows)
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(w, "%d instances kept:\n", len(skipped))
+		var rows [][]string
+		for _, s := range skipped {
+			rows = append(rows, []string{s.InstanceID, s.Reason})
+		}
+		output.PrintTable(w, []string{"INSTANCE ID", "REASON"}, rows)
+	}
+}
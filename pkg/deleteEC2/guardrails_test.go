@@ -0,0 +1,143 @@
+package deleteEC2
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Returns an instance with the tags "key=value" given in parameter.
+func instance(id string, tags ...string) types.Instance {
+	result := types.Instance{InstanceId: &id, State: &types.InstanceState{Name: types.InstanceStateNameRunning}}
+	for i := 0; i+1 < len(tags); i += 2 {
+		key, value := tags[i], tags[i+1]
+		result.Tags = append(result.Tags, types.Tag{Key: &key, Value: &value})
+	}
+	return result
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := &Policy{Allow: []string{"tag:env=dev|test"}, Deny: []string{"name~*prod*"}}
+	tests := []struct {
+		instance types.Instance
+		reason   string
+	}{
+		{instance("i-1", "env", "dev", "Name", "web"), ""},
+		{instance("i-2", "env", "test"), ""},
+		{instance("i-3", "env", "dev", "Name", "web-prod"), "denied by policy (name~*prod*)"},
+		{instance("i-4", "env", "staging"), "not allowed by policy"},
+		{instance("i-5"), "not allowed by policy"},
+	}
+	for _, test := range tests {
+		reason, err := policy.check(test.instance)
+		if err != nil || reason != test.reason {
+			t.Errorf("check(%s) = %q, %v, expected %q", *test.instance.InstanceId, reason, err, test.reason)
+		}
+	}
+
+	// without allow expressions, all the instances that aren't denied can be deleted
+	denyOnly := &Policy{Deny: []string{"tag:env=prod"}}
+	if reason, err := denyOnly.check(instance("i-6")); reason != "" || err != nil {
+		t.Errorf("check(i-6) = %q, %v, expected no reason", reason, err)
+	}
+
+	invalid := &Policy{Allow: []string{"size>3"}}
+	if _, err := invalid.check(instance("i-7")); err == nil {
+		t.Errorf("check() with an invalid expression: expected an error")
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "policy.yaml")
+	os.WriteFile(valid, []byte("allow:\n  - tag:env=dev|test\ndeny:\n  - name~*-prod*\n"), 0o644)
+	policy, err := LoadPolicy(valid)
+	if err != nil {
+		t.Fatalf("LoadPolicy() = %v", err)
+	}
+	if !reflect.DeepEqual(policy.Allow, []string{"tag:env=dev|test"}) || !reflect.DeepEqual(policy.Deny, []string{"name~*-prod*"}) {
+		t.Errorf("LoadPolicy() = allow %q, deny %q", policy.Allow, policy.Deny)
+	}
+
+	invalid := filepath.Join(dir, "invalid.yaml")
+	os.WriteFile(invalid, []byte("deny:\n  - nothing\n"), 0o644)
+	for _, path := range []string{invalid, filepath.Join(dir, "missing.yaml")} {
+		if _, err := LoadPolicy(path); err == nil {
+			t.Errorf("LoadPolicy(%s): expected an error", filepath.Base(path))
+		}
+	}
+}
+
+func TestGuardrailsFilter(t *testing.T) {
+	instances := []types.Instance{
+		instance("i-1", "env", "dev"),
+		instance("i-2", "env", "dev", "do-not-delete", "TRUE"),
+		instance("i-3", "env", "prod"),
+		instance("i-4", "env", "dev", "keep", "yes"),
+	}
+	tests := []struct {
+		guardrails Guardrails
+		deletable  []string
+		skipped    []SkippedInstance
+	}{
+		{
+			Guardrails{},
+			[]string{"i-1", "i-3", "i-4"},
+			[]SkippedInstance{{"i-2", "protected by tag do-not-delete=true"}},
+		},
+		{
+			Guardrails{ProtectionTag: "keep=yes"},
+			[]string{"i-1", "i-2", "i-3"},
+			[]SkippedInstance{{"i-4", "protected by tag keep=yes"}},
+		},
+		{
+			Guardrails{ProtectionTag: "-"},
+			[]string{"i-1", "i-2", "i-3", "i-4"},
+			nil,
+		},
+		{
+			Guardrails{Policy: &Policy{Deny: []string{"tag:env=prod"}}},
+			[]string{"i-1", "i-4"},
+			[]SkippedInstance{{"i-2", "protected by tag do-not-delete=true"}, {"i-3", "denied by policy (tag:env=prod)"}},
+		},
+	}
+	for i, test := range tests {
+		deletable, skipped, err := test.guardrails.Filter(instances)
+		if err != nil {
+			t.Errorf("test %d: Filter() = %v", i, err)
+			continue
+		}
+		var ids []string
+		for _, instance := range deletable {
+			ids = append(ids, *instance.InstanceId)
+		}
+		if !reflect.DeepEqual(ids, test.deletable) {
+			t.Errorf("test %d: Filter() deletable = %v, expected %v", i, ids, test.deletable)
+		}
+		if !reflect.DeepEqual(skipped, test.skipped) {
+			t.Errorf("test %d: Filter() skipped = %v, expected %v", i, skipped, test.skipped)
+		}
+	}
+}
+
+func TestGuardrailsCheckThreshold(t *testing.T) {
+	tests := []struct {
+		guardrails Guardrails
+		count      int
+		refused    bool
+	}{
+		{Guardrails{}, 1000, false},
+		{Guardrails{MaxInstances: 10}, 10, false},
+		{Guardrails{MaxInstances: 10}, 11, true},
+		{Guardrails{MaxInstances: 10, Force: true}, 11, false},
+	}
+	for _, test := range tests {
+		err := test.guardrails.CheckThreshold(test.count)
+		if (err != nil) != test.refused {
+			t.Errorf("CheckThreshold(%d) with %+v = %v, expected refused: %v", test.count, test.guardrails, err, test.refused)
+		}
+	}
+}
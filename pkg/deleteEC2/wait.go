@@ -0,0 +1,37 @@
+package deleteEC2
+
+import (
+	"aws/pkg/describeEC2"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Default maximum time waited for the termination of the instances.
+const DefaultWaitTimeout = 10 * time.Minute
+
+// Waits until the instances of IDs given in parameter are terminated,
+// printing the progress in progress, for at most timeout (DefaultWaitTimeout if timeout <= 0).
+// Returns the last state of each instance ("terminated" for the terminated ones).
+// If some instances aren't terminated before the timeout (or if an instance
+// doesn't exist), their last known state is returned along with an error
+// (see describeEC2.WaitState).
+func WaitTerminated(ec2client *ec2.Client, instanceIds []string, timeout time.Duration, progress io.Writer) (map[string]string, error) {
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	return describeEC2.WaitState(ec2client, instanceIds, types.InstanceStateNameTerminated, timeout, progress)
+}
+
+// If options.Wait is set, waits until the deleted instances of the result
+// are terminated, and records their final state.
+func (r *DeleteResult) waitTerminated(ec2client *ec2.Client, options DeleteOptions) error {
+	if !options.Wait || options.DryRun {
+		return nil
+	}
+	states, err := WaitTerminated(ec2client, r.IDs(), options.WaitTimeout, options.messages())
+	r.SetFinalStates(states)
+	return err
+}
@@ -0,0 +1,123 @@
+package deleteEC2
+
+import (
+	"aws/pkg/describeEC2"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Returns the answer of a DescribeInstances request filtered by instance ID,
+// with the state of each instance of the filter.
+func described(params url.Values, states map[string]string) (int, string) {
+	var items strings.Builder
+	for i := 1; params.Has(fmt.Sprintf("Filter.1.Value.%d", i)); i++ {
+		id := params.Get(fmt.Sprintf("Filter.1.Value.%d", i))
+		state, ok := states[id]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&items, "<item><instanceId>%s</instanceId><instanceState><name>%s</name></instanceState></item>", id, state)
+	}
+	return http.StatusOK, "<DescribeInstancesResponse><reservationSet><item><instancesSet>" + items.String() +
+		"</instancesSet></item></reservationSet></DescribeInstancesResponse>"
+}
+
+func TestWaitTerminated(t *testing.T) {
+	defer func(interval time.Duration) { describeEC2.PollInterval = interval }(describeEC2.PollInterval)
+	describeEC2.PollInterval = time.Millisecond
+
+	var mu sync.Mutex
+	polls := 0
+	ec2client := fakeEC2(t, func(action string, params url.Values) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch action {
+		case "TerminateInstances":
+			return terminated(instanceIDs(params))
+		case "DescribeInstances":
+			polls++
+			// i-1 is terminated at the first poll, i-2 at the second one
+			// and i-3 is never terminated
+			states := map[string]string{"i-1": "terminated", "i-2": "shutting-down", "i-3": "shutting-down"}
+			if polls > 1 {
+				states["i-2"] = "terminated"
+			}
+			return described(params, states)
+		}
+		t.Errorf("unexpected request %s", action)
+		return failed("InvalidAction")
+	})
+
+	states, err := WaitTerminated(ec2client, []string{"i-1", "i-2"}, time.Second, io.Discard)
+	if err != nil {
+		t.Errorf("WaitTerminated(i-1, i-2) = %v", err)
+	}
+	if states["i-1"] != "terminated" || states["i-2"] != "terminated" || polls != 2 {
+		t.Errorf("WaitTerminated(i-1, i-2) = %v after %d polls, expected both terminated after 2 polls", states, polls)
+	}
+
+	// the instances not terminated before the timeout are returned with their last state
+	states, err = WaitTerminated(ec2client, []string{"i-3", "i-unknown"}, 10*time.Millisecond, io.Discard)
+	if err == nil {
+		t.Errorf("WaitTerminated(i-3, i-unknown): expected a timeout error")
+	}
+	expected := map[string]string{"i-3": "shutting-down", "i-unknown": "not found"}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("WaitTerminated(i-3, i-unknown) = %v, expected %v", states, expected)
+	}
+
+	// with options.Wait, the final state of the deleted instances is recorded in the result
+	polls = 1
+	result, err := DeleteInstances(ec2client, []string{"i-1", "i-2"}, DeleteOptions{Wait: true, WaitTimeout: time.Second, Output: io.Discard})
+	if err != nil {
+		t.Fatalf("DeleteInstances() = %v", err)
+	}
+	for _, instance := range result.Instances {
+		if instance.CurrentState != "shutting-down" || instance.FinalState != "terminated" {
+			t.Errorf("instance %s: current state %q and final state %q, expected shutting-down and terminated",
+				instance.InstanceID, instance.CurrentState, instance.FinalState)
+		}
+	}
+}
+
+func TestWaitTerminatedThrottled(t *testing.T) {
+	defer func(interval time.Duration) { describeEC2.PollInterval = interval }(describeEC2.PollInterval)
+	describeEC2.PollInterval = time.Millisecond
+
+	var mu sync.Mutex
+	polls := 0
+	ec2client := fakeEC2(t, func(action string, params url.Values) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		polls++
+		// the first poll is throttled, and retried by the throttle
+		if polls == 1 {
+			return failed("RequestLimitExceeded")
+		}
+		return described(params, map[string]string{"i-1": "terminated"})
+	})
+	states, err := WaitTerminated(ec2client, []string{"i-1"}, time.Minute, io.Discard)
+	if err != nil || states["i-1"] != "terminated" || polls != 2 {
+		t.Errorf("WaitTerminated(i-1) = %v, %v after %d polls, expected terminated after 2 polls", states, err, polls)
+	}
+}
+
+func TestWaitTerminatedDryRun(t *testing.T) {
+	ec2client := fakeEC2(t, func(action string, params url.Values) (int, string) {
+		if action != "TerminateInstances" {
+			t.Errorf("unexpected request %s in dry run mode", action)
+		}
+		return failed("DryRunOperation")
+	})
+	result, err := DeleteInstances(ec2client, []string{"i-1"}, DeleteOptions{Wait: true, DryRun: true, Output: io.Discard})
+	if err != nil || len(result.Instances) != 1 || !result.Instances[0].DryRun {
+		t.Errorf("DeleteInstances() in dry run mode = %+v, %v, expected i-1 deleted in dry run", result, err)
+	}
+}
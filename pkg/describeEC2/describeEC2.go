@@ -0,0 +1,285 @@
+package describeEC2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Details of an EC2 instance.
+type Instance struct {
+	InstanceID       string            `json:"instance_id" yaml:"instance_id"`
+	Name             string            `json:"name" yaml:"name"` // value of the tag "Name"
+	State            string            `json:"state" yaml:"state"`
+	InstanceType     string            `json:"instance_type" yaml:"instance_type"`
+	AMI              string            `json:"ami" yaml:"ami"`
+	AvailabilityZone string            `json:"availability_zone" yaml:"availability_zone"`
+	LaunchTime       time.Time         `json:"launch_time" yaml:"launch_time"`
+	PublicIP         string            `json:"public_ip,omitempty" yaml:"public_ip,omitempty"`
+	PrivateIP        string            `json:"private_ip,omitempty" yaml:"private_ip,omitempty"`
+	KeyName          string            `json:"key_name,omitempty" yaml:"key_name,omitempty"`
+	SecurityGroups   []string          `json:"security_groups" yaml:"security_groups"` // names of the security groups
+	Tags             map[string]string `json:"tags" yaml:"tags"`
+}
+
+// Converts the instance description returned by the EC2 API.
+func NewInstance(instance types.Instance) Instance {
+	result := Instance{
+		InstanceID:     deref(instance.InstanceId),
+		InstanceType:   string(instance.InstanceType),
+		AMI:            deref(instance.ImageId),
+		PublicIP:       deref(instance.PublicIpAddress),
+		PrivateIP:      deref(instance.PrivateIpAddress),
+		KeyName:        deref(instance.KeyName),
+		SecurityGroups: []string{},
+		Tags:           map[string]string{},
+	}
+	if instance.State != nil {
+		result.State = string(instance.State.Name)
+	}
+	if instance.Placement != nil {
+		result.AvailabilityZone = deref(instance.Placement.AvailabilityZone)
+	}
+	if instance.LaunchTime != nil {
+		result.LaunchTime = *instance.LaunchTime
+	}
+	for _, group := range instance.SecurityGroups {
+		result.SecurityGroups = append(result.SecurityGroups, deref(group.GroupName))
+	}
+	for _, tag := range instance.Tags {
+		result.Tags[deref(tag.Key)] = deref(tag.Value)
+	}
+	result.Name = result.Tags["Name"]
+	return result
+}
+
+// Returns the string pointed by s, or "" if s is nil.
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Options of ListInstances.
+type ListOptions struct {
+	InstanceIDs []string       // only list these instances (all instances if empty)
+	Filters     []types.Filter // filters of the DescribeInstances request (ex: tag:Name)
+	// if set, only the instances for which Match returns true are listed
+	// (ex: the Match method of a selector.Selector)
+	Match func(instance types.Instance) bool
+	// by default, shutting-down and terminated instances are not listed
+	IncludeTerminated bool
+	// column to sort the instances by (see Columns), prefixed by "-" for descending order.
+	// If empty, the instances are in the order returned by AWS.
+	SortBy string
+}
+
+// Returned by the function given to EachInstance to stop the iteration
+// (EachInstance then returns nil).
+var StopIteration = errors.New("stop iteration")
+
+// Calls fn on each instance described by the DescribeInstances request given in parameter.
+// The pages of results are fetched one at a time (following NextToken),
+// so large fleets can be processed without loading all instances in memory.
+// The iteration stops at the first error returned by fn, which is returned
+// (except StopIteration).
+func EachInstance(ec2client *ec2.Client, input *ec2.DescribeInstancesInput, fn func(instance types.Instance) error) error {
+	paginator := ec2.NewDescribeInstancesPaginator(ec2client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return fmt.Errorf("fetching info on instances failed: %w", err)
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				err = fn(instance)
+				if err == StopIteration {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Returns true if the instance is shutting down or terminated.
+func IsTerminated(instance types.Instance) bool {
+	return instance.State != nil && (instance.State.Name == types.InstanceStateNameShuttingDown || instance.State.Name == types.InstanceStateNameTerminated)
+}
+
+// Returns the details of the instances owned (all of them, or filtered by the options).
+func ListInstances(ec2client *ec2.Client, options ListOptions) ([]Instance, error) {
+	instances := []Instance{}
+
+	describeInstanceInput := &ec2.DescribeInstancesInput{
+		InstanceIds: options.InstanceIDs,
+		Filters:     options.Filters,
+	}
+	err := EachInstance(ec2client, describeInstanceInput, func(instance types.Instance) error {
+		if options.Match != nil && !options.Match(instance) {
+			return nil
+		}
+		if options.IncludeTerminated || !IsTerminated(instance) {
+			instances = append(instances, NewInstance(instance))
+		}
+		return nil
+	})
+	if err != nil {
+		return instances, err
+	}
+
+	if options.SortBy != "" {
+		err = SortInstances(instances, options.SortBy)
+	}
+	return instances, err
+}
+
+// A column of the instances table.
+type Column struct {
+	Name   string // name used to select the column (ex: "id")
+	Header string
+	Value  func(i Instance) string
+	// compares 2 instances for this column (if nil, Value is compared)
+	less func(a, b Instance) bool
+}
+
+// All the columns that can be printed.
+var Columns = []Column{
+	{Name: "id", Header: "INSTANCE ID", Value: func(i Instance) string { return i.InstanceID }},
+	{Name: "name", Header: "NAME", Value: func(i Instance) string { return i.Name }},
+	{Name: "state", Header: "STATE", Value: func(i Instance) string { return i.State }},
+	{Name: "type", Header: "TYPE", Value: func(i Instance) string { return i.InstanceType }},
+	{Name: "ami", Header: "AMI", Value: func(i Instance) string { return i.AMI }},
+	{Name: "az", Header: "AZ", Value: func(i Instance) string { return i.AvailabilityZone }},
+	{
+		Name: "launched", Header: "LAUNCHED",
+		Value: func(i Instance) string {
+			if i.LaunchTime.IsZero() {
+				return ""
+			}
+			return i.LaunchTime.Local().Format("2006-01-02 15:04")
+		},
+		less: func(a, b Instance) bool { return a.LaunchTime.Before(b.LaunchTime) },
+	},
+	{Name: "public-ip", Header: "PUBLIC IP", Value: func(i Instance) string { return i.PublicIP }},
+	{Name: "private-ip", Header: "PRIVATE IP", Value: func(i Instance) string { return i.PrivateIP }},
+	{Name: "key", Header: "KEY", Value: func(i Instance) string { return i.KeyName }},
+	{Name: "sg", Header: "SECURITY GROUPS", Value: func(i Instance) string { return strings.Join(i.SecurityGroups, ",") }},
+	{
+		Name: "tags", Header: "TAGS",
+		Value: func(i Instance) string {
+			var tags []string
+			for key, value := range i.Tags {
+				tags = append(tags, key+"="+value)
+			}
+			sort.Strings(tags)
+			return strings.Join(tags, ",")
+		},
+	},
+}
+
+// Columns printed when none are selected.
+var DefaultColumns = []string{"id", "name", "state", "type", "az", "launched", "public-ip", "key"}
+
+// Returns the column of name given in parameter.
+func FindColumn(name string) (Column, error) {
+	for _, column := range Columns {
+		if column.Name == name {
+			return column, nil
+		}
+	}
+	names := make([]string, len(Columns))
+	for i, column := range Columns {
+		names[i] = column.Name
+	}
+	return Column{}, fmt.Errorf("unknown column %q (expected one of: %s)", name, strings.Join(names, ", "))
+}
+
+// Sorts the instances by the column given in parameter.
+// The column name can be prefixed by "-" to sort in descending order (ex: "-launched").
+func SortInstances(instances []Instance, by string) error {
+	descending := strings.HasPrefix(by, "-")
+	column, err := FindColumn(strings.TrimPrefix(by, "-"))
+	if err != nil {
+		return err
+	}
+	less := column.less
+	if less == nil {
+		less = func(a, b Instance) bool { return column.Value(a) < column.Value(b) }
+	}
+	sort.SliceStable(instances, func(i, j int) bool {
+		if descending {
+			return less(instances[j], instances[i])
+		}
+		return less(instances[i], instances[j])
+	})
+	return nil
+}
+
+// A list of instances, that can be printed by the output package.
+// In a table, only the selected columns are printed.
+type InstanceList struct {
+	Instances []Instance
+	Columns   []string // names of the columns of the table (DefaultColumns if empty)
+}
+
+func (l *InstanceList) Table() ([]string, [][]string) {
+	names := l.Columns
+	if len(names) == 0 {
+		names = DefaultColumns
+	}
+	var columns []Column
+	for _, name := range names {
+		// unknown columns are checked by the caller with FindColumn
+		if column, err := FindColumn(name); err == nil {
+			columns = append(columns, column)
+		}
+	}
+
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Header
+	}
+	rows := make([][]string, len(l.Instances))
+	for i, instance := range l.Instances {
+		rows[i] = make([]string, len(columns))
+		for j, column := range columns {
+			rows[i][j] = column.Value(instance)
+		}
+	}
+	return header, rows
+}
+
+func (l *InstanceList) IDs() []string {
+	ids := make([]string, len(l.Instances))
+	for i, instance := range l.Instances {
+		ids[i] = instance.InstanceID
+	}
+	return ids
+}
+
+// In JSON and YAML, the list is printed as an array of instances.
+func (l *InstanceList) MarshalJSON() ([]byte, error) {
+	if l.Instances == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(l.Instances)
+}
+
+func (l *InstanceList) MarshalYAML() (interface{}, error) {
+	if l.Instances == nil {
+		return []Instance{}, nil
+	}
+	return l.Instances, nil
+}
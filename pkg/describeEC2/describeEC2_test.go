@@ -0,0 +1,58 @@
+package describeEC2
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortInstances(t *testing.T) {
+	now := time.Now()
+	instances := []Instance{
+		{InstanceID: "i-2", Name: "web", LaunchTime: now.Add(-time.Hour)},
+		{InstanceID: "i-1", Name: "db", LaunchTime: now},
+		{InstanceID: "i-3", Name: "api", LaunchTime: now.Add(-2 * time.Hour)},
+	}
+	tests := []struct {
+		by       string
+		expected []string
+		invalid  bool
+	}{
+		{"id", []string{"i-1", "i-2", "i-3"}, false},
+		{"-id", []string{"i-3", "i-2", "i-1"}, false},
+		{"name", []string{"i-3", "i-1", "i-2"}, false},
+		{"launched", []string{"i-3", "i-2", "i-1"}, false},
+		{"-launched", []string{"i-1", "i-2", "i-3"}, false},
+		{"size", nil, true},
+	}
+	for _, test := range tests {
+		sorted := append([]Instance(nil), instances...)
+		err := SortInstances(sorted, test.by)
+		if (err != nil) != test.invalid {
+			t.Errorf("SortInstances(%q) error = %v, expected an error: %v", test.by, err, test.invalid)
+			continue
+		}
+		if test.invalid {
+			continue
+		}
+		list := &InstanceList{Instances: sorted}
+		if ids := list.IDs(); !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("SortInstances(%q) = %v, expected %v", test.by, ids, test.expected)
+		}
+	}
+}
+
+func TestInstanceListTable(t *testing.T) {
+	list := &InstanceList{
+		Instances: []Instance{{InstanceID: "i-1", Name: "web", Tags: map[string]string{"b": "2", "a": "1"}}},
+		Columns:   []string{"id", "tags"},
+	}
+	header, rows := list.Table()
+	if expected := []string{"INSTANCE ID", "TAGS"}; !reflect.DeepEqual(header, expected) {
+		t.Errorf("Table() header = %v, expected %v", header, expected)
+	}
+	// the tags are sorted by key
+	if expected := [][]string{{"i-1", "a=1,b=2"}}; !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Table() rows = %v, expected %v", rows, expected)
+	}
+}
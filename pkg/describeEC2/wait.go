@@ -0,0 +1,88 @@
+package describeEC2
+
+import (
+	"aws/pkg/fleet"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Time between two checks of the state of the instances, while waiting for a state.
+var PollInterval = 5 * time.Second
+
+// Maximum number of values of a DescribeInstances filter (limit of the EC2 API).
+const maxFilterValues = 200
+
+// Waits until the instances of IDs given in parameter are in the state given in
+// parameter (ex: types.InstanceStateNameStopped), for at most timeout, printing
+// the progress in progress (use io.Discard to print nothing).
+// Returns the last state of each instance.
+// If some instances aren't in the state before the timeout (or if an instance
+// doesn't exist), their last known state is returned along with an error.
+// The polls slow down when AWS answers that the request rate limit is exceeded
+// (the throttled poll is retried, see fleet.Throttle).
+func WaitState(ec2client *ec2.Client, instanceIds []string, state types.InstanceStateName, timeout time.Duration, progress io.Writer) (map[string]string, error) {
+	states := make(map[string]string)
+	var ids []string
+	for _, id := range instanceIds {
+		if _, ok := states[id]; !ok {
+			states[id] = ""
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return states, nil
+	}
+	fmt.Fprintf(progress, "Waiting for %d instances to be %s...\n", len(ids), state)
+
+	throttle := fleet.NewThrottle(fleet.Options{})
+	start := time.Now()
+	done := 0
+	for {
+		// the instances are described with a filter, and not by ID:
+		// an unknown ID doesn't make the whole request fail
+		for i := 0; i < len(ids); i += maxFilterValues {
+			batch := ids[i:min(i+maxFilterValues, len(ids))]
+			filterName := "instance-id"
+			input := &ec2.DescribeInstancesInput{Filters: []types.Filter{{Name: &filterName, Values: batch}}}
+			err := throttle.Do(func() error {
+				return EachInstance(ec2client, input, func(instance types.Instance) error {
+					if instance.State != nil {
+						states[*instance.InstanceId] = string(instance.State.Name)
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				return states, fmt.Errorf("failed to fetch the state of the instances: %w", err)
+			}
+		}
+
+		var waiting []string
+		for _, id := range ids {
+			if states[id] != string(state) {
+				waiting = append(waiting, id)
+			}
+		}
+		if len(ids)-len(waiting) != done || len(waiting) == 0 {
+			done = len(ids) - len(waiting)
+			fmt.Fprintf(progress, "%d/%d instances %s (%s)\n", done, len(ids), state, time.Since(start).Round(time.Second))
+		}
+		if len(waiting) == 0 {
+			return states, nil
+		}
+		if time.Since(start)+PollInterval > timeout {
+			for _, id := range waiting {
+				if states[id] == "" {
+					states[id] = "not found"
+				}
+			}
+			return states, fmt.Errorf("%d instances not %s after %s: %s", len(waiting), state, timeout, strings.Join(waiting, " "))
+		}
+		time.Sleep(PollInterval)
+	}
+}
@@ -0,0 +1,33 @@
+/*
+Package dryrun helps sending EC2 requests with DryRun=true.
+
+With DryRun=true, AWS checks that the request is valid and that the user has
+the required permissions, without doing anything. If the request would have
+succeeded, AWS answers with the error DryRunOperation, else with the error that
+would have happened (ex: UnauthorizedOperation).
+*/
+package dryrun
+
+import (
+	"errors"
+
+	"github.com/aws/smithy-go"
+)
+
+// Error code returned by AWS when a request sent with DryRun=true would have succeeded.
+const OperationCode = "DryRunOperation"
+
+// Returns true if err means that the request sent with DryRun=true would have succeeded.
+func Succeeded(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == OperationCode
+}
+
+// Returns the error of a request sent with DryRun=true:
+// nil if the request would have succeeded, else err.
+func Check(err error) error {
+	if Succeeded(err) {
+		return nil
+	}
+	return err
+}
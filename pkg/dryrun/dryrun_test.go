@@ -0,0 +1,36 @@
+package dryrun
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestCheck(t *testing.T) {
+	succeeded := &smithy.GenericAPIError{Code: OperationCode, Message: "Request would have succeeded, but DryRun flag is set."}
+	unauthorized := &smithy.GenericAPIError{Code: "UnauthorizedOperation"}
+	tests := []struct {
+		err       error
+		succeeded bool
+	}{
+		{nil, false},
+		{succeeded, true},
+		{fmt.Errorf("couldn't delete instance i-1: %w", succeeded), true},
+		{unauthorized, false},
+		{errors.New(OperationCode), false}, // not an error of AWS
+	}
+	for _, test := range tests {
+		if s := Succeeded(test.err); s != test.succeeded {
+			t.Errorf("Succeeded(%v) = %v, expected %v", test.err, s, test.succeeded)
+		}
+		err := Check(test.err)
+		if test.succeeded && err != nil {
+			t.Errorf("Check(%v) = %v, expected nil", test.err, err)
+		}
+		if !test.succeeded && err != test.err {
+			t.Errorf("Check(%v) = %v, expected the same error", test.err, err)
+		}
+	}
+}
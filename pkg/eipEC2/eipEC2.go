@@ -0,0 +1,280 @@
+package eipEC2
+
+import (
+	"aws/pkg/dryrun"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// Options of the operations changing the Elastic IPs. The zero value uses the defaults.
+type Options struct {
+	// if true, the requests changing the Elastic IPs are sent with DryRun=true:
+	// AWS checks the permissions without changing anything (see package dryrun)
+	DryRun bool
+	// writer where the progress messages are printed (default: os.Stdout; set it
+	// to os.Stderr to keep stdout for the results, or to io.Discard)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// Tag of the Elastic IPs allocated for an instance (see Allocate), whose value is
+// the ID of the instance: teardown releases them with the instance.
+const InstanceTag = "allocated-for"
+
+// An Elastic IP, and its association.
+type Address struct {
+	AllocationID  string `json:"allocation_id" yaml:"allocation_id"`
+	PublicIP      string `json:"public_ip" yaml:"public_ip"`
+	Name          string `json:"name,omitempty" yaml:"name,omitempty"`                     // value of the tag "Name"
+	InstanceID    string `json:"instance_id,omitempty" yaml:"instance_id,omitempty"`       // instance it's associated with
+	AssociationID string `json:"association_id,omitempty" yaml:"association_id,omitempty"` // empty if not associated
+	PrivateIP     string `json:"private_ip,omitempty" yaml:"private_ip,omitempty"`
+	AllocatedFor  string `json:"allocated_for,omitempty" yaml:"allocated_for,omitempty"` // instance it was allocated for (see InstanceTag)
+	DryRun        bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`             // true if the operation would have been done
+}
+
+func newAddress(address types.Address) Address {
+	result := Address{
+		AllocationID:  deref(address.AllocationId),
+		PublicIP:      deref(address.PublicIp),
+		InstanceID:    deref(address.InstanceId),
+		AssociationID: deref(address.AssociationId),
+		PrivateIP:     deref(address.PrivateIpAddress),
+	}
+	for _, tag := range address.Tags {
+		switch deref(tag.Key) {
+		case "Name":
+			result.Name = deref(tag.Value)
+		case InstanceTag:
+			result.AllocatedFor = deref(tag.Value)
+		}
+	}
+	return result
+}
+
+func (a *Address) Table() ([]string, [][]string) {
+	return (&AddressList{Addresses: []Address{*a}}).Table()
+}
+
+func (a *Address) IDs() []string {
+	return []string{a.AllocationID}
+}
+
+// Result of List.
+type AddressList struct {
+	Addresses []Address `json:"addresses" yaml:"addresses"`
+}
+
+func (l *AddressList) Table() ([]string, [][]string) {
+	rows := make([][]string, len(l.Addresses))
+	for i, a := range l.Addresses {
+		instance := a.InstanceID
+		if a.DryRun {
+			instance = "(dry run)"
+		}
+		rows[i] = []string{a.AllocationID, a.PublicIP, a.Name, instance, a.AssociationID, a.PrivateIP, a.AllocatedFor}
+	}
+	return []string{"ALLOCATION ID", "PUBLIC IP", "NAME", "INSTANCE ID", "ASSOCIATION ID", "PRIVATE IP", "ALLOCATED FOR"}, rows
+}
+
+func (l *AddressList) IDs() []string {
+	ids := make([]string, len(l.Addresses))
+	for i, a := range l.Addresses {
+		ids[i] = a.AllocationID
+	}
+	return ids
+}
+
+// Lists the Elastic IPs of the region, with the instances they're associated with.
+func List(ec2client *ec2.Client) (*AddressList, error) {
+	output, err := ec2client.DescribeAddresses(context.TODO(), &ec2.DescribeAddressesInput{})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching Elastic IPs info: %w", err)
+	}
+	list := &AddressList{Addresses: []Address{}}
+	for _, address := range output.Addresses {
+		list.Addresses = append(list.Addresses, newAddress(address))
+	}
+	return list, nil
+}
+
+// Returns the Elastic IP of allocation ID (ex: eipalloc-0123) or public IP given in parameter.
+func Find(ec2client *ec2.Client, address string) (*Address, error) {
+	input := &ec2.DescribeAddressesInput{PublicIps: []string{address}}
+	if strings.HasPrefix(address, "eipalloc-") {
+		input = &ec2.DescribeAddressesInput{AllocationIds: []string{address}}
+	}
+	output, err := ec2client.DescribeAddresses(context.TODO(), input)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find Elastic IP %s: %w", address, err)
+	}
+	if len(output.Addresses) == 0 {
+		return nil, fmt.Errorf("couldn't find Elastic IP %s", address)
+	}
+	result := newAddress(output.Addresses[0])
+	return &result, nil
+}
+
+// Allocates a new Elastic IP, tagged with the name given in parameter (if not empty).
+// If instanceId isn't empty, the address is tagged as allocated for this instance
+// (see InstanceTag), but isn't associated with it (see Associate).
+func Allocate(ec2client *ec2.Client, name string, instanceId string, options Options) (*Address, error) {
+	var tags []types.Tag
+	if name != "" {
+		key := "Name"
+		tags = append(tags, types.Tag{Key: &key, Value: &name})
+	}
+	if instanceId != "" {
+		key := InstanceTag
+		tags = append(tags, types.Tag{Key: &key, Value: &instanceId})
+	}
+	input := &ec2.AllocateAddressInput{Domain: types.DomainTypeVpc, DryRun: &options.DryRun}
+	if len(tags) > 0 {
+		input.TagSpecifications = []types.TagSpecification{{ResourceType: types.ResourceTypeElasticIp, Tags: tags}}
+	}
+	output, err := ec2client.AllocateAddress(context.TODO(), input)
+	if options.DryRun && dryrun.Succeeded(err) {
+		fmt.Fprintln(options.messages(), "Dry run: an Elastic IP would be allocated.")
+		return &Address{Name: name, AllocatedFor: instanceId, DryRun: true}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't allocate an Elastic IP: %w", err)
+	}
+	fmt.Fprintf(options.messages(), "Elastic IP %s allocated (%s).\n", *output.PublicIp, *output.AllocationId)
+	return &Address{AllocationID: *output.AllocationId, PublicIP: *output.PublicIp, Name: name, AllocatedFor: instanceId}, nil
+}
+
+// Associates the Elastic IP (allocation ID or public IP) with the instance of ID given
+// in parameter. If the address is already associated with another instance, it's moved
+// only if reassociate is true.
+func Associate(ec2client *ec2.Client, address string, instanceId string, reassociate bool, options Options) (*Address, error) {
+	result, err := Find(ec2client, address)
+	if err != nil {
+		return nil, err
+	}
+	if result.InstanceID == instanceId {
+		fmt.Fprintf(options.messages(), "Elastic IP %s is already associated with instance %s.\n", result.PublicIP, instanceId)
+		return result, nil
+	}
+	if result.AssociationID != "" && !reassociate {
+		return nil, fmt.Errorf("the Elastic IP %s is already associated with %s (use reassociate to move it)", result.PublicIP, result.InstanceID)
+	}
+	output, err := ec2client.AssociateAddress(context.TODO(), &ec2.AssociateAddressInput{
+		AllocationId:       &result.AllocationID,
+		InstanceId:         &instanceId,
+		AllowReassociation: &reassociate,
+		DryRun:             &options.DryRun,
+	})
+	if options.DryRun && dryrun.Succeeded(err) {
+		fmt.Fprintf(options.messages(), "Dry run: Elastic IP %s would be associated with instance %s.\n", result.PublicIP, instanceId)
+		result.DryRun = true
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't associate Elastic IP %s with instance %s: %w", result.PublicIP, instanceId, err)
+	}
+	result.InstanceID = instanceId
+	result.AssociationID = deref(output.AssociationId)
+	fmt.Fprintf(options.messages(), "Elastic IP %s associated with instance %s.\n", result.PublicIP, instanceId)
+	return result, nil
+}
+
+// Allocates an Elastic IP for the instance of ID given in parameter (tagged with the
+// name given in parameter and InstanceTag), and associates it with the instance,
+// which must be running. If the association fails, the address is released.
+func AllocateFor(ec2client *ec2.Client, instanceId string, name string, options Options) (*Address, error) {
+	allocated, err := Allocate(ec2client, name, instanceId, options)
+	if err != nil || allocated.DryRun {
+		return allocated, err
+	}
+	associated, err := Associate(ec2client, allocated.AllocationID, instanceId, false, options)
+	if err != nil {
+		// the address isn't kept, as nothing uses it
+		_, releaseErr := ec2client.ReleaseAddress(context.TODO(), &ec2.ReleaseAddressInput{AllocationId: &allocated.AllocationID})
+		if releaseErr != nil {
+			return allocated, errors.Join(err, fmt.Errorf("couldn't release Elastic IP %s: %w", allocated.PublicIP, releaseErr))
+		}
+		fmt.Fprintf(options.messages(), "Elastic IP %s released.\n", allocated.PublicIP)
+		return nil, err
+	}
+	return associated, nil
+}
+
+// Disassociates the Elastic IP (allocation ID or public IP) from its instance.
+func Disassociate(ec2client *ec2.Client, address string, options Options) (*Address, error) {
+	result, err := Find(ec2client, address)
+	if err != nil {
+		return nil, err
+	}
+	if result.AssociationID == "" {
+		fmt.Fprintf(options.messages(), "Elastic IP %s isn't associated.\n", result.PublicIP)
+		return result, nil
+	}
+	_, err = ec2client.DisassociateAddress(context.TODO(), &ec2.DisassociateAddressInput{AssociationId: &result.AssociationID, DryRun: &options.DryRun})
+	if options.DryRun && dryrun.Succeeded(err) {
+		fmt.Fprintf(options.messages(), "Dry run: Elastic IP %s would be disassociated from instance %s.\n", result.PublicIP, result.InstanceID)
+		result.DryRun = true
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't disassociate Elastic IP %s: %w", result.PublicIP, err)
+	}
+	fmt.Fprintf(options.messages(), "Elastic IP %s disassociated from instance %s.\n", result.PublicIP, result.InstanceID)
+	result.InstanceID, result.AssociationID, result.PrivateIP = "", "", ""
+	return result, nil
+}
+
+// Releases the Elastic IP (allocation ID or public IP). An associated address is
+// only released if disassociate is true (it's then disassociated first).
+func Release(ec2client *ec2.Client, address string, disassociate bool, options Options) (*Address, error) {
+	result, err := Find(ec2client, address)
+	if err != nil {
+		return nil, err
+	}
+	if result.AssociationID != "" {
+		if !disassociate {
+			return nil, fmt.Errorf("the Elastic IP %s is associated with instance %s (disassociate it first)", result.PublicIP, result.InstanceID)
+		}
+		if result, err = Disassociate(ec2client, address, options); err != nil || result.DryRun {
+			return result, err
+		}
+	}
+	_, err = ec2client.ReleaseAddress(context.TODO(), &ec2.ReleaseAddressInput{AllocationId: &result.AllocationID, DryRun: &options.DryRun})
+	if options.DryRun && dryrun.Succeeded(err) {
+		fmt.Fprintf(options.messages(), "Dry run: Elastic IP %s would be released.\n", result.PublicIP)
+		result.DryRun = true
+		return result, nil
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidAllocationID.NotFound" {
+		fmt.Fprintf(options.messages(), "Elastic IP %s was already released.\n", result.PublicIP)
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't release Elastic IP %s: %w", result.PublicIP, err)
+	}
+	fmt.Fprintf(options.messages(), "Elastic IP %s released.\n", result.PublicIP)
+	return result, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,60 @@
+package eipEC2
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestNewAddress(t *testing.T) {
+	tests := []struct {
+		address  types.Address
+		expected Address
+	}{
+		{
+			types.Address{AllocationId: aws.String("eipalloc-1"), PublicIp: aws.String("203.0.113.1")},
+			Address{AllocationID: "eipalloc-1", PublicIP: "203.0.113.1"},
+		},
+		{
+			types.Address{
+				AllocationId:     aws.String("eipalloc-2"),
+				PublicIp:         aws.String("203.0.113.2"),
+				InstanceId:       aws.String("i-1"),
+				AssociationId:    aws.String("eipassoc-1"),
+				PrivateIpAddress: aws.String("10.0.0.2"),
+				Tags: []types.Tag{
+					{Key: aws.String("Name"), Value: aws.String("web")},
+					{Key: aws.String(InstanceTag), Value: aws.String("i-1")},
+					{Key: aws.String("env"), Value: aws.String("dev")},
+				},
+			},
+			Address{AllocationID: "eipalloc-2", PublicIP: "203.0.113.2", Name: "web", InstanceID: "i-1",
+				AssociationID: "eipassoc-1", PrivateIP: "10.0.0.2", AllocatedFor: "i-1"},
+		},
+	}
+	for _, test := range tests {
+		if address := newAddress(test.address); address != test.expected {
+			t.Errorf("newAddress(%s) = %+v, expected %+v", *test.address.AllocationId, address, test.expected)
+		}
+	}
+}
+
+func TestAddressListTable(t *testing.T) {
+	list := &AddressList{Addresses: []Address{
+		{AllocationID: "eipalloc-1", PublicIP: "203.0.113.1", InstanceID: "i-1", AllocatedFor: "i-1"},
+		{AllocationID: "eipalloc-2", PublicIP: "203.0.113.2", DryRun: true},
+	}}
+	_, rows := list.Table()
+	expected := [][]string{
+		{"eipalloc-1", "203.0.113.1", "", "i-1", "", "", "i-1"},
+		{"eipalloc-2", "203.0.113.2", "", "(dry run)", "", "", ""},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Table() rows = %v, expected %v", rows, expected)
+	}
+	if ids := list.IDs(); !reflect.DeepEqual(ids, []string{"eipalloc-1", "eipalloc-2"}) {
+		t.Errorf("IDs() = %v, expected [eipalloc-1 eipalloc-2]", ids)
+	}
+}
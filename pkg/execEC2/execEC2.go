@@ -0,0 +1,270 @@
+package execEC2
+
+import (
+	"aws/pkg/describeEC2"
+	"aws/pkg/fleet"
+	"aws/pkg/sshEC2"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// Status of the command on an instance.
+const (
+	StatusOK      = "ok"      // exit code 0
+	StatusFailed  = "failed"  // non-zero exit code
+	StatusTimeout = "timeout" // still running after Options.Timeout (the connection is closed)
+	StatusError   = "error"   // couldn't connect, or the command ended without exit code
+	StatusSkipped = "skipped" // not started, because of Options.StopOnFailure
+	StatusDryRun  = "dry run"
+)
+
+// Options of Run. The zero value uses the defaults.
+type Options struct {
+	// connection to the instances (SSH.Output is set to Output)
+	SSH         sshEC2.Options
+	Concurrency int // maximum number of instances running the command at the same time (default: fleet.DefaultConcurrency)
+	// maximum duration of the command on each instance, once connected (default: no limit)
+	Timeout time.Duration
+	// if true, the command isn't started on the remaining instances once it failed
+	// on one of them (the commands already started run until they end)
+	StopOnFailure bool
+	// writers where the output lines of the instances are copied, prefixed by the
+	// names of the instances (default: os.Stdout and os.Stderr)
+	Stdout io.Writer
+	Stderr io.Writer
+	// if true, the command isn't run: only the instances and their connection
+	// parameters (login user, key file) are resolved
+	DryRun bool
+	// writer where the messages that aren't output lines of the instances are
+	// printed, ex: dry run (default: os.Stdout)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// Result of the command on an instance.
+type HostResult struct {
+	InstanceID string  `json:"instance_id" yaml:"instance_id"`
+	Name       string  `json:"name,omitempty" yaml:"name,omitempty"`
+	Host       string  `json:"host,omitempty" yaml:"host,omitempty"` // user@IP
+	Status     string  `json:"status" yaml:"status"`                 // see the Status constants
+	ExitCode   int     `json:"exit_code" yaml:"exit_code"`           // -1 if the command has no exit code
+	Seconds    float64 `json:"seconds" yaml:"seconds"`               // duration of the connection and the command
+	Error      string  `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Returns true if the command didn't succeed on the instance (or wasn't run).
+func (h *HostResult) Failed() bool {
+	return h.Status != StatusOK && h.Status != StatusDryRun
+}
+
+// Result of Run.
+type Result struct {
+	Command string       `json:"command" yaml:"command"`
+	Hosts   []HostResult `json:"hosts" yaml:"hosts"`
+}
+
+// Returns the number of instances on which the command didn't succeed.
+func (r *Result) Failed() int {
+	failed := 0
+	for _, host := range r.Hosts {
+		if host.Failed() {
+			failed++
+		}
+	}
+	return failed
+}
+
+func (r *Result) Table() ([]string, [][]string) {
+	rows := make([][]string, len(r.Hosts))
+	for i, host := range r.Hosts {
+		exitCode := fmt.Sprint(host.ExitCode)
+		if host.ExitCode < 0 {
+			exitCode = "-"
+		}
+		duration := (time.Duration(host.Seconds*1000) * time.Millisecond).String()
+		rows[i] = []string{host.InstanceID, host.Name, host.Host, host.Status, exitCode, duration, host.Error}
+	}
+	return []string{"INSTANCE ID", "NAME", "HOST", "STATUS", "EXIT CODE", "DURATION", "ERROR"}, rows
+}
+
+// Returns the IDs of the instances on which the command succeeded.
+func (r *Result) IDs() []string {
+	var ids []string
+	for _, host := range r.Hosts {
+		if host.Status == StatusOK {
+			ids = append(ids, host.InstanceID)
+		}
+	}
+	return ids
+}
+
+// Runs the command with SSH on the instances given in parameter (at most
+// options.Concurrency at the same time), and copies their output lines to
+// options.Stdout and options.Stderr, prefixed by the names of the instances
+// (or their IDs, if several instances have the same name).
+// The result is returned even if the command failed on some instances,
+// with an error giving the number of failures.
+func Run(ec2client *ec2.Client, instances []describeEC2.Instance, command string, options Options) (*Result, error) {
+	if options.Stdout == nil {
+		options.Stdout = os.Stdout
+	}
+	if options.Stderr == nil {
+		options.Stderr = os.Stderr
+	}
+	options.SSH.Output = options.Output
+	users, err := sshEC2.ImageUsers(ec2client, instances, options.SSH)
+	if err != nil {
+		return nil, err
+	}
+	prefixes := hostPrefixes(instances)
+
+	var lock sync.Mutex // serializes the output lines of the instances
+	var stopped atomic.Bool
+	results, _ := fleet.Run(instances, fleet.Options{Concurrency: options.Concurrency, MaxRetries: -1}, func(instance describeEC2.Instance) (HostResult, error) {
+		host := HostResult{InstanceID: instance.InstanceID, Name: instance.Name, ExitCode: -1}
+		if stopped.Load() {
+			host.Status = StatusSkipped
+			return host, nil
+		}
+		start := time.Now()
+		stdout := &prefixWriter{lock: &lock, w: options.Stdout, prefix: prefixes[instance.InstanceID]}
+		stderr := &prefixWriter{lock: &lock, w: options.Stderr, prefix: prefixes[instance.InstanceID]}
+		run(instance, users[instance.AMI], command, options, &host, stdout, stderr)
+		stdout.Flush()
+		stderr.Flush()
+		host.Seconds = time.Since(start).Seconds()
+		if host.Failed() && options.StopOnFailure && !stopped.Swap(true) {
+			fmt.Fprintf(options.messages(), "Command failed on %s (%s): stopping.\n", instance.InstanceID, host.Status)
+		}
+		return host, nil
+	})
+
+	result := &Result{Command: command}
+	for _, r := range results {
+		result.Hosts = append(result.Hosts, r.Value)
+	}
+	if failed := result.Failed(); failed > 0 {
+		return result, fmt.Errorf("the command didn't succeed on %d of %d instances", failed, len(instances))
+	}
+	return result, nil
+}
+
+// Runs the command on one instance, and records its status in host.
+func run(instance describeEC2.Instance, imageUser string, command string, options Options, host *HostResult, stdout, stderr io.Writer) {
+	target, err := sshEC2.NewTarget(instance, imageUser, options.SSH)
+	if err != nil {
+		host.Status, host.Error = StatusError, err.Error()
+		return
+	}
+	host.Host = target.String()
+	if options.DryRun {
+		fmt.Fprintf(options.messages(), "Dry run: would run %q on %s (instance %s) with key %s\n", command, target, target.InstanceID, target.KeyFile)
+		host.Status = StatusDryRun
+		return
+	}
+	client, err := sshEC2.Dial(target, options.SSH)
+	if err != nil {
+		host.Status, host.Error = StatusError, err.Error()
+		return
+	}
+	defer client.Close()
+
+	// closing the connection ends the command
+	var timedOut atomic.Bool
+	if options.Timeout > 0 {
+		timer := time.AfterFunc(options.Timeout, func() {
+			timedOut.Store(true)
+			client.Close()
+		})
+		defer timer.Stop()
+	}
+	code, err := sshEC2.Run(client, command, nil, stdout, stderr)
+	switch {
+	case timedOut.Load():
+		host.Status, host.Error = StatusTimeout, fmt.Sprintf("command still running after %s", options.Timeout)
+	case err != nil:
+		host.Status, host.ExitCode, host.Error = StatusError, code, err.Error()
+	case code != 0:
+		host.Status, host.ExitCode = StatusFailed, code
+	default:
+		host.Status, host.ExitCode = StatusOK, code
+	}
+}
+
+// Returns the prefixes of the output lines of the instances, by instance ID:
+// their names (or IDs if they have none, or the same name as another instance),
+// padded to the same width.
+func hostPrefixes(instances []describeEC2.Instance) map[string]string {
+	names := map[string]int{}
+	for _, instance := range instances {
+		names[instance.Name]++
+	}
+	labels := map[string]string{}
+	width := 0
+	for _, instance := range instances {
+		label := instance.Name
+		if label == "" || names[label] > 1 {
+			label = instance.InstanceID
+		}
+		labels[instance.InstanceID] = label
+		width = max(width, len(label))
+	}
+	prefixes := map[string]string{}
+	for id, label := range labels {
+		prefixes[id] = fmt.Sprintf("%-*s | ", width, label)
+	}
+	return prefixes
+}
+
+// Writer copying complete lines to w, prefixed by prefix.
+// The lines of the writers sharing the same lock aren't mixed.
+type prefixWriter struct {
+	lock   *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte // incomplete last line
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	end := bytes.LastIndexByte(p.buf, '\n')
+	if end < 0 {
+		return len(data), nil
+	}
+	lines := strings.SplitAfter(string(p.buf[:end+1]), "\n")
+	p.buf = append(p.buf[:0], p.buf[end+1:]...)
+	var out strings.Builder
+	for _, line := range lines {
+		if line != "" {
+			out.WriteString(p.prefix + line)
+		}
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, err := io.WriteString(p.w, out.String()); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Writes the incomplete last line, if any.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.Write([]byte("\n"))
+	}
+}
@@ -0,0 +1,64 @@
+package execEC2
+
+import (
+	"aws/pkg/describeEC2"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHostPrefixes(t *testing.T) {
+	instances := []describeEC2.Instance{
+		{InstanceID: "i-0001", Name: "web"},
+		{InstanceID: "i-0002", Name: "db"},
+		{InstanceID: "i-0003", Name: "db"},
+		{InstanceID: "i-0004"},
+	}
+	// the instances without name, or with the same name, are labelled by their ID
+	expected := map[string]string{
+		"i-0001": "web    | ",
+		"i-0002": "i-0002 | ",
+		"i-0003": "i-0003 | ",
+		"i-0004": "i-0004 | ",
+	}
+	if prefixes := hostPrefixes(instances); !reflect.DeepEqual(prefixes, expected) {
+		t.Errorf("hostPrefixes() = %q, expected %q", prefixes, expected)
+	}
+
+	expected = map[string]string{"i-0001": "web | ", "i-0005": "api | "}
+	if prefixes := hostPrefixes([]describeEC2.Instance{{InstanceID: "i-0001", Name: "web"}, {InstanceID: "i-0005", Name: "api"}}); !reflect.DeepEqual(prefixes, expected) {
+		t.Errorf("hostPrefixes() = %q, expected %q", prefixes, expected)
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out strings.Builder
+	lock := &sync.Mutex{}
+	web := &prefixWriter{lock: lock, w: &out, prefix: "web | "}
+	db := &prefixWriter{lock: lock, w: &out, prefix: "db  | "}
+
+	for _, write := range []struct {
+		w    *prefixWriter
+		data string
+	}{
+		{web, "hello\nwor"},
+		{db, "starting"}, // incomplete line: kept until its end
+		{web, "ld\n\n"},
+		{db, " postgres\nready"},
+	} {
+		if n, err := write.w.Write([]byte(write.data)); n != len(write.data) || err != nil {
+			t.Errorf("Write(%q) = %d, %v", write.data, n, err)
+		}
+	}
+	web.Flush()
+	db.Flush()
+	expected := "web | hello\n" +
+		"web | world\n" +
+		"web | \n" +
+		"db  | starting postgres\n" +
+		"db  | ready\n"
+	if out.String() != expected {
+		t.Errorf("output = %q, expected %q", out.String(), expected)
+	}
+}
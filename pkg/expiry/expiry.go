@@ -0,0 +1,71 @@
+/*
+Package expiry reads and writes the expiry tags of instances:
+
+	expires-at=2024-01-02T15:04:05Z   expiry date (RFC 3339)
+	ttl=8h                            time to live, after the launch of the instance
+
+Expired instances are stopped or terminated by the reaper (see package reapEC2).
+*/
+package expiry
+
+import (
+	"aws/pkg/selector"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Tags of the instances that expire.
+const (
+	ExpiresAtTag = "expires-at" // date of expiry, in RFC 3339 format (ex: 2024-01-02T15:04:05Z)
+	TTLTag       = "ttl"        // duration after the launch (ex: 8h, 7d); ignored if expires-at is set
+)
+
+// Parses a time to live, such as 90m, 8h, 1h30m, 7d (days) or 2w (weeks).
+func ParseTTL(value string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		ttl, err = selector.ParseDuration(value)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid TTL %q (ex: 8h, 7d)", value)
+	}
+	return ttl, nil
+}
+
+// Returns the tag making an instance expire at the date given in parameter.
+func Tag(expiresAt time.Time) types.Tag {
+	key := ExpiresAtTag
+	value := expiresAt.UTC().Format(time.RFC3339)
+	return types.Tag{Key: &key, Value: &value}
+}
+
+// Returns the expiry date of the instance, and false if it has no expiry tag.
+// An invalid tag returns an error.
+func ExpiresAt(instance types.Instance) (time.Time, bool, error) {
+	tags := make(map[string]string)
+	for _, tag := range instance.Tags {
+		if tag.Key != nil && tag.Value != nil {
+			tags[*tag.Key] = *tag.Value
+		}
+	}
+	if value, ok := tags[ExpiresAtTag]; ok {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, true, fmt.Errorf("invalid tag %s=%s (expected an RFC 3339 date, ex: 2024-01-02T15:04:05Z)", ExpiresAtTag, value)
+		}
+		return expiresAt, true, nil
+	}
+	if value, ok := tags[TTLTag]; ok {
+		ttl, err := ParseTTL(value)
+		if err != nil {
+			return time.Time{}, true, err
+		}
+		if instance.LaunchTime == nil {
+			return time.Time{}, true, fmt.Errorf("tag %s=%s: unknown launch time", TTLTag, value)
+		}
+		return instance.LaunchTime.Add(ttl), true, nil
+	}
+	return time.Time{}, false, nil
+}
@@ -0,0 +1,86 @@
+package expiry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		value string
+		ttl   time.Duration
+	}{
+		{"90m", 90 * time.Minute},
+		{"8h", 8 * time.Hour},
+		{"1h30m", 90 * time.Minute},
+		{"7d", 7 * 24 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+	}
+	for _, test := range tests {
+		ttl, err := ParseTTL(test.value)
+		if err != nil {
+			t.Errorf("ParseTTL(%q): %v", test.value, err)
+		} else if ttl != test.ttl {
+			t.Errorf("ParseTTL(%q) = %s, expected %s", test.value, ttl, test.ttl)
+		}
+	}
+	for _, value := range []string{"", "8", "h", "8y", "1d12h", "eight hours"} {
+		if _, err := ParseTTL(value); err == nil {
+			t.Errorf("ParseTTL(%q): expected an error", value)
+		}
+	}
+}
+
+func tags(keysAndValues ...string) []types.Tag {
+	var tags []types.Tag
+	for i := 0; i < len(keysAndValues); i += 2 {
+		tags = append(tags, types.Tag{Key: aws.String(keysAndValues[i]), Value: aws.String(keysAndValues[i+1])})
+	}
+	return tags
+}
+
+func TestExpiresAt(t *testing.T) {
+	launched := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		instance  types.Instance
+		expiresAt time.Time
+		expires   bool
+		err       bool
+	}{
+		{"no tag", types.Instance{Tags: tags("Name", "web-1")}, time.Time{}, false, false},
+		{"expires-at", types.Instance{Tags: tags(ExpiresAtTag, "2024-01-02T15:04:05Z")}, time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), true, false},
+		{"ttl", types.Instance{Tags: tags(TTLTag, "8h"), LaunchTime: &launched}, launched.Add(8 * time.Hour), true, false},
+		{"ttl in days", types.Instance{Tags: tags(TTLTag, "2d"), LaunchTime: &launched}, launched.Add(48 * time.Hour), true, false},
+		{"expires-at before ttl", types.Instance{Tags: tags(TTLTag, "8h", ExpiresAtTag, "2024-01-03T00:00:00Z"), LaunchTime: &launched}, time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), true, false},
+		{"invalid expires-at", types.Instance{Tags: tags(ExpiresAtTag, "tomorrow")}, time.Time{}, true, true},
+		{"invalid ttl", types.Instance{Tags: tags(TTLTag, "8y"), LaunchTime: &launched}, time.Time{}, true, true},
+		{"ttl without launch time", types.Instance{Tags: tags(TTLTag, "8h")}, time.Time{}, true, true},
+	}
+	for _, test := range tests {
+		expiresAt, expires, err := ExpiresAt(test.instance)
+		if (err != nil) != test.err {
+			t.Errorf("%s: ExpiresAt() error = %v, expected an error: %v", test.name, err, test.err)
+		}
+		if expires != test.expires || !expiresAt.Equal(test.expiresAt) {
+			t.Errorf("%s: ExpiresAt() = %s, %v, expected %s, %v", test.name, expiresAt, expires, test.expiresAt, test.expires)
+		}
+	}
+}
+
+func TestTag(t *testing.T) {
+	paris := time.FixedZone("CET", 3600)
+	expiresAt := time.Date(2024, 1, 2, 16, 4, 5, 0, paris)
+	tag := Tag(expiresAt)
+	if *tag.Key != ExpiresAtTag || *tag.Value != "2024-01-02T15:04:05Z" {
+		t.Errorf("Tag(%s) = %s=%s, expected %s=2024-01-02T15:04:05Z", expiresAt, *tag.Key, *tag.Value, ExpiresAtTag)
+	}
+	read, expires, err := ExpiresAt(types.Instance{Tags: []types.Tag{tag}})
+	if err != nil || !expires || !read.Equal(expiresAt) {
+		t.Errorf("ExpiresAt(Tag(%s)) = %s, %v, %v", expiresAt, read, expires, err)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Package fleet runs an operation on many items (instances, batches of instances...)
+with bounded parallelism.
+
+The operations share an adaptive throttle: when AWS answers that the request rate
+limit is exceeded (RequestLimitExceeded), all the workers slow down, and the
+throttled item is retried after a delay. The delay decreases again as requests succeed.
+*/
+package fleet
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// Concurrency used when Options.Concurrency isn't set.
+const DefaultConcurrency = 10
+
+// Options of Run. The zero value uses the defaults.
+type Options struct {
+	Concurrency int           // maximum number of items processed at the same time (default: DefaultConcurrency)
+	MaxRetries  int           // retries of an item whose request was throttled (default: 5, -1 to never retry)
+	MinDelay    time.Duration // first delay after a throttled request (default: 500ms)
+	MaxDelay    time.Duration // maximum delay between requests (default: 20s)
+	// throttle shared by the workers (if nil, a new one is created with the options above).
+	// Give the same throttle to the requests made inside the operation, so they slow down together.
+	Throttle *Throttle
+}
+
+func (o Options) withDefaults() Options {
+	if o.Concurrency <= 0 {
+		o.Concurrency = DefaultConcurrency
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 5
+	}
+	if o.MinDelay <= 0 {
+		o.MinDelay = 500 * time.Millisecond
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = 20 * time.Second
+	}
+	return o
+}
+
+// Result of the operation on one item.
+type Result[I, O any] struct {
+	Item  I
+	Value O     // value returned by the operation
+	Err   error // error returned by the operation (nil if it succeeded)
+}
+
+// Calls fn on each item, with at most options.Concurrency calls at the same time.
+// Returns the result of each item (in the order of the items),
+// and the errors of all items joined with errors.Join (nil if all succeeded).
+func Run[I, O any](items []I, options Options, fn func(item I) (O, error)) ([]Result[I, O], error) {
+	options = options.withDefaults()
+	results := make([]Result[I, O], len(items))
+	t := options.Throttle
+	if t == nil {
+		t = NewThrottle(options)
+	}
+
+	// workers take the indexes of the items to process from this channel
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(options.Concurrency, len(items)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i].Item = items[i]
+				results[i].Err = t.Do(func() error {
+					var err error
+					results[i].Value, err = fn(items[i])
+					return err
+				})
+			}
+		}()
+	}
+	for i := range items {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// Error codes returned by AWS when requests are throttled.
+var throttleCodes = map[string]bool{
+	"RequestLimitExceeded":     true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestThrottled":         true,
+	"TooManyRequestsException": true,
+}
+
+// Returns true if the error is due to the request rate limit
+// (after the retries of the SDK).
+func IsThrottled(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && throttleCodes[apiErr.ErrorCode()]
+}
+
+// Delay shared by concurrent requests, waited before each request.
+// It doubles each time a request is throttled, and decreases by
+// a quarter each time a request isn't.
+type Throttle struct {
+	mu         sync.Mutex
+	delay      time.Duration
+	min        time.Duration
+	max        time.Duration
+	maxRetries int
+}
+
+// Returns a throttle with the delays and retries of the options.
+func NewThrottle(options Options) *Throttle {
+	options = options.withDefaults()
+	return &Throttle{min: options.MinDelay, max: options.MaxDelay, maxRetries: options.MaxRetries}
+}
+
+// Calls fn after the current delay, and calls it again (up to the maximum number
+// of retries) as long as it returns an error due to the request rate limit.
+// Returns the error of the last call.
+func (t *Throttle) Do(fn func() error) error {
+	for try := 0; ; try++ {
+		t.wait()
+		err := fn()
+		if !IsThrottled(err) {
+			t.recover()
+			return err
+		}
+		t.backoff()
+		if t.maxRetries < 0 || try >= t.maxRetries {
+			return err
+		}
+	}
+}
+
+func (t *Throttle) wait() {
+	t.mu.Lock()
+	delay := t.delay
+	t.mu.Unlock()
+	if delay > 0 {
+		// jitter, so that the workers don't all retry at the same time
+		time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay))))
+	}
+}
+
+func (t *Throttle) backoff() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.delay = min(max(t.delay*2, t.min), t.max)
+}
+
+func (t *Throttle) recover() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.delay -= t.delay / 4
+	if t.delay < t.min/2 {
+		t.delay = 0
+	}
+}
@@ -0,0 +1,149 @@
+package fleet
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// Error returned by AWS when the request rate limit is exceeded.
+var throttled = &smithy.GenericAPIError{Code: "RequestLimitExceeded", Message: "Request limit exceeded."}
+
+func TestIsThrottled(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{errors.New("RequestLimitExceeded"), false},
+		{throttled, true},
+		{fmt.Errorf("couldn't delete instance i-1: %w", throttled), true},
+		{&smithy.GenericAPIError{Code: "Throttling"}, true},
+		{&smithy.GenericAPIError{Code: "InvalidInstanceID.NotFound"}, false},
+	}
+	for _, test := range tests {
+		if throttled := IsThrottled(test.err); throttled != test.expected {
+			t.Errorf("IsThrottled(%v) = %v, expected %v", test.err, throttled, test.expected)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	var running, maxRunning int32
+	results, err := Run(items, Options{Concurrency: 3}, func(item int) (string, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		if item%4 == 0 {
+			return "", fmt.Errorf("item %d failed", item)
+		}
+		return fmt.Sprint(item * 2), nil
+	})
+
+	if maxRunning > 3 {
+		t.Errorf("Run() processed %d items at the same time, expected at most 3", maxRunning)
+	}
+	if len(results) != len(items) {
+		t.Fatalf("Run() returned %d results, expected %d", len(results), len(items))
+	}
+	// the results are in the order of the items
+	for i, result := range results {
+		if result.Item != items[i] {
+			t.Errorf("result %d is of item %d, expected %d", i, result.Item, items[i])
+		}
+		failed := result.Item%4 == 0
+		if (result.Err != nil) != failed {
+			t.Errorf("result of item %d: error = %v, expected an error: %v", result.Item, result.Err, failed)
+		}
+		if !failed && result.Value != fmt.Sprint(result.Item*2) {
+			t.Errorf("result of item %d = %q, expected %q", result.Item, result.Value, fmt.Sprint(result.Item*2))
+		}
+	}
+	// the errors of the items are joined
+	expected := "item 4 failed\nitem 8 failed"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Run() error = %v, expected %q", err, expected)
+	}
+}
+
+func TestRunNoItems(t *testing.T) {
+	results, err := Run(nil, Options{}, func(item int) (int, error) {
+		t.Errorf("fn called without items")
+		return 0, nil
+	})
+	if len(results) != 0 || err != nil {
+		t.Errorf("Run(nil) = %v, %v, expected no results and no error", results, err)
+	}
+}
+
+func TestThrottleDo(t *testing.T) {
+	tests := []struct {
+		maxRetries int
+		throttled  int // number of calls throttled before the call succeeds
+		calls      int
+		failed     bool
+	}{
+		{5, 0, 1, false},
+		{5, 2, 3, false},
+		{2, 5, 3, true},
+		{-1, 5, 1, true}, // never retried
+	}
+	for _, test := range tests {
+		throttle := NewThrottle(Options{MaxRetries: test.maxRetries, MinDelay: time.Microsecond, MaxDelay: time.Millisecond})
+		calls := 0
+		err := throttle.Do(func() error {
+			calls++
+			if calls <= test.throttled {
+				return throttled
+			}
+			return nil
+		})
+		if calls != test.calls {
+			t.Errorf("Do() with %d retries and %d throttled calls: %d calls, expected %d", test.maxRetries, test.throttled, calls, test.calls)
+		}
+		if (err != nil) != test.failed {
+			t.Errorf("Do() with %d retries and %d throttled calls: error = %v, expected an error: %v", test.maxRetries, test.throttled, err, test.failed)
+		}
+	}
+}
+
+func TestThrottleDelay(t *testing.T) {
+	throttle := NewThrottle(Options{MinDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond})
+	delay := func() time.Duration {
+		throttle.mu.Lock()
+		defer throttle.mu.Unlock()
+		return throttle.delay
+	}
+
+	// the delay doubles at each throttled request, up to the maximum
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, e := range expected {
+		throttle.backoff()
+		if d := delay(); d != e {
+			t.Errorf("delay after %d throttled requests = %v, expected %v", i+1, d, e)
+		}
+	}
+	// and decreases by a quarter at each request that isn't throttled,
+	// until it's small enough to be dropped
+	throttle.recover()
+	if d := delay(); d != 225*time.Millisecond {
+		t.Errorf("delay after a successful request = %v, expected %v", d, 225*time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		throttle.recover()
+	}
+	if d := delay(); d != 0 {
+		t.Errorf("delay after 11 successful requests = %v, expected 0", d)
+	}
+}
@@ -0,0 +1,399 @@
+package imageEC2
+
+import (
+	"aws/pkg/dryrun"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// Default maximum time waited for an image or snapshots to be available.
+const DefaultWaitTimeout = 30 * time.Minute
+
+// Tag of the images and snapshots, whose value is the ID of the instance they were created from.
+const SourceInstanceTag = "source-instance"
+
+// Options of CreateImage, CreateSnapshots and Deregister. The zero value uses the defaults.
+type Options struct {
+	Description string
+	// tags of the image and its snapshots, or of the snapshots (besides SourceInstanceTag)
+	Tags map[string]string
+	// CreateImage only: if true, the instance isn't rebooted before the image is created
+	// (faster, but the consistency of the file systems isn't guaranteed)
+	NoReboot bool
+	// if true, returns as soon as the creation started, without waiting for the
+	// image or snapshots to be available (at most WaitTimeout, default: DefaultWaitTimeout)
+	NoWait      bool
+	WaitTimeout time.Duration
+	// if true, the requests creating or deleting images and snapshots are sent with
+	// DryRun=true: AWS checks the permissions without changing anything (see package dryrun)
+	DryRun bool
+	// writer where the progress messages are printed (default: os.Stdout; set it
+	// to os.Stderr to keep stdout for the results, or to io.Discard)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// An AMI (Amazon Machine Image).
+type Image struct {
+	ImageID        string   `json:"image_id" yaml:"image_id"` // can be given to launchEC2.LaunchInstance
+	Name           string   `json:"name" yaml:"name"`
+	State          string   `json:"state" yaml:"state"` // pending, available, failed...
+	Architecture   string   `json:"architecture,omitempty" yaml:"architecture,omitempty"`
+	CreationDate   string   `json:"creation_date,omitempty" yaml:"creation_date,omitempty"`
+	SourceInstance string   `json:"source_instance,omitempty" yaml:"source_instance,omitempty"`
+	Snapshots      []string `json:"snapshots,omitempty" yaml:"snapshots,omitempty"` // IDs of the snapshots of its volumes
+	DryRun         bool     `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`     // true if it would have been created
+}
+
+func newImage(image types.Image) Image {
+	result := Image{
+		ImageID:      deref(image.ImageId),
+		Name:         deref(image.Name),
+		State:        string(image.State),
+		Architecture: string(image.Architecture),
+		CreationDate: deref(image.CreationDate),
+	}
+	for _, tag := range image.Tags {
+		if deref(tag.Key) == SourceInstanceTag {
+			result.SourceInstance = deref(tag.Value)
+		}
+	}
+	for _, mapping := range image.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
+			result.Snapshots = append(result.Snapshots, *mapping.Ebs.SnapshotId)
+		}
+	}
+	return result
+}
+
+func (i *Image) Table() ([]string, [][]string) {
+	return (&ImageList{Images: []Image{*i}}).Table()
+}
+
+func (i *Image) IDs() []string {
+	return []string{i.ImageID}
+}
+
+// Result of ListImages.
+type ImageList struct {
+	Images []Image `json:"images" yaml:"images"`
+}
+
+func (l *ImageList) Table() ([]string, [][]string) {
+	rows := make([][]string, len(l.Images))
+	for i, image := range l.Images {
+		state := image.State
+		if image.DryRun {
+			state = "dry run"
+		}
+		rows[i] = []string{image.ImageID, image.Name, state, image.Architecture, image.CreationDate, image.SourceInstance, strings.Join(image.Snapshots, " ")}
+	}
+	return []string{"IMAGE ID", "NAME", "STATE", "ARCHITECTURE", "CREATED", "SOURCE INSTANCE", "SNAPSHOTS"}, rows
+}
+
+func (l *ImageList) IDs() []string {
+	ids := make([]string, len(l.Images))
+	for i, image := range l.Images {
+		ids[i] = image.ImageID
+	}
+	return ids
+}
+
+// A snapshot of a volume.
+type Snapshot struct {
+	SnapshotID string `json:"snapshot_id" yaml:"snapshot_id"`
+	VolumeID   string `json:"volume_id" yaml:"volume_id"`
+	VolumeSize int32  `json:"volume_size" yaml:"volume_size"` // GiB
+	State      string `json:"state" yaml:"state"`             // pending, completed, error...
+	Deleted    bool   `json:"deleted,omitempty" yaml:"deleted,omitempty"`
+	DryRun     bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"` // true if it would have been created or deleted
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Result of CreateSnapshots, or snapshots deleted by Deregister.
+type SnapshotList struct {
+	InstanceID string     `json:"instance_id,omitempty" yaml:"instance_id,omitempty"`
+	ImageID    string     `json:"image_id,omitempty" yaml:"image_id,omitempty"` // image deregistered (see Deregister)
+	Snapshots  []Snapshot `json:"snapshots" yaml:"snapshots"`
+}
+
+func (l *SnapshotList) Table() ([]string, [][]string) {
+	rows := make([][]string, len(l.Snapshots))
+	for i, s := range l.Snapshots {
+		state := s.State
+		switch {
+		case s.DryRun:
+			state = "dry run"
+		case s.Deleted:
+			state = "deleted"
+		}
+		size := ""
+		if s.VolumeSize > 0 {
+			size = fmt.Sprintf("%d GiB", s.VolumeSize)
+		}
+		rows[i] = []string{s.SnapshotID, s.VolumeID, size, state, s.Error}
+	}
+	return []string{"SNAPSHOT ID", "VOLUME ID", "SIZE", "STATE", "ERROR"}, rows
+}
+
+func (l *SnapshotList) IDs() []string {
+	ids := make([]string, len(l.Snapshots))
+	for i, s := range l.Snapshots {
+		ids[i] = s.SnapshotID
+	}
+	return ids
+}
+
+// Returns the tag specifications of the resource types given in parameter,
+// with the tags given in parameter and SourceInstanceTag.
+func tagSpecifications(instanceId string, tags map[string]string, resourceTypes ...types.ResourceType) []types.TagSpecification {
+	keys := []string{SourceInstanceTag}
+	values := map[string]string{SourceInstanceTag: instanceId}
+	for key, value := range tags {
+		if key != SourceInstanceTag {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	sort.Strings(keys[1:])
+	var specifications []types.TagSpecification
+	for _, resourceType := range resourceTypes {
+		specification := types.TagSpecification{ResourceType: resourceType}
+		for _, key := range keys {
+			key, value := key, values[key]
+			specification.Tags = append(specification.Tags, types.Tag{Key: &key, Value: &value})
+		}
+		specifications = append(specifications, specification)
+	}
+	return specifications
+}
+
+// Creates an AMI of name given in parameter from the instance of ID given in parameter,
+// with a snapshot of each of its EBS volumes. The instance is rebooted first, unless
+// options.NoReboot. The image and its snapshots are tagged with options.Tags and
+// SourceInstanceTag. Unless options.NoWait, waits until the image is available:
+// its ID can then be given to launchEC2.LaunchInstance. If the wait fails (ex: the
+// image isn't available before options.WaitTimeout), the image is returned as pending
+// along with the error (its creation may continue: see WaitImage and Deregister).
+func CreateImage(ec2client *ec2.Client, instanceId string, name string, options Options) (*Image, error) {
+	input := &ec2.CreateImageInput{
+		InstanceId:        &instanceId,
+		Name:              &name,
+		NoReboot:          &options.NoReboot,
+		TagSpecifications: tagSpecifications(instanceId, options.Tags, types.ResourceTypeImage, types.ResourceTypeSnapshot),
+		DryRun:            &options.DryRun,
+	}
+	if options.Description != "" {
+		input.Description = &options.Description
+	}
+	output, err := ec2client.CreateImage(context.TODO(), input)
+	if options.DryRun && dryrun.Succeeded(err) {
+		fmt.Fprintf(options.messages(), "Dry run: image %s would be created from instance %s.\n", name, instanceId)
+		return &Image{Name: name, SourceInstance: instanceId, DryRun: true}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create image %s from instance %s: %w", name, instanceId, err)
+	}
+	result := &Image{ImageID: *output.ImageId, Name: name, State: string(types.ImageStatePending), SourceInstance: instanceId}
+	fmt.Fprintf(options.messages(), "Image %s (%s) is being created from instance %s.\n", result.ImageID, name, instanceId)
+	if options.NoWait {
+		return result, nil
+	}
+	image, err := WaitImage(ec2client, result.ImageID, options.WaitTimeout, options.messages())
+	if err != nil {
+		return result, err
+	}
+	return image, nil
+}
+
+// Waits until the image of ID given in parameter is available, for at most timeout
+// (DefaultWaitTimeout if timeout <= 0), printing the progress in progress, and returns it.
+func WaitImage(ec2client *ec2.Client, imageId string, timeout time.Duration, progress io.Writer) (*Image, error) {
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	fmt.Fprintf(progress, "Waiting for image %s to be available...\n", imageId)
+	input := &ec2.DescribeImagesInput{ImageIds: []string{imageId}}
+	output, err := ec2.NewImageAvailableWaiter(ec2client).WaitForOutput(context.TODO(), input, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("image %s isn't available: %w", imageId, err)
+	}
+	if len(output.Images) == 0 {
+		return nil, fmt.Errorf("couldn't find image %s", imageId)
+	}
+	image := newImage(output.Images[0])
+	fmt.Fprintf(progress, "Image %s available.\n", imageId)
+	return &image, nil
+}
+
+// Creates a snapshot of each EBS volume of the instance of ID given in parameter
+// (the snapshots are crash-consistent across the volumes), tagged with the tags of
+// their volume, options.Tags and SourceInstanceTag. Unless options.NoWait, waits
+// until the snapshots are completed.
+func CreateSnapshots(ec2client *ec2.Client, instanceId string, options Options) (*SnapshotList, error) {
+	input := &ec2.CreateSnapshotsInput{
+		InstanceSpecification: &types.InstanceSpecification{InstanceId: &instanceId},
+		CopyTagsFromSource:    types.CopyTagsFromSourceVolume,
+		TagSpecifications:     tagSpecifications(instanceId, options.Tags, types.ResourceTypeSnapshot),
+		DryRun:                &options.DryRun,
+	}
+	if options.Description != "" {
+		input.Description = &options.Description
+	}
+	output, err := ec2client.CreateSnapshots(context.TODO(), input)
+	result := &SnapshotList{InstanceID: instanceId, Snapshots: []Snapshot{}}
+	if options.DryRun && dryrun.Succeeded(err) {
+		fmt.Fprintf(options.messages(), "Dry run: the volumes of instance %s would be snapshotted.\n", instanceId)
+		result.Snapshots = append(result.Snapshots, Snapshot{DryRun: true})
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't snapshot the volumes of instance %s: %w", instanceId, err)
+	}
+	var ids []string
+	for _, s := range output.Snapshots {
+		result.Snapshots = append(result.Snapshots, Snapshot{
+			SnapshotID: deref(s.SnapshotId),
+			VolumeID:   deref(s.VolumeId),
+			VolumeSize: deref(s.VolumeSize),
+			State:      string(s.State),
+		})
+		ids = append(ids, deref(s.SnapshotId))
+	}
+	fmt.Fprintf(options.messages(), "%d snapshots of the volumes of instance %s started.\n", len(ids), instanceId)
+	if options.NoWait || len(ids) == 0 {
+		return result, nil
+	}
+
+	timeout := options.WaitTimeout
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	fmt.Fprintf(options.messages(), "Waiting for %d snapshots to be completed...\n", len(ids))
+	waiter := ec2.NewSnapshotCompletedWaiter(ec2client)
+	if err := waiter.Wait(context.TODO(), &ec2.DescribeSnapshotsInput{SnapshotIds: ids}, timeout); err != nil {
+		return result, fmt.Errorf("the snapshots of instance %s aren't completed: %w", instanceId, err)
+	}
+	for i := range result.Snapshots {
+		result.Snapshots[i].State = string(types.SnapshotStateCompleted)
+	}
+	fmt.Fprintf(options.messages(), "%d snapshots completed.\n", len(ids))
+	return result, nil
+}
+
+// Lists the images owned by the account.
+func ListImages(ec2client *ec2.Client) (*ImageList, error) {
+	list := &ImageList{Images: []Image{}}
+	paginator := ec2.NewDescribeImagesPaginator(ec2client, &ec2.DescribeImagesInput{Owners: []string{"self"}})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("error fetching images info: %w", err)
+		}
+		for _, image := range output.Images {
+			list.Images = append(list.Images, newImage(image))
+		}
+	}
+	// most recent first
+	sort.SliceStable(list.Images, func(i, j int) bool { return list.Images[i].CreationDate > list.Images[j].CreationDate })
+	return list, nil
+}
+
+// Returns the ID of the image given by ID (ami-...), returned as is, or by name
+// (among the images owned by the account).
+func Resolve(ec2client *ec2.Client, image string) (string, error) {
+	if strings.HasPrefix(image, "ami-") {
+		return image, nil
+	}
+	filterName := "name"
+	output, err := ec2client.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{
+		Owners:  []string{"self"},
+		Filters: []types.Filter{{Name: &filterName, Values: []string{image}}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't find image %s: %w", image, err)
+	}
+	if len(output.Images) != 1 {
+		return "", fmt.Errorf("%d images named %s owned by the account (expected 1)", len(output.Images), image)
+	}
+	return *output.Images[0].ImageId, nil
+}
+
+// Deregisters the image of ID given in parameter, and deletes the snapshots of its
+// volumes (unless keepSnapshots). The result lists the snapshots; the deregistration
+// fails if the image doesn't exist, but it continues if a snapshot can't be deleted.
+func Deregister(ec2client *ec2.Client, imageId string, keepSnapshots bool, options Options) (*SnapshotList, error) {
+	output, err := ec2client.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{ImageIds: []string{imageId}})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find image %s: %w", imageId, err)
+	}
+	if len(output.Images) == 0 {
+		return nil, fmt.Errorf("couldn't find image %s", imageId)
+	}
+	image := newImage(output.Images[0])
+	result := &SnapshotList{ImageID: imageId, Snapshots: []Snapshot{}}
+
+	_, err = ec2client.DeregisterImage(context.TODO(), &ec2.DeregisterImageInput{ImageId: &imageId, DryRun: &options.DryRun})
+	switch {
+	case options.DryRun && dryrun.Succeeded(err):
+		fmt.Fprintf(options.messages(), "Dry run: image %s would be deregistered.\n", imageId)
+	case err != nil:
+		return nil, fmt.Errorf("couldn't deregister image %s: %w", imageId, err)
+	default:
+		fmt.Fprintf(options.messages(), "Image %s deregistered.\n", imageId)
+	}
+	if keepSnapshots {
+		for _, id := range image.Snapshots {
+			result.Snapshots = append(result.Snapshots, Snapshot{SnapshotID: id, State: "kept"})
+		}
+		return result, nil
+	}
+
+	var errs []error
+	for _, id := range image.Snapshots {
+		snapshot := Snapshot{SnapshotID: id}
+		_, err := ec2client.DeleteSnapshot(context.TODO(), &ec2.DeleteSnapshotInput{SnapshotId: &id, DryRun: &options.DryRun})
+		var apiErr smithy.APIError
+		switch {
+		case options.DryRun && dryrun.Succeeded(err):
+			snapshot.DryRun = true
+			fmt.Fprintf(options.messages(), "Dry run: snapshot %s would be deleted.\n", id)
+		case errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidSnapshot.NotFound":
+			snapshot.Deleted = true
+			fmt.Fprintf(options.messages(), "Snapshot %s was already deleted.\n", id)
+		case err != nil:
+			snapshot.Error = err.Error()
+			errs = append(errs, fmt.Errorf("couldn't delete snapshot %s: %w", id, err))
+		default:
+			snapshot.Deleted = true
+			fmt.Fprintf(options.messages(), "Snapshot %s deleted.\n", id)
+		}
+		result.Snapshots = append(result.Snapshots, snapshot)
+	}
+	return result, errors.Join(errs...)
+}
+
+func deref[T any](v *T) T {
+	if v == nil {
+		var zero T
+		return zero
+	}
+	return *v
+}
@@ -0,0 +1,86 @@
+package imageEC2
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestNewImage(t *testing.T) {
+	image := types.Image{
+		ImageId:      aws.String("ami-1"),
+		Name:         aws.String("web-backup"),
+		State:        types.ImageStateAvailable,
+		Architecture: types.ArchitectureValuesX8664,
+		CreationDate: aws.String("2024-03-15T10:00:00.000Z"),
+		Tags:         []types.Tag{{Key: aws.String("env"), Value: aws.String("dev")}, {Key: aws.String(SourceInstanceTag), Value: aws.String("i-1")}},
+		BlockDeviceMappings: []types.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-1")}},
+			// instance store volumes have no snapshot
+			{DeviceName: aws.String("/dev/sdb"), VirtualName: aws.String("ephemeral0")},
+			{DeviceName: aws.String("/dev/sdc"), Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-2")}},
+		},
+	}
+	expected := Image{
+		ImageID:        "ami-1",
+		Name:           "web-backup",
+		State:          "available",
+		Architecture:   "x86_64",
+		CreationDate:   "2024-03-15T10:00:00.000Z",
+		SourceInstance: "i-1",
+		Snapshots:      []string{"snap-1", "snap-2"},
+	}
+	if result := newImage(image); !reflect.DeepEqual(result, expected) {
+		t.Errorf("newImage() = %+v, expected %+v", result, expected)
+	}
+}
+
+func TestTagSpecifications(t *testing.T) {
+	tags := map[string]string{"env": "dev", "app": "web", SourceInstanceTag: "ignored"}
+	specifications := tagSpecifications("i-1", tags, types.ResourceTypeImage, types.ResourceTypeSnapshot)
+	if len(specifications) != 2 {
+		t.Fatalf("tagSpecifications() = %d specifications, expected 2", len(specifications))
+	}
+	for i, resourceType := range []types.ResourceType{types.ResourceTypeImage, types.ResourceTypeSnapshot} {
+		specification := specifications[i]
+		if specification.ResourceType != resourceType {
+			t.Errorf("specification %d is of %s, expected %s", i, specification.ResourceType, resourceType)
+		}
+		// SourceInstanceTag first, then the other tags sorted by key; the value
+		// of SourceInstanceTag given in the tags overrides the instance ID
+		var result []string
+		for _, tag := range specification.Tags {
+			result = append(result, *tag.Key+"="+*tag.Value)
+		}
+		expected := []string{SourceInstanceTag + "=ignored", "app=web", "env=dev"}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("tags of %s = %v, expected %v", resourceType, result, expected)
+		}
+	}
+
+	specifications = tagSpecifications("i-1", nil, types.ResourceTypeSnapshot)
+	if len(specifications) != 1 || len(specifications[0].Tags) != 1 || *specifications[0].Tags[0].Value != "i-1" {
+		t.Errorf("tagSpecifications() without tags = %+v, expected only %s=i-1", specifications, SourceInstanceTag)
+	}
+}
+
+func TestSnapshotListTable(t *testing.T) {
+	list := &SnapshotList{Snapshots: []Snapshot{
+		{SnapshotID: "snap-1", VolumeID: "vol-1", VolumeSize: 8, State: "completed"},
+		{SnapshotID: "snap-2", State: "completed", Deleted: true},
+		{SnapshotID: "snap-3", DryRun: true},
+		{SnapshotID: "snap-4", State: "completed", Error: "in use"},
+	}}
+	_, rows := list.Table()
+	expected := [][]string{
+		{"snap-1", "vol-1", "8 GiB", "completed", ""},
+		{"snap-2", "", "", "deleted", ""},
+		{"snap-3", "", "", "dry run", ""},
+		{"snap-4", "", "", "completed", "in use"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Table() rows = %v, expected %v", rows, expected)
+	}
+}
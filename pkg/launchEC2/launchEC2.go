@@ -1,11 +1,16 @@
 package launchEC2
 
 import (
+	"aws/pkg/confirm"
+	"aws/pkg/describeEC2"
+	"aws/pkg/dryrun"
+	"aws/pkg/expiry"
+	"aws/pkg/fleet"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
@@ -13,8 +18,49 @@ import (
 	"github.com/aws/smithy-go"
 )
 
+// Options of the functions creating resources. The zero value uses the defaults.
+type Options struct {
+	// if true, the requests creating resources are sent with DryRun=true:
+	// AWS checks the permissions without creating anything, and the resources
+	// that would be created are printed (see package dryrun)
+	DryRun bool
+	// writer where the progress messages are printed (default: os.Stdout; set it
+	// to os.Stderr to keep stdout for the results, or to io.Discard)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// Result of ConfigureSecurityGroup.
+type SecurityGroup struct {
+	GroupName string `json:"group_name" yaml:"group_name"`
+	GroupID   string `json:"group_id,omitempty" yaml:"group_id,omitempty"` // only known if created
+	Created   bool   `json:"created" yaml:"created"`                       // false if it already existed
+	DryRun    bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`   // true if it would have been created
+}
+
+func (sg *SecurityGroup) Table() ([]string, [][]string) {
+	return []string{"GROUP NAME", "GROUP ID", "CREATED"}, [][]string{{sg.GroupName, sg.GroupID, fmt.Sprint(sg.Created)}}
+}
+
+func (sg *SecurityGroup) IDs() []string {
+	if sg.GroupID == "" {
+		return []string{sg.GroupName}
+	}
+	return []string{sg.GroupID}
+}
+
 // Creates a security group allowing traffic to the instance.
-func ConfigureSecurityGroup(ec2client *ec2.Client, securityGroupName string) error {
+// In dry run mode (options.DryRun), only checks if the group would be created.
+func ConfigureSecurityGroup(ec2client *ec2.Client, securityGroupName string, options Options) (*SecurityGroup, error) {
+	result := &SecurityGroup{GroupName: securityGroupName}
+
 	// information about the security group
 	desc := "allow SSH access"
 	securityGroupInput := ec2.CreateSecurityGroupInput{
@@ -22,22 +68,29 @@ func ConfigureSecurityGroup(ec2client *ec2.Client, securityGroupName string) err
 		GroupName:   &securityGroupName,
 	}
 
+	if options.DryRun {
+		return dryRunSecurityGroup(ec2client, &securityGroupInput, options)
+	}
+
 	// creates the new security group
-	_, err := ec2client.CreateSecurityGroup(context.TODO(), &securityGroupInput)
+	createOutput, err := ec2client.CreateSecurityGroup(context.TODO(), &securityGroupInput)
 
 	var apiErr smithy.APIError
 	if errors.As(err, &apiErr) {
 		// if a security group by this name already exists,
 		// I don't do anything (to adapt to the desired usage)
 		if apiErr.ErrorCode() == "InvalidGroup.Duplicate" {
-			fmt.Printf("Security group %s already exists.\n", securityGroupName)
-			return nil
+			fmt.Fprintf(options.messages(), "Security group %s already exists.\n", securityGroupName)
+			return result, nil
 		}
-	} else if err != nil {
-		return fmt.Errorf("error creating security group %s: %w", securityGroupName, err)
 	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating security group %s: %w", securityGroupName, err)
+	}
+	result.GroupID = *createOutput.GroupId
+	result.Created = true
 
-	fmt.Printf("Security Group %s created.\n", securityGroupName)
+	fmt.Fprintf(options.messages(), "Security Group %s created.\n", securityGroupName)
 
 	/* Add inbound rules to the security group.
 	   This will allow the instance to receive traffic from the
@@ -77,59 +130,107 @@ func ConfigureSecurityGroup(ec2client *ec2.Client, securityGroupName string) err
 
 	_, err = ec2client.AuthorizeSecurityGroupIngress(context.TODO(), &inboundRulesInput)
 	if err != nil {
-		return fmt.Errorf("error adding inbound rules to security group %s: %w", securityGroupName, err)
+		return result, fmt.Errorf("error adding inbound rules to security group %s: %w", securityGroupName, err)
+	}
+
+	fmt.Fprintf(options.messages(), "Done configuring security group %s.\n", securityGroupName)
+
+	return result, nil
+}
+
+// Dry run of ConfigureSecurityGroup: prints what would be done.
+func dryRunSecurityGroup(ec2client *ec2.Client, securityGroupInput *ec2.CreateSecurityGroupInput, options Options) (*SecurityGroup, error) {
+	securityGroupName := *securityGroupInput.GroupName
+	result := &SecurityGroup{GroupName: securityGroupName}
+
+	// AWS doesn't check in a dry run if the group already exists
+	groupNameFilter := "group-name"
+	describeOutput, err := ec2client.DescribeSecurityGroups(context.TODO(), &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{{Name: &groupNameFilter, Values: []string{securityGroupName}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching security groups info: %w", err)
 	}
+	if len(describeOutput.SecurityGroups) > 0 {
+		fmt.Fprintf(options.messages(), "Security group %s already exists.\n", securityGroupName)
+		result.GroupID = *describeOutput.SecurityGroups[0].GroupId
+		return result, nil
+	}
+
+	dryRun := true
+	securityGroupInput.DryRun = &dryRun
+	_, err = ec2client.CreateSecurityGroup(context.TODO(), securityGroupInput)
+	if err = dryrun.Check(err); err != nil {
+		return nil, fmt.Errorf("dry run: creating security group %s would fail: %w", securityGroupName, err)
+	}
+	// the rules can't be checked, as the group doesn't exist
+	fmt.Fprintf(options.messages(), "Dry run: security group %s would be created, with inbound rules: TCP port 22 (SSH) and TCP port 8080 from 0.0.0.0/0.\n", securityGroupName)
+	result.DryRun = true
+	return result, nil
+}
 
-	fmt.Printf("Done configuring security group %s.\n", securityGroupName)
+// Result of ConfigureAccessKey.
+type KeyPair struct {
+	KeyName string `json:"key_name" yaml:"key_name"`
+	Created bool   `json:"created" yaml:"created"`                       // false if it already existed
+	KeyFile string `json:"key_file,omitempty" yaml:"key_file,omitempty"` // file of the private key, if created
+	DryRun  bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`   // true if it would have been created
+}
+
+func (k *KeyPair) Table() ([]string, [][]string) {
+	return []string{"KEY NAME", "CREATED", "KEY FILE"}, [][]string{{k.KeyName, fmt.Sprint(k.Created), k.KeyFile}}
+}
 
-	return nil
+func (k *KeyPair) IDs() []string {
+	return []string{k.KeyName}
 }
 
 // If the EC2 access key doesn't exist: creates and downloads one.
 // This key will be used to connect with SSH to the instance.
-func ConfigureAccessKey(ec2client *ec2.Client, ec2KeyName string) error {
-	// first let's check if the desired access key already exists
-	describeOutput, err := ec2client.DescribeKeyPairs(context.TODO(), &ec2.DescribeKeyPairsInput{})
+// The confirmer decides if a missing key should be created
+// (use confirm.NewTerminal() to ask the user).
+// In dry run mode (options.DryRun), only checks if the key would be created (without asking).
+func ConfigureAccessKey(ec2client *ec2.Client, ec2KeyName string, confirmer confirm.Confirmer, options Options) (*KeyPair, error) {
+	result := &KeyPair{KeyName: ec2KeyName}
+	// first let's check if the desired access key already exists.
+	// DescribeKeyPairs isn't paginated: instead of fetching all the key pairs,
+	// we ask AWS for the key of this name only.
+	keyNameFilter := "key-name"
+	describeOutput, err := ec2client.DescribeKeyPairs(context.TODO(), &ec2.DescribeKeyPairsInput{
+		Filters: []types.Filter{{Name: &keyNameFilter, Values: []string{ec2KeyName}}},
+	})
 	if err != nil {
-		return fmt.Errorf("error fetching key pairs info: %w", err)
+		return nil, fmt.Errorf("error fetching key pairs info: %w", err)
 	}
-	exists := false
-	for _, keyPair := range describeOutput.KeyPairs {
-		if *keyPair.KeyName == ec2KeyName {
-			exists = true
-			break
-		}
-	}
-	if exists {
+	if len(describeOutput.KeyPairs) > 0 {
 		// if it exists, exit.
-		fmt.Printf("EC2 key %s already exists.\n", ec2KeyName)
-		return nil
+		fmt.Fprintf(options.messages(), "EC2 key %s already exists.\n", ec2KeyName)
+		return result, nil
 	}
 
-	// if the key pair doesn't exist,
-	// prompt user to ask if they want to create the key pair.
-	fmt.Printf("> EC2 key \"%s\" doesn't exist. Do you want to create it? (Y/N): ", ec2KeyName)
-	validAnswer := false
-	// prompt loop (until user gives an valid answer)
-	for !validAnswer {
-		var answer string
-		_, err = fmt.Scan(&answer)
-		if err != nil {
-			return fmt.Errorf("error reading user input: %w", err)
-		}
-		answer = strings.ToUpper(answer)
-
-		switch answer {
-		case "Y":
-			validAnswer = true
-		case "N":
-			/* if user chooses to not create the key,
-			   returns an error as the key was unabled to be
-			   configure (to adapt to desired usage) */
-			return fmt.Errorf("key %s not created", ec2KeyName)
-		default:
-			fmt.Println("Invalid output, please answer \"Y\" or \"N\": ")
+	if options.DryRun {
+		dryRun := true
+		_, err = ec2client.CreateKeyPair(context.TODO(), &ec2.CreateKeyPairInput{KeyName: &ec2KeyName, DryRun: &dryRun})
+		if err = dryrun.Check(err); err != nil {
+			return nil, fmt.Errorf("dry run: creating key pair %s would fail: %w", ec2KeyName, err)
 		}
+		fmt.Fprintf(options.messages(), "Dry run: key pair \"%s\" would be created (after confirmation), and its private key written in file %s.pem.\n", ec2KeyName, ec2KeyName)
+		result.DryRun = true
+		result.KeyFile = ec2KeyName + ".pem"
+		return result, nil
+	}
+
+	// if the key pair doesn't exist,
+	// ask if we should create the key pair.
+	create, err := confirmer.Confirm(confirm.ActionCreateKey, fmt.Sprintf("EC2 key \"%s\" doesn't exist. Do you want to create it?", ec2KeyName))
+	if err != nil {
+		return nil, err
+	}
+	if !create {
+		/* if user chooses to not create the key,
+		   returns an error as the key was unabled to be
+		   configure (to adapt to desired usage) */
+		return nil, fmt.Errorf("key %s not created", ec2KeyName)
 	}
 
 	/* if EC2 key pair doesn't exist and user decides to create it,
@@ -141,27 +242,54 @@ func ConfigureAccessKey(ec2client *ec2.Client, ec2KeyName string) error {
 	}
 	key, err := ec2client.CreateKeyPair(context.TODO(), &createKeyPairInput)
 	if err != nil {
-		return fmt.Errorf("error: creating key pair %s failed: %w", ec2KeyName, err)
+		return nil, fmt.Errorf("error: creating key pair %s failed: %w", ec2KeyName, err)
 	}
-	fmt.Printf("Key pair \"%s\" successfully created on AWS.\n", ec2KeyName)
+	fmt.Fprintf(options.messages(), "Key pair \"%s\" successfully created on AWS.\n", ec2KeyName)
+	result.Created = true
 
 	// write the private key in a file and restrict permissions
 	err = os.WriteFile(ec2KeyName+".pem", []byte(*key.KeyMaterial), 0400)
 	if err != nil {
-		return fmt.Errorf("couldn't create file \"%s.pem\"! The key was created but not downloaded. aws error: %w", ec2KeyName, err)
+		return result, fmt.Errorf("couldn't create file \"%s.pem\"! The key was created but not downloaded. aws error: %w", ec2KeyName, err)
 	}
 
-	fmt.Printf("Private key downloaded in file %s.pem.\n", ec2KeyName)
-	return nil
+	fmt.Fprintf(options.messages(), "Private key downloaded in file %s.pem.\n", ec2KeyName)
+	result.KeyFile = ec2KeyName + ".pem"
+	return result, nil
+}
+
+// Result of LaunchInstance.
+type LaunchResult struct {
+	InstanceID    string `json:"instance_id" yaml:"instance_id"`
+	Name          string `json:"name" yaml:"name"`
+	InstanceType  string `json:"instance_type" yaml:"instance_type"`
+	AMI           string `json:"ami" yaml:"ami"`
+	SecurityGroup string `json:"security_group" yaml:"security_group"`
+	KeyName       string `json:"key_name" yaml:"key_name"`
+	ExpiresAt     string `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`       // expiry date, if launched with a TTL
+	PublicIP      string `json:"public_ip,omitempty" yaml:"public_ip,omitempty"`         // set by the caller, once retrieved with GetPublicIP
+	AllocationID  string `json:"allocation_id,omitempty" yaml:"allocation_id,omitempty"` // set by the caller, if an Elastic IP was associated
+	DryRun        bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`             // true if it would have been launched (InstanceID is then empty)
+}
+
+func (r *LaunchResult) Table() ([]string, [][]string) {
+	return []string{"INSTANCE ID", "NAME", "TYPE", "AMI", "SECURITY GROUP", "KEY", "PUBLIC IP"},
+		[][]string{{r.InstanceID, r.Name, r.InstanceType, r.AMI, r.SecurityGroup, r.KeyName, r.PublicIP}}
+}
+
+func (r *LaunchResult) IDs() []string {
+	return []string{r.InstanceID}
 }
 
 /*
 Launches a EC2 Instance of type and AMI (Amazon Machine Image) given in parameters.
 It will also be associated with the access key, security group, and name given in parameters.
-If successfull, this function returns the ID of the instance created. This will be used
-to describe the instance later
+If ttl > 0, the instance is tagged to expire after ttl (see package expiry).
+If successfull, this function returns the instance created, with its ID. This will be used
+to describe the instance later.
+In dry run mode (options.DryRun), only checks if the instance would be launched.
 */
-func LaunchInstance(ec2client *ec2.Client, instanceType string, AMI_id string, securityGroupName string, ec2KeyName string, instanceName string) (string, error) {
+func LaunchInstance(ec2client *ec2.Client, instanceType string, AMI_id string, securityGroupName string, ec2KeyName string, instanceName string, ttl time.Duration, options Options) (*LaunchResult, error) {
 	/* Creates a tag to name the instance.
 	   The name is simply a tag called "Name".
 	   This code would work the same to create any tag.
@@ -175,6 +303,11 @@ func LaunchInstance(ec2client *ec2.Client, instanceType string, AMI_id string, s
 		ResourceType: "instance",
 		Tags:         []types.Tag{tag},
 	}
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+		tagSpecification.Tags = append(tagSpecification.Tags, expiry.Tag(expiresAt))
+	}
 
 	// launches the instance
 	nbInstance := int32(1)
@@ -187,19 +320,65 @@ func LaunchInstance(ec2client *ec2.Client, instanceType string, AMI_id string, s
 		SecurityGroups:    []string{securityGroupName},                // associate security group
 		TagSpecifications: []types.TagSpecification{tagSpecification}, // associate Name
 	}
+	result := &LaunchResult{
+		Name:          instanceName,
+		InstanceType:  instanceType,
+		AMI:           AMI_id,
+		SecurityGroup: securityGroupName,
+		KeyName:       ec2KeyName,
+	}
+	if ttl > 0 {
+		result.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+	}
+	if options.DryRun {
+		return dryRunLaunch(ec2client, &runInstanceInput, result, options)
+	}
+
 	instanceOutput, err := ec2client.RunInstances(context.TODO(), &runInstanceInput)
 	if err != nil {
-		return "", fmt.Errorf("failed to launch instance: %w", err)
+		return nil, fmt.Errorf("failed to launch instance: %w", err)
 	}
 	// print the instance ID. This can be used to fetch additionnal info on the instance
-	fmt.Printf("New instance successfully launched, with the following attributes:\n")
-	fmt.Printf(" - id: %s\n", *instanceOutput.Instances[0].InstanceId)
-	fmt.Printf(" - name: %s\n", instanceName)
-	fmt.Printf(" - access key: %s\n", ec2KeyName)
-	fmt.Printf(" - type, AMI: %s, %s\n", instanceType, AMI_id)
-	fmt.Printf(" - security group: %s\n", securityGroupName)
+	fmt.Fprintf(options.messages(), "New instance successfully launched, with the following attributes:\n")
+	fmt.Fprintf(options.messages(), " - id: %s\n", *instanceOutput.Instances[0].InstanceId)
+	fmt.Fprintf(options.messages(), " - name: %s\n", instanceName)
+	fmt.Fprintf(options.messages(), " - access key: %s\n", ec2KeyName)
+	fmt.Fprintf(options.messages(), " - type, AMI: %s, %s\n", instanceType, AMI_id)
+	fmt.Fprintf(options.messages(), " - security group: %s\n", securityGroupName)
+	if ttl > 0 {
+		fmt.Fprintf(options.messages(), " - expires at: %s\n", result.ExpiresAt)
+	}
 
-	return *instanceOutput.Instances[0].InstanceId, nil
+	result.InstanceID = *instanceOutput.Instances[0].InstanceId
+	return result, nil
+}
+
+// Dry run of LaunchInstance: prints what would be done.
+func dryRunLaunch(ec2client *ec2.Client, runInstanceInput *ec2.RunInstancesInput, result *LaunchResult, options Options) (*LaunchResult, error) {
+	dryRun := true
+	runInstanceInput.DryRun = &dryRun
+	_, err := ec2client.RunInstances(context.TODO(), runInstanceInput)
+
+	// in a dry run of the launch command, the security group and key pair may not
+	// have been created: we check the rest of the request without them.
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "InvalidGroup.NotFound" || apiErr.ErrorCode() == "InvalidKeyPair.NotFound") {
+		fmt.Fprintf(options.messages(), "Dry run: security group %s or key pair %s doesn't exist, it must be created before launching the instance.\n", result.SecurityGroup, result.KeyName)
+		runInstanceInput.SecurityGroups = nil
+		runInstanceInput.KeyName = nil
+		_, err = ec2client.RunInstances(context.TODO(), runInstanceInput)
+	}
+	if err = dryrun.Check(err); err != nil {
+		return nil, fmt.Errorf("dry run: launching the instance would fail: %w", err)
+	}
+
+	fmt.Fprintf(options.messages(), "Dry run: a new instance would be launched, with the following attributes:\n")
+	fmt.Fprintf(options.messages(), " - name: %s\n", result.Name)
+	fmt.Fprintf(options.messages(), " - access key: %s\n", result.KeyName)
+	fmt.Fprintf(options.messages(), " - type, AMI: %s, %s\n", result.InstanceType, result.AMI)
+	fmt.Fprintf(options.messages(), " - security group: %s\n", result.SecurityGroup)
+	result.DryRun = true
+	return result, nil
 }
 
 /*
@@ -209,8 +388,8 @@ so we're polling until the instance has an IP.
 It might also take a couple seconds/minutes after that to be able to reach the IP.
 The IP can be used to log in to the instance.
 */
-func GetPublicIP(ec2client *ec2.Client, instanceId string) (string, error) {
-	fmt.Printf("Waiting for the instance %s's public IP...\n", instanceId)
+func GetPublicIP(ec2client *ec2.Client, instanceId string, progress io.Writer) (string, error) {
+	fmt.Fprintf(progress, "Waiting for the instance %s's public IP...\n", instanceId)
 
 	total_wait := 120  // 2 min wait, arbitrary.
 	wait_interval := 1 // 1 second wait between each try.
@@ -230,24 +409,35 @@ func GetPublicIP(ec2client *ec2.Client, instanceId string) (string, error) {
 		describeInstanceInput := &ec2.DescribeInstancesInput{
 			InstanceIds: []string{instanceId},
 		}
-		describeInstanceOutput, err := ec2client.DescribeInstances(context.TODO(), describeInstanceInput)
+		var found *types.Instance
+		err := describeEC2.EachInstance(ec2client, describeInstanceInput, func(instance types.Instance) error {
+			found = &instance
+			return describeEC2.StopIteration
+		})
 
 		if err != nil {
 			return "", fmt.Errorf("failed to fetch info on instance of ID %s: %w", instanceId, err)
 		}
 		// if AWS sent empty results:
-		if len(describeInstanceOutput.Reservations) == 0 {
-			return "", fmt.Errorf("couldn't find instance of instance ID %s", instanceId)
-		} else if len(describeInstanceOutput.Reservations[0].Instances) == 0 {
+		if found == nil {
 			return "", fmt.Errorf("couldn't find instance of instance ID %s", instanceId)
 		}
 
 		// checks if field "PublicIpAddress" is empty.
 		// if not empty, it means the instance has a public IP and we can finish here.
-		if describeInstanceOutput.Reservations[0].Instances[0].PublicIpAddress != nil {
-			publicIp := *describeInstanceOutput.Reservations[0].Instances[0].PublicIpAddress
-			fmt.Printf("Public IP successfully retrieved: %s\n", publicIp)
+		if found.PublicIpAddress != nil {
+			publicIp := *found.PublicIpAddress
+			fmt.Fprintf(progress, "Public IP successfully retrieved: %s\n", publicIp)
 			return publicIp, nil
 		}
 	}
 }
+
+// Retrieves the public IPs of multiple instances (see GetPublicIP),
+// waiting for options.Concurrency instances at the same time.
+// The result of each instance is its public IP, or the error of GetPublicIP.
+func GetPublicIPs(ec2client *ec2.Client, instanceIds []string, options fleet.Options, progress io.Writer) ([]fleet.Result[string, string], error) {
+	return fleet.Run(instanceIds, options, func(instanceId string) (string, error) {
+		return GetPublicIP(ec2client, instanceId, progress)
+	})
+}
@@ -0,0 +1,255 @@
+package lifecycleEC2
+
+import (
+	"aws/pkg/confirm"
+	"aws/pkg/deleteEC2"
+	"aws/pkg/describeEC2"
+	"aws/pkg/dryrun"
+	"aws/pkg/fleet"
+	"aws/pkg/report"
+	"aws/pkg/selector"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Operations on the state of the instances.
+const (
+	ActionStop   = "stop"
+	ActionStart  = "start"
+	ActionReboot = "reboot"
+)
+
+// Default maximum time waited for the instances to be stopped or running.
+const DefaultWaitTimeout = 10 * time.Minute
+
+// Options of the operations on multiple instances. The zero value uses the defaults.
+type Options struct {
+	// Stop only: forces the instances to stop, without flushing the file system
+	// caches (for instances stuck in the stopping state)
+	Force bool
+	// Stop only: hibernates the instances (their memory is saved on their volume);
+	// the instances must have been launched with hibernation enabled
+	Hibernate bool
+	// if true, waits until the instances are stopped or running (at most WaitTimeout,
+	// default: DefaultWaitTimeout), and records their final state in the result
+	Wait        bool
+	WaitTimeout time.Duration
+	// number of requests sent at the same time (one request per instance)
+	Fleet fleet.Options
+	// safety checks of the operations on selected instances (StopSelected,
+	// RebootSelected): protection tag, policy, threshold and confirmation
+	Guardrails deleteEC2.Guardrails
+	// if true, the requests are sent with DryRun=true: AWS checks the permissions
+	// without changing the instances, and the changes that would be done are printed
+	// (see package dryrun)
+	DryRun bool
+	// writer where the progress messages are printed (default: os.Stdout; set it
+	// to os.Stderr to keep stdout for the results, or to io.Discard)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// Result of an operation on multiple instances (see package report).
+type Result struct {
+	report.Result `yaml:",inline"`
+	// selected instances kept by the guardrails (see StopSelected)
+	Skipped []deleteEC2.SkippedInstance `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+}
+
+// Result of an operation on one instance.
+type InstanceResult = report.InstanceResult
+
+// Error of the operation on one instance.
+type InstanceError = report.InstanceError
+
+// Error returned when the operation failed on some instances.
+type Error = report.Error
+
+// Stops the instances of IDs given in parameter (see Options.Force and Options.Hibernate).
+// The result lists what happened to each instance, and is returned even if some
+// instances couldn't be stopped (along with an *Error).
+func Stop(ec2client *ec2.Client, instanceIds []string, options Options) (*Result, error) {
+	return run(ec2client, ActionStop, instanceIds, options, func(instanceId string) (*types.InstanceStateChange, error) {
+		output, err := ec2client.StopInstances(context.TODO(), &ec2.StopInstancesInput{
+			InstanceIds: []string{instanceId},
+			Force:       &options.Force,
+			Hibernate:   &options.Hibernate,
+			DryRun:      &options.DryRun,
+		})
+		if err != nil || len(output.StoppingInstances) == 0 {
+			return nil, err
+		}
+		return &output.StoppingInstances[0], nil
+	})
+}
+
+// Starts the instances of IDs given in parameter.
+// The result lists what happened to each instance, and is returned even if some
+// instances couldn't be started (along with an *Error).
+func Start(ec2client *ec2.Client, instanceIds []string, options Options) (*Result, error) {
+	return run(ec2client, ActionStart, instanceIds, options, func(instanceId string) (*types.InstanceStateChange, error) {
+		output, err := ec2client.StartInstances(context.TODO(), &ec2.StartInstancesInput{
+			InstanceIds: []string{instanceId},
+			DryRun:      &options.DryRun,
+		})
+		if err != nil || len(output.StartingInstances) == 0 {
+			return nil, err
+		}
+		return &output.StartingInstances[0], nil
+	})
+}
+
+// Reboots the instances of IDs given in parameter (the reboot request is asynchronous:
+// AWS doesn't give the state of the instances).
+// The result lists what happened to each instance, and is returned even if some
+// instances couldn't be rebooted (along with an *Error).
+func Reboot(ec2client *ec2.Client, instanceIds []string, options Options) (*Result, error) {
+	return run(ec2client, ActionReboot, instanceIds, options, func(instanceId string) (*types.InstanceStateChange, error) {
+		_, err := ec2client.RebootInstances(context.TODO(), &ec2.RebootInstancesInput{
+			InstanceIds: []string{instanceId},
+			DryRun:      &options.DryRun,
+		})
+		return nil, err
+	})
+}
+
+// Stops the instances selected by the selector (ex: "tag:env=dev,name~web-*", see
+// package selector), after applying the guardrails of options.Guardrails like
+// deleteEC2.DeleteSelected: the instances protected by tag or by the policy are
+// kept, the instances to stop are printed, and the confirmer is asked to confirm
+// by typing their number (or the account ID).
+// If the action is aborted, the result is nil.
+func StopSelected(ec2client *ec2.Client, sel *selector.Selector, confirmer confirm.Confirmer, options Options) (*Result, error) {
+	return runGuarded(ec2client, ActionStop, sel, confirmer, confirm.ActionStopSelected, options, Stop)
+}
+
+// Reboots the instances selected by the selector, with the same guardrails
+// and confirmation as StopSelected.
+func RebootSelected(ec2client *ec2.Client, sel *selector.Selector, confirmer confirm.Confirmer, options Options) (*Result, error) {
+	return runGuarded(ec2client, ActionReboot, sel, confirmer, confirm.ActionRebootSelected, options, Reboot)
+}
+
+// Runs the operation on the non-terminated instances selected by sel, after
+// applying the guardrails and asking the confirmer to confirm (confirmAction
+// is the action given to the confirmer).
+func runGuarded(ec2client *ec2.Client, action string, sel *selector.Selector, confirmer confirm.Confirmer, confirmAction string, options Options, operation func(*ec2.Client, []string, Options) (*Result, error)) (*Result, error) {
+	var instances []types.Instance
+	err := describeEC2.EachInstance(ec2client, &ec2.DescribeInstancesInput{Filters: sel.Filters}, func(instance types.Instance) error {
+		if sel.Match(instance) && !describeEC2.IsTerminated(instance) {
+			instances = append(instances, instance)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching info on instances failed: %w", err)
+	}
+
+	// keeps the instances protected by tag or by the policy, and previews the operation
+	guardrails := options.Guardrails
+	selected, skipped, err := guardrails.Filter(instances)
+	if err != nil {
+		return nil, err
+	}
+	if len(selected) == 0 {
+		deleteEC2.PrintPreview(options.messages(), action, selected, skipped)
+		fmt.Fprintln(options.messages(), "No instance found.")
+		return &Result{Result: *report.New(action), Skipped: skipped}, nil
+	}
+	if err := guardrails.CheckActionThreshold(action, len(selected)); err != nil {
+		return nil, err
+	}
+	deleteEC2.PrintPreview(options.messages(), action, selected, skipped)
+
+	// asks for confirmation, by typing the number of instances or the account ID
+	if options.DryRun {
+		confirmer = confirm.AutoYes{}
+	}
+	expected, err := guardrails.ExpectedAnswer(ec2client, len(selected))
+	if err != nil {
+		return nil, err
+	}
+	message := fmt.Sprintf("You asked to %s the EC2 instances selected by %q.", action, sel)
+	proceed, err := confirm.Typed(confirmer, confirmAction, message, expected)
+	if err != nil {
+		return nil, err
+	}
+	if !proceed {
+		fmt.Fprintln(options.messages(), "Action aborted.")
+		return nil, nil
+	}
+
+	instanceIDs := make([]string, len(selected))
+	for i, instance := range selected {
+		instanceIDs[i] = *instance.InstanceId
+	}
+	result, err := operation(ec2client, instanceIDs, options)
+	result.Skipped = skipped
+	return result, err
+}
+
+// Runs the request of the action on each instance (options.Fleet.Concurrency
+// requests at the same time), and waits for the instances if options.Wait is set.
+func run(ec2client *ec2.Client, action string, instanceIds []string, options Options, request func(instanceId string) (*types.InstanceStateChange, error)) (*Result, error) {
+	results, _ := fleet.Run(instanceIds, options.Fleet, request)
+
+	result := &Result{Result: *report.New(action)}
+	for _, r := range results {
+		switch {
+		case options.DryRun && dryrun.Succeeded(r.Err):
+			result.AddDryRun(r.Item)
+			fmt.Fprintf(options.messages(), "Dry run: instance %s would be %s.\n", r.Item, report.PastTense(action))
+		case r.Err != nil:
+			fmt.Fprintln(options.messages(), result.AddError(r.Item, r.Err))
+		default:
+			result.AddDone(r.Item, r.Value)
+			fmt.Fprintf(options.messages(), "Instance %s %s.\n", r.Item, report.PastTense(action))
+		}
+	}
+
+	waitErr := wait(ec2client, result, options)
+	fmt.Fprintln(options.messages(), result.Summary(options.DryRun))
+	if result.Failed > 0 {
+		return result, errors.Join(result.Err(), waitErr)
+	}
+	return result, waitErr
+}
+
+// If options.Wait is set, waits until the instances on which the action was done
+// are stopped (stop) or running (start, reboot), and records their final state.
+func wait(ec2client *ec2.Client, r *Result, options Options) error {
+	if !options.Wait || options.DryRun {
+		return nil
+	}
+	state := types.InstanceStateNameRunning
+	if r.Action == ActionStop {
+		state = types.InstanceStateNameStopped
+	}
+	states, err := WaitState(ec2client, r.IDs(), state, options.WaitTimeout, options.messages())
+	r.SetFinalStates(states)
+	return err
+}
+
+// Waits until the instances of IDs given in parameter are in the state given in
+// parameter (ex: types.InstanceStateNameStopped), printing the progress in progress, for at most
+// timeout (DefaultWaitTimeout if timeout <= 0). Returns the last state of each instance,
+// along with an error if some instances aren't in the state before the timeout.
+func WaitState(ec2client *ec2.Client, instanceIds []string, state types.InstanceStateName, timeout time.Duration, progress io.Writer) (map[string]string, error) {
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	return describeEC2.WaitState(ec2client, instanceIds, state, timeout, progress)
+}
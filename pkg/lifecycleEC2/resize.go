@@ -0,0 +1,375 @@
+package lifecycleEC2
+
+import (
+	"aws/pkg/describeEC2"
+	"aws/pkg/dryrun"
+	"aws/pkg/report"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// Operation changing the type of an instance (see Resize).
+const ActionResize = "resize"
+
+// Error codes of a start failing because AWS has no capacity for the instance type
+// (also the codes of the state reason of an instance stopped for this reason, after
+// the prefix "Server."): the instance is resized back to its previous type.
+var capacityCodes = map[string]bool{
+	"InsufficientInstanceCapacity":         true,
+	"InsufficientHostCapacity":             true,
+	"InsufficientReservedInstanceCapacity": true,
+	"InsufficientCapacity":                 true,
+	"Unsupported":                          true, // the type isn't supported in the availability zone (anymore)
+}
+
+// Options of Resize. The zero value uses the defaults.
+type ResizeOptions struct {
+	// maximum time waited for the instance to be stopped, then running, and then
+	// to pass its status checks (each, default: DefaultWaitTimeout)
+	WaitTimeout time.Duration
+	// if true, doesn't wait for the status checks after the start (only for the running state)
+	NoStatusChecks bool
+	// if true, the requests are only sent with DryRun=true, to check the permissions
+	DryRun bool
+	// writer where the progress messages are printed (default: os.Stdout; set it
+	// to os.Stderr to keep stdout for the results, or to io.Discard)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o ResizeOptions) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// Result of the resize of an instance.
+type ResizeResult struct {
+	InstanceID   string `json:"instance_id" yaml:"instance_id"`
+	PreviousType string `json:"previous_type" yaml:"previous_type"`
+	NewType      string `json:"new_type" yaml:"new_type"`           // type requested
+	InstanceType string `json:"instance_type" yaml:"instance_type"` // type of the instance at the end (PreviousType if rolled back)
+	RolledBack   bool   `json:"rolled_back,omitempty" yaml:"rolled_back,omitempty"`
+	State        string `json:"state" yaml:"state"`                         // state of the instance at the end
+	DryRun       bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"` // true if the instance would have been resized
+	Error        string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func (r *ResizeResult) Table() ([]string, [][]string) {
+	status := "resized"
+	switch {
+	case r.DryRun:
+		status = "dry run"
+	case r.RolledBack:
+		status = "rolled back"
+	case r.Error != "":
+		status = "failed"
+	case r.PreviousType == r.NewType:
+		status = "unchanged"
+	}
+	return []string{"INSTANCE ID", "PREVIOUS TYPE", "NEW TYPE", "INSTANCE TYPE", "STATE", "STATUS", "ERROR"},
+		[][]string{{r.InstanceID, r.PreviousType, r.NewType, r.InstanceType, r.State, status, r.Error}}
+}
+
+func (r *ResizeResult) IDs() []string {
+	return []string{r.InstanceID}
+}
+
+// Changes the type of the instance of ID given in parameter to instanceType.
+// The new type is first checked against the architecture and the virtualization
+// type of the AMI of the instance, its ENA support, and the offering of its
+// availability zone. A running instance is then stopped, modified, started again,
+// and waited for until it passes its status checks (a stopped instance stays stopped).
+// If the start fails because AWS has no capacity for the new type (the start is
+// rejected, or the instance goes back to stopped with the state reason
+// Server.InsufficientInstanceCapacity), the instance is resized back to its previous
+// type and started again (the result is RolledBack, and an error is returned).
+// The result is returned even if the resize failed, with the state of the instance.
+func Resize(ec2client *ec2.Client, instanceId string, instanceType string, options ResizeOptions) (*ResizeResult, error) {
+	result := &ResizeResult{InstanceID: instanceId, NewType: instanceType}
+	output, err := ec2client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{InstanceIds: []string{instanceId}})
+	if err != nil {
+		return result, report.NewInstanceError(ActionResize, instanceId, err)
+	}
+	if len(output.Reservations) == 0 || len(output.Reservations[0].Instances) == 0 {
+		return result, fmt.Errorf("instance %s not found", instanceId)
+	}
+	instance := output.Reservations[0].Instances[0]
+	result.PreviousType = string(instance.InstanceType)
+	result.InstanceType = result.PreviousType
+	result.State = string(instance.State.Name)
+	fail := func(err error) (*ResizeResult, error) {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	if result.PreviousType == instanceType {
+		fmt.Fprintf(options.messages(), "Instance %s is already of type %s.\n", instanceId, instanceType)
+		return result, nil
+	}
+	running := instance.State.Name == types.InstanceStateNameRunning
+	if !running && instance.State.Name != types.InstanceStateNameStopped {
+		return fail(fmt.Errorf("instance %s is %s: it must be running or stopped to be resized", instanceId, instance.State.Name))
+	}
+	if err := checkType(ec2client, instance, instanceType); err != nil {
+		return fail(err)
+	}
+
+	if options.DryRun {
+		return resizeDryRun(ec2client, result, running, options)
+	}
+
+	if running {
+		fmt.Fprintf(options.messages(), "Stopping instance %s...\n", instanceId)
+		if _, err := ec2client.StopInstances(context.TODO(), &ec2.StopInstancesInput{InstanceIds: []string{instanceId}}); err != nil {
+			return fail(report.NewInstanceError(ActionStop, instanceId, err))
+		}
+		states, err := WaitState(ec2client, []string{instanceId}, types.InstanceStateNameStopped, options.WaitTimeout, options.messages())
+		result.State = states[instanceId]
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := modifyType(ec2client, instanceId, instanceType, false); err != nil {
+		return fail(report.NewInstanceError(ActionResize, instanceId, err))
+	}
+	result.InstanceType = instanceType
+	fmt.Fprintf(options.messages(), "Instance %s resized from %s to %s.\n", instanceId, result.PreviousType, instanceType)
+	if !running {
+		return result, nil
+	}
+
+	state, startErr := startRunning(ec2client, instanceId, options)
+	result.State = state
+	if code := capacityCode(startErr); code != "" {
+		// no capacity for the new type: back to the previous one
+		fmt.Fprintf(options.messages(), "No capacity for %s (%s): resizing instance %s back to %s...\n", instanceType, code, instanceId, result.PreviousType)
+		if err := modifyType(ec2client, instanceId, result.PreviousType, false); err != nil {
+			return fail(fmt.Errorf("couldn't start instance %s with type %s (%w), and couldn't resize it back to %s: %w", instanceId, instanceType, startErr, result.PreviousType, err))
+		}
+		result.InstanceType = result.PreviousType
+		result.RolledBack = true
+		state, err := startRunning(ec2client, instanceId, options)
+		result.State = state
+		if err != nil {
+			return fail(fmt.Errorf("couldn't start instance %s with type %s (%w), and couldn't start it again with type %s: %w", instanceId, instanceType, startErr, result.PreviousType, err))
+		}
+		startErr = fmt.Errorf("couldn't start instance %s with type %s, it was resized back to %s: %w", instanceId, instanceType, result.PreviousType, startErr)
+	} else if startErr != nil {
+		return fail(startErr)
+	}
+
+	if err := waitStatusChecks(ec2client, instanceId, options); err != nil {
+		startErr = errors.Join(startErr, err)
+	}
+	if startErr != nil {
+		return fail(startErr)
+	}
+	return result, nil
+}
+
+// Error of a start accepted by AWS, after which the instance went back to stopped
+// (ex: AWS had no capacity for its type, which may only be found once it's pending).
+type stoppedError struct {
+	instanceId string
+	reason     string // code of the state reason (ex: Server.InsufficientInstanceCapacity)
+	message    string // message of the state reason
+}
+
+func (e *stoppedError) Error() string {
+	return fmt.Sprintf("instance %s stopped while starting (%s): %s", e.instanceId, e.reason, e.message)
+}
+
+// Returns the error code of err if it means that AWS has no capacity for the
+// instance type (a rejected start, or an instance stopped while starting), else "".
+func capacityCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && capacityCodes[apiErr.ErrorCode()] {
+		return apiErr.ErrorCode()
+	}
+	var stopped *stoppedError
+	if errors.As(err, &stopped) && capacityCodes[strings.TrimPrefix(stopped.reason, "Server.")] {
+		return stopped.reason
+	}
+	return ""
+}
+
+// Returns an error if the instance can't have the type given in parameter.
+func checkType(ec2client *ec2.Client, instance types.Instance, instanceType string) error {
+	described, err := ec2client.DescribeInstanceTypes(context.TODO(), &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{types.InstanceType(instanceType)},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid instance type %s: %w", instanceType, err)
+	}
+	if len(described.InstanceTypes) == 0 {
+		return fmt.Errorf("unknown instance type %s", instanceType)
+	}
+	info := described.InstanceTypes[0]
+
+	// the architecture of the AMI (the one of the instance, if the AMI was deregistered)
+	architecture := string(instance.Architecture)
+	if instance.ImageId != nil {
+		images, err := ec2client.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{ImageIds: []string{*instance.ImageId}})
+		if err == nil && len(images.Images) > 0 {
+			architecture = string(images.Images[0].Architecture)
+		}
+	}
+	if info.ProcessorInfo != nil && !slices.Contains(info.ProcessorInfo.SupportedArchitectures, types.ArchitectureType(architecture)) {
+		return fmt.Errorf("instance type %s doesn't support the architecture %s of the AMI of instance %s (supported: %v)", instanceType, architecture, *instance.InstanceId, info.ProcessorInfo.SupportedArchitectures)
+	}
+	if instance.VirtualizationType != "" && !slices.Contains(info.SupportedVirtualizationTypes, types.VirtualizationType(instance.VirtualizationType)) {
+		return fmt.Errorf("instance type %s doesn't support the %s virtualization of instance %s", instanceType, instance.VirtualizationType, *instance.InstanceId)
+	}
+	if info.NetworkInfo != nil && info.NetworkInfo.EnaSupport == types.EnaSupportRequired && (instance.EnaSupport == nil || !*instance.EnaSupport) {
+		return fmt.Errorf("instance type %s requires ENA, which isn't enabled on instance %s", instanceType, *instance.InstanceId)
+	}
+
+	// the type must be offered in the availability zone of the instance
+	if instance.Placement == nil || instance.Placement.AvailabilityZone == nil {
+		return nil
+	}
+	zone := *instance.Placement.AvailabilityZone
+	zoneFilter, typeFilter := "location", "instance-type"
+	offerings, err := ec2client.DescribeInstanceTypeOfferings(context.TODO(), &ec2.DescribeInstanceTypeOfferingsInput{
+		LocationType: types.LocationTypeAvailabilityZone,
+		Filters: []types.Filter{
+			{Name: &zoneFilter, Values: []string{zone}},
+			{Name: &typeFilter, Values: []string{instanceType}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't check the offering of %s in %s: %w", instanceType, zone, err)
+	}
+	if len(offerings.InstanceTypeOfferings) == 0 {
+		return fmt.Errorf("instance type %s isn't offered in the availability zone %s of instance %s", instanceType, zone, *instance.InstanceId)
+	}
+	return nil
+}
+
+// Changes the type of the stopped instance (or only checks the permissions, if dryRun).
+func modifyType(ec2client *ec2.Client, instanceId string, instanceType string, dryRun bool) error {
+	_, err := ec2client.ModifyInstanceAttribute(context.TODO(), &ec2.ModifyInstanceAttributeInput{
+		InstanceId:   &instanceId,
+		InstanceType: &types.AttributeValue{Value: &instanceType},
+		DryRun:       &dryRun,
+	})
+	return err
+}
+
+// Starts the stopped instance, and waits until it's running (for at most options.WaitTimeout).
+// Returns the last state of the instance, along with an error if it isn't running:
+// a *stoppedError if AWS accepted the start, but the instance went back to stopped.
+func startRunning(ec2client *ec2.Client, instanceId string, options ResizeOptions) (string, error) {
+	fmt.Fprintf(options.messages(), "Starting instance %s...\n", instanceId)
+	_, err := ec2client.StartInstances(context.TODO(), &ec2.StartInstancesInput{InstanceIds: []string{instanceId}})
+	if err != nil {
+		return string(types.InstanceStateNameStopped), report.NewInstanceError(ActionStart, instanceId, err)
+	}
+	timeout := options.WaitTimeout
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	fmt.Fprintf(options.messages(), "Waiting for instance %s to be running...\n", instanceId)
+	begin := time.Now()
+	state := string(types.InstanceStateNamePending)
+	pending := false
+	for {
+		output, err := ec2client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{InstanceIds: []string{instanceId}})
+		if err != nil {
+			return state, fmt.Errorf("failed to fetch the state of instance %s: %w", instanceId, err)
+		}
+		if len(output.Reservations) > 0 && len(output.Reservations[0].Instances) > 0 {
+			instance := output.Reservations[0].Instances[0]
+			state = string(instance.State.Name)
+			switch instance.State.Name {
+			case types.InstanceStateNameRunning:
+				fmt.Fprintf(options.messages(), "Instance %s running (%s).\n", instanceId, time.Since(begin).Round(time.Second))
+				return state, nil
+			case types.InstanceStateNamePending:
+				pending = true
+			case types.InstanceStateNameStopped:
+				stopped := &stoppedError{instanceId: instanceId}
+				if instance.StateReason != nil {
+					stopped.reason, stopped.message = deref(instance.StateReason.Code), deref(instance.StateReason.Message)
+				}
+				// right after the start, the instance may still be described as stopped
+				// by the stop of the resize
+				if pending || stopped.reason != "Client.UserInitiatedShutdown" {
+					return state, stopped
+				}
+			case types.InstanceStateNameShuttingDown, types.InstanceStateNameTerminated:
+				return state, fmt.Errorf("instance %s is %s", instanceId, state)
+			}
+		}
+		if time.Since(begin)+describeEC2.PollInterval > timeout {
+			return state, fmt.Errorf("instance %s not running after %s (state: %s)", instanceId, timeout, state)
+		}
+		time.Sleep(describeEC2.PollInterval)
+	}
+}
+
+// Waits until the running instance passes its status checks (unless options.NoStatusChecks).
+func waitStatusChecks(ec2client *ec2.Client, instanceId string, options ResizeOptions) error {
+	if options.NoStatusChecks {
+		return nil
+	}
+	timeout := options.WaitTimeout
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	fmt.Fprintf(options.messages(), "Waiting for instance %s to pass its status checks...\n", instanceId)
+	waiter := ec2.NewInstanceStatusOkWaiter(ec2client)
+	if err := waiter.Wait(context.TODO(), &ec2.DescribeInstanceStatusInput{InstanceIds: []string{instanceId}}, timeout); err != nil {
+		return fmt.Errorf("instance %s didn't pass its status checks: %w", instanceId, err)
+	}
+	fmt.Fprintf(options.messages(), "Instance %s passed its status checks.\n", instanceId)
+	return nil
+}
+
+// Sends the requests of the resize with DryRun=true (AWS only checks the permissions).
+func resizeDryRun(ec2client *ec2.Client, result *ResizeResult, running bool, options ResizeOptions) (*ResizeResult, error) {
+	id := result.InstanceID
+	dryRun := true
+	var errs []error
+	if running {
+		_, err := ec2client.StopInstances(context.TODO(), &ec2.StopInstancesInput{InstanceIds: []string{id}, DryRun: &dryRun})
+		errs = append(errs, err)
+	}
+	errs = append(errs, modifyType(ec2client, id, result.NewType, dryRun))
+	if running {
+		_, err := ec2client.StartInstances(context.TODO(), &ec2.StartInstancesInput{InstanceIds: []string{id}, DryRun: &dryRun})
+		errs = append(errs, err)
+	}
+	for _, err := range errs {
+		// a stopped instance can't be modified while running, and the opposite: only the permissions matter
+		var apiErr smithy.APIError
+		if err != nil && !dryrun.Succeeded(err) && !(errors.As(err, &apiErr) && apiErr.ErrorCode() == "IncorrectInstanceState") {
+			result.Error = err.Error()
+			return result, report.NewInstanceError(ActionResize, id, err)
+		}
+	}
+	result.DryRun = true
+	fmt.Fprintf(options.messages(), "Dry run: instance %s would be resized from %s to %s.\n", id, result.PreviousType, result.NewType)
+	return result, nil
+}
+
+// Returns the string pointed by s, or "" if s is nil.
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
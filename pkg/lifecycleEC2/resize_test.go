@@ -0,0 +1,34 @@
+package lifecycleEC2
+
+import (
+	"aws/pkg/report"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestCapacityCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{nil, ""},
+		{errors.New("connection reset"), ""},
+		// start rejected by AWS
+		{report.NewInstanceError(ActionStart, "i-1", &smithy.GenericAPIError{Code: "InsufficientInstanceCapacity"}), "InsufficientInstanceCapacity"},
+		{report.NewInstanceError(ActionStart, "i-1", &smithy.GenericAPIError{Code: "Unsupported"}), "Unsupported"},
+		{report.NewInstanceError(ActionStart, "i-1", &smithy.GenericAPIError{Code: "IncorrectInstanceState"}), ""},
+		// start accepted, and then the instance went back to stopped
+		{&stoppedError{instanceId: "i-1", reason: "Server.InsufficientInstanceCapacity"}, "Server.InsufficientInstanceCapacity"},
+		{fmt.Errorf("resize: %w", &stoppedError{instanceId: "i-1", reason: "Server.InsufficientInstanceCapacity"}), "Server.InsufficientInstanceCapacity"},
+		{&stoppedError{instanceId: "i-1", reason: "Server.InternalError"}, ""},
+		{&stoppedError{instanceId: "i-1", reason: "Client.VolumeLimitExceeded"}, ""},
+	}
+	for _, test := range tests {
+		if code := capacityCode(test.err); code != test.code {
+			t.Errorf("capacityCode(%v) = %q, expected %q", test.err, code, test.code)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format in which the results are printed.
+type Format string
+
+const (
+	Table Format = "table" // human readable table
+	JSON  Format = "json"
+	YAML  Format = "yaml"
+	IDs   Format = "ids" // only the resource IDs, one per line
+)
+
+// All the supported formats.
+var Formats = []Format{Table, JSON, YAML, IDs}
+
+// Returns the format of name given in parameter.
+func ParseFormat(name string) (Format, error) {
+	for _, format := range Formats {
+		if string(format) == name {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("invalid output format %q (expected one of: %s)", name, FormatNames())
+}
+
+// Returns the names of the supported formats, separated by commas.
+func FormatNames() string {
+	names := make([]string, len(Formats))
+	for i, format := range Formats {
+		names[i] = string(format)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Returns true if the format is meant to be read by other programs
+// (ie, nothing else than the result should be printed on stdout).
+func (f Format) Machine() bool {
+	return f != Table
+}
+
+// Implemented by the results that can be printed as a table.
+type Tabler interface {
+	Table() (header []string, rows [][]string)
+}
+
+// Implemented by the results that can be printed as a list of IDs.
+type IDer interface {
+	IDs() []string
+}
+
+// Prints the result in the format given in parameter.
+// JSON and YAML print the result struct (with its json/yaml tags).
+// Table and IDs need the result to implement Tabler and IDer:
+// results that don't implement Tabler are printed in YAML instead.
+func Print(w io.Writer, format Format, result any) error {
+	switch format {
+	case JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case YAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case IDs:
+		ider, ok := result.(IDer)
+		if !ok {
+			return fmt.Errorf("this result can't be printed as a list of IDs")
+		}
+		for _, id := range ider.IDs() {
+			fmt.Fprintln(w, id)
+		}
+		return nil
+	default:
+		tabler, ok := result.(Tabler)
+		if !ok {
+			return Print(w, YAML, result)
+		}
+		header, rows := tabler.Table()
+		PrintTable(w, header, rows)
+		return nil
+	}
+}
+
+// Prints rows aligned in columns, under the header.
+func PrintTable(w io.Writer, header []string, rows [][]string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+// A list of resource IDs, as returned by the Find functions.
+type IDList []string
+
+func (l IDList) Table() ([]string, [][]string) {
+	rows := make([][]string, len(l))
+	for i, id := range l {
+		rows[i] = []string{id}
+	}
+	return []string{"ID"}, rows
+}
+
+func (l IDList) IDs() []string {
+	return l
+}
+
+// MarshalJSON prints an empty list as [] instead of null.
+func (l IDList) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]string(l))
+}
@@ -0,0 +1,76 @@
+package output
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		invalid bool
+	}{
+		{"table", Table, false},
+		{"json", JSON, false},
+		{"yaml", YAML, false},
+		{"ids", IDs, false},
+		{"JSON", "", true},
+		{"", "", true},
+		{"csv", "", true},
+	}
+	for _, test := range tests {
+		format, err := ParseFormat(test.name)
+		if (err != nil) != test.invalid {
+			t.Errorf("ParseFormat(%q) error = %v, expected an error: %v", test.name, err, test.invalid)
+			continue
+		}
+		if format != test.format {
+			t.Errorf("ParseFormat(%q) = %q, expected %q", test.name, format, test.format)
+		}
+	}
+}
+
+// Result that can only be printed in JSON or YAML.
+type plain struct {
+	Name string `json:"name" yaml:"name"`
+}
+
+func TestPrint(t *testing.T) {
+	tests := []struct {
+		format   Format
+		result   any
+		expected string
+		invalid  bool
+	}{
+		{Table, IDList{"i-1", "i-2"}, "ID\ni-1\ni-2\n", false},
+		{IDs, IDList{"i-1", "i-2"}, "i-1\ni-2\n", false},
+		{JSON, IDList{"i-1"}, "[\n  \"i-1\"\n]\n", false},
+		{JSON, IDList(nil), "[]\n", false},
+		{YAML, IDList{"i-1"}, "- i-1\n", false},
+		// results that don't implement Tabler are printed in YAML
+		{Table, plain{"web"}, "name: web\n", false},
+		{JSON, plain{"web"}, "{\n  \"name\": \"web\"\n}\n", false},
+		{IDs, plain{"web"}, "", true},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		err := Print(&out, test.format, test.result)
+		if (err != nil) != test.invalid {
+			t.Errorf("Print(%s, %v) error = %v, expected an error: %v", test.format, test.result, err, test.invalid)
+			continue
+		}
+		if out.String() != test.expected {
+			t.Errorf("Print(%s, %v) = %q, expected %q", test.format, test.result, out.String(), test.expected)
+		}
+	}
+}
+
+func TestPrintTable(t *testing.T) {
+	var out strings.Builder
+	PrintTable(&out, []string{"ID", "STATE"}, [][]string{{"i-123456", "running"}, {"i-1", "stopped"}})
+	expected := "ID        STATE\ni-123456  running\ni-1       stopped\n"
+	if out.String() != expected {
+		t.Errorf("PrintTable() = %q, expected %q", out.String(), expected)
+	}
+}
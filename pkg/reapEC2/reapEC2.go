@@ -0,0 +1,373 @@
+package reapEC2
+
+import (
+	"aws/pkg/deleteEC2"
+	"aws/pkg/describeEC2"
+	"aws/pkg/dryrun"
+	"aws/pkg/expiry"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// What is done to the expired instances.
+const (
+	ActionStop      = "stop"
+	ActionTerminate = "terminate"
+)
+
+// Tag recording the expiry date for which the warning was sent
+// (if the TTL is extended, a new warning is sent before the new expiry date).
+const WarnedTag = "expiry-warned"
+
+// Options of the reaper. The zero value stops the expired instances, without warning.
+type Options struct {
+	Action string // ActionStop (default) or ActionTerminate
+	// URL receiving the warnings (JSON POST, with a "text" field compatible with
+	// Slack/Mattermost webhooks). If set, the instances are only stopped or terminated
+	// once a warning was sent for them, in a previous run.
+	Webhook string
+	// warnings are sent this long before the expiry (default: 1h)
+	WarnBefore time.Duration
+	// instances never stopped or terminated (protection tag, policy; the threshold isn't checked)
+	Guardrails deleteEC2.Guardrails
+	// returns the current time (default: time.Now)
+	Now func() time.Time
+	// if true, the requests changing the instances are sent with DryRun=true,
+	// and no warning is sent (see package dryrun)
+	DryRun bool
+	// writer where the progress messages are printed (default: os.Stdout; set it
+	// to os.Stderr to keep stdout for the results, or to io.Discard)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// What the reaper did to one instance.
+type InstanceResult struct {
+	InstanceID string `json:"instance_id" yaml:"instance_id"`
+	Name       string `json:"name,omitempty" yaml:"name,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	Action     string `json:"action" yaml:"action"`                       // warned, stopped, terminated, extended, kept
+	DryRun     bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"` // true if the action would have been done
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`     // why the action failed, or why the instance is kept
+}
+
+// Result of Reap or Extend.
+type ReapResult struct {
+	Instances []InstanceResult `json:"instances" yaml:"instances"`
+}
+
+func (r *ReapResult) Table() ([]string, [][]string) {
+	rows := make([][]string, len(r.Instances))
+	for i, instance := range r.Instances {
+		action := instance.Action
+		if instance.DryRun {
+			action += " (dry run)"
+		}
+		rows[i] = []string{instance.InstanceID, instance.Name, instance.ExpiresAt, action, instance.Error}
+	}
+	return []string{"INSTANCE ID", "NAME", "EXPIRES AT", "ACTION", "ERROR"}, rows
+}
+
+func (r *ReapResult) IDs() []string {
+	ids := make([]string, len(r.Instances))
+	for i, instance := range r.Instances {
+		ids[i] = instance.InstanceID
+	}
+	return ids
+}
+
+// An instance with an expiry tag.
+type expiring struct {
+	instance  types.Instance
+	result    InstanceResult
+	expiresAt time.Time
+	warned    bool // true if the warning was sent for this expiry date
+}
+
+// Returns the non-terminated instances having an expiry tag (see package expiry).
+// The instances with an invalid tag are recorded in the result.
+func findExpiring(ec2client *ec2.Client, result *ReapResult) ([]expiring, error) {
+	filterName := "tag-key"
+	input := &ec2.DescribeInstancesInput{Filters: []types.Filter{{Name: &filterName, Values: []string{expiry.ExpiresAtTag, expiry.TTLTag}}}}
+	var instances []expiring
+	err := describeEC2.EachInstance(ec2client, input, func(instance types.Instance) error {
+		if describeEC2.IsTerminated(instance) {
+			return nil
+		}
+		e := expiring{instance: instance, result: InstanceResult{InstanceID: *instance.InstanceId, Name: describeEC2.NewInstance(instance).Name}}
+		expiresAt, _, err := expiry.ExpiresAt(instance)
+		if err != nil {
+			e.result.Action = "kept"
+			e.result.Error = err.Error()
+			result.Instances = append(result.Instances, e.result)
+			return nil
+		}
+		e.expiresAt = expiresAt
+		e.result.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+		for _, tag := range instance.Tags {
+			if tag.Key != nil && *tag.Key == WarnedTag && tag.Value != nil && *tag.Value == e.result.ExpiresAt {
+				e.warned = true
+			}
+		}
+		instances = append(instances, e)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching info on expiring instances failed: %w", err)
+	}
+	return instances, nil
+}
+
+// Finds the instances with an expiry tag (see package expiry), and stops or terminates
+// the expired ones (options.Action). If options.Webhook is set, a warning is first sent
+// for the instances expiring within options.WarnBefore, and the expired instances
+// are only stopped or terminated at the next run after their warning.
+// The instances kept by the guardrails are never stopped or terminated.
+// The result lists the instances warned, stopped, terminated or kept (the instances
+// that don't expire yet aren't listed). Run it periodically to reap the instances.
+func Reap(ec2client *ec2.Client, options Options) (*ReapResult, error) {
+	if options.Action == "" {
+		options.Action = ActionStop
+	}
+	if options.Action != ActionStop && options.Action != ActionTerminate {
+		return nil, fmt.Errorf("invalid action %q (expected %s or %s)", options.Action, ActionStop, ActionTerminate)
+	}
+	if options.WarnBefore <= 0 {
+		options.WarnBefore = time.Hour
+	}
+	now := time.Now()
+	if options.Now != nil {
+		now = options.Now()
+	}
+
+	result := &ReapResult{Instances: []InstanceResult{}}
+	instances, err := findExpiring(ec2client, result)
+	if err != nil {
+		return nil, err
+	}
+	// the instances already stopped don't need to be stopped again
+	var due []expiring
+	for _, e := range instances {
+		if now.Add(options.WarnBefore).Before(e.expiresAt) {
+			continue
+		}
+		state := e.instance.State.Name
+		if options.Action == ActionStop && (state == types.InstanceStateNameStopped || state == types.InstanceStateNameStopping) {
+			continue
+		}
+		due = append(due, e)
+	}
+
+	// keeps the instances protected by tag or by the policy
+	var candidates []types.Instance
+	for _, e := range due {
+		candidates = append(candidates, e.instance)
+	}
+	_, skipped, err := options.Guardrails.Filter(candidates)
+	if err != nil {
+		return nil, err
+	}
+	kept := make(map[string]string)
+	for _, s := range skipped {
+		kept[s.InstanceID] = s.Reason
+	}
+
+	var toWarn, toReap []expiring
+	for _, e := range due {
+		if reason, ok := kept[e.result.InstanceID]; ok {
+			e.result.Action = "kept"
+			e.result.Error = reason
+			result.Instances = append(result.Instances, e.result)
+			continue
+		}
+		expired := !now.Before(e.expiresAt)
+		switch {
+		case options.Webhook != "" && !e.warned:
+			toWarn = append(toWarn, e)
+		case expired:
+			toReap = append(toReap, e)
+		}
+	}
+
+	var errs []error
+	if len(toWarn) > 0 {
+		warned, err := warn(ec2client, options, toWarn)
+		result.Instances = append(result.Instances, warned...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(toReap) > 0 {
+		reaped, err := reap(ec2client, options, toReap)
+		result.Instances = append(result.Instances, reaped...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// Message sent to the webhook.
+type warning struct {
+	Text      string           `json:"text"`
+	Action    string           `json:"action"`
+	Instances []InstanceResult `json:"instances"`
+}
+
+// Sends the warning of the instances to the webhook, and tags them as warned.
+func warn(ec2client *ec2.Client, options Options, instances []expiring) ([]InstanceResult, error) {
+	message := warning{
+		Text:   fmt.Sprintf("%d EC2 instances expire soon and will be %s:", len(instances), pastTense(options.Action)),
+		Action: options.Action,
+	}
+	for _, e := range instances {
+		e.result.Action = "warned"
+		e.result.DryRun = options.DryRun
+		message.Text += fmt.Sprintf("\n- %s (%s): expires at %s", e.result.InstanceID, e.result.Name, e.result.ExpiresAt)
+		message.Instances = append(message.Instances, e.result)
+	}
+	if options.DryRun {
+		fmt.Fprintf(options.messages(), "Dry run: a warning would be sent for %d instances.\n", len(instances))
+		return message.Instances, nil
+	}
+
+	if err := post(options.Webhook, message); err != nil {
+		for i := range message.Instances {
+			message.Instances[i].Error = err.Error()
+		}
+		return message.Instances, fmt.Errorf("couldn't send the warning: %w", err)
+	}
+	fmt.Fprintf(options.messages(), "Warning sent for %d instances.\n", len(instances))
+
+	// the warning isn't sent again for the same expiry date
+	var errs []error
+	for i, e := range instances {
+		key, value := WarnedTag, e.result.ExpiresAt
+		_, err := ec2client.CreateTags(context.TODO(), &ec2.CreateTagsInput{
+			Resources: []string{e.result.InstanceID},
+			Tags:      []types.Tag{{Key: &key, Value: &value}},
+		})
+		if err != nil {
+			message.Instances[i].Error = fmt.Sprintf("couldn't record the warning: %s", err)
+			errs = append(errs, fmt.Errorf("instance %s: couldn't record the warning: %w", e.result.InstanceID, err))
+		}
+	}
+	return message.Instances, errors.Join(errs...)
+}
+
+// Posts the message to the webhook, in JSON.
+func post(url string, message any) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return nil
+}
+
+func pastTense(action string) string {
+	if action == ActionTerminate {
+		return "terminated"
+	}
+	return "stopped"
+}
+
+// Stops or terminates the expired instances.
+func reap(ec2client *ec2.Client, options Options, instances []expiring) ([]InstanceResult, error) {
+	action := options.Action
+	var results []InstanceResult
+	var errs []error
+	for _, e := range instances {
+		var err error
+		if action == ActionTerminate {
+			err = deleteEC2.DeleteInstance(ec2client, e.result.InstanceID, deleteEC2.DeleteOptions{DryRun: options.DryRun, Output: options.Output})
+		} else {
+			_, err = ec2client.StopInstances(context.TODO(), &ec2.StopInstancesInput{InstanceIds: []string{e.result.InstanceID}, DryRun: &options.DryRun})
+		}
+		e.result.Action = pastTense(action)
+		switch {
+		case options.DryRun && dryrun.Succeeded(err), options.DryRun && err == nil:
+			e.result.DryRun = true
+			fmt.Fprintf(options.messages(), "Dry run: expired instance %s would be %s.\n", e.result.InstanceID, pastTense(action))
+		case err != nil:
+			e.result.Action = "kept"
+			e.result.Error = err.Error()
+			errs = append(errs, fmt.Errorf("instance %s: %w", e.result.InstanceID, err))
+		default:
+			fmt.Fprintf(options.messages(), "Expired instance %s %s.\n", e.result.InstanceID, pastTense(action))
+		}
+		results = append(results, e.result)
+	}
+	return results, errors.Join(errs...)
+}
+
+// Extends the time to live of the instances of IDs given in parameter: they expire
+// "by" later than their current expiry date (or than now, if they're expired or don't expire).
+// Only options.Now and options.DryRun are used.
+func Extend(ec2client *ec2.Client, instanceIds []string, by time.Duration, options Options) (*ReapResult, error) {
+	now := time.Now()
+	if options.Now != nil {
+		now = options.Now()
+	}
+	result := &ReapResult{Instances: []InstanceResult{}}
+	if len(instanceIds) == 0 {
+		return result, nil
+	}
+	var errs []error
+	err := describeEC2.EachInstance(ec2client, &ec2.DescribeInstancesInput{InstanceIds: instanceIds}, func(instance types.Instance) error {
+		r := InstanceResult{InstanceID: *instance.InstanceId, Name: describeEC2.NewInstance(instance).Name, Action: "extended"}
+		expiresAt, ok, err := expiry.ExpiresAt(instance)
+		if !ok || err != nil || expiresAt.Before(now) {
+			expiresAt = now
+		}
+		expiresAt = expiresAt.Add(by)
+		r.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+		_, err = ec2client.CreateTags(context.TODO(), &ec2.CreateTagsInput{
+			Resources: []string{r.InstanceID},
+			Tags:      []types.Tag{expiry.Tag(expiresAt)},
+			DryRun:    &options.DryRun,
+		})
+		switch {
+		case options.DryRun && dryrun.Succeeded(err):
+			r.DryRun = true
+			fmt.Fprintf(options.messages(), "Dry run: instance %s would expire at %s.\n", r.InstanceID, r.ExpiresAt)
+		case err != nil:
+			r.Action = "kept"
+			r.Error = err.Error()
+			errs = append(errs, fmt.Errorf("instance %s: %w", r.InstanceID, err))
+		default:
+			fmt.Fprintf(options.messages(), "Instance %s now expires at %s.\n", r.InstanceID, r.ExpiresAt)
+		}
+		result.Instances = append(result.Instances, r)
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("fetching info on instances failed: %w", err)
+	}
+	return result, errors.Join(errs...)
+}
@@ -0,0 +1,302 @@
+/*
+Package report records the result of an operation on multiple instances
+(delete, stop, start, reboot...): what happened to each instance, the error of
+the instances on which it failed (with its AWS error code, and whether retrying
+may succeed), and a summary of the failures by error code.
+*/
+package report
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// Result of an operation on multiple instances.
+type Result struct {
+	Action    string           `json:"action" yaml:"action"`
+	Succeeded int              `json:"succeeded" yaml:"succeeded"`
+	Failed    int              `json:"failed" yaml:"failed"`
+	Instances []InstanceResult `json:"instances" yaml:"instances"`
+}
+
+// Result of the operation on one instance.
+type InstanceResult struct {
+	InstanceID    string `json:"instance_id" yaml:"instance_id"`
+	Done          bool   `json:"done" yaml:"done"`
+	PreviousState string `json:"previous_state,omitempty" yaml:"previous_state,omitempty"` // state before the request (ex: running)
+	CurrentState  string `json:"current_state,omitempty" yaml:"current_state,omitempty"`   // state after the request (ex: stopping)
+	FinalState    string `json:"final_state,omitempty" yaml:"final_state,omitempty"`       // state after waiting for the instance
+	DryRun        bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`               // true if it would have been done
+	Error         string `json:"error,omitempty" yaml:"error,omitempty"`                   // why the operation failed
+	ErrorCode     string `json:"error_code,omitempty" yaml:"error_code,omitempty"`         // AWS error code (ex: IncorrectInstanceState)
+	Retryable     bool   `json:"retryable,omitempty" yaml:"retryable,omitempty"`           // true if retrying may succeed
+
+	err *InstanceError
+}
+
+// Error of the operation on one instance.
+type InstanceError struct {
+	InstanceID string
+	Action     string // ex: "delete", "stop"
+	// AWS error code, for example:
+	//  - InvalidInstanceID.NotFound: the instance doesn't exist
+	//  - OperationNotPermitted: the instance has termination protection
+	//  - IncorrectInstanceState: the operation isn't possible in the current state of the instance
+	//  - UnauthorizedOperation: the user isn't allowed to do the operation
+	// empty if the error doesn't come from AWS (ex: network error).
+	Code      string
+	Retryable bool // true if retrying may succeed
+	Err       error
+}
+
+func (e *InstanceError) Error() string {
+	return fmt.Sprintf("couldn't %s instance %s: %s", e.Action, e.InstanceID, e.Err)
+}
+
+func (e *InstanceError) Unwrap() error {
+	return e.Err
+}
+
+// Error codes for which retrying later may succeed.
+var retryableCodes = map[string]bool{
+	"RequestLimitExceeded":                 true,
+	"Throttling":                           true,
+	"InternalError":                        true,
+	"ServiceUnavailable":                   true,
+	"Unavailable":                          true,
+	"IncorrectInstanceState":               true,
+	"InsufficientInstanceCapacity":         true,
+	"InsufficientHostCapacity":             true,
+	"InsufficientReservedInstanceCapacity": true,
+}
+
+// Returns the error of the action on instanceId, with the AWS error code of err.
+func NewInstanceError(action string, instanceId string, err error) *InstanceError {
+	instanceErr := &InstanceError{InstanceID: instanceId, Action: action, Err: err}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		instanceErr.Code = apiErr.ErrorCode()
+		instanceErr.Retryable = retryableCodes[instanceErr.Code]
+	} else {
+		// not an answer of AWS: the request may not have been received
+		instanceErr.Retryable = true
+	}
+	return instanceErr
+}
+
+// Error returned when the operation failed on some instances.
+// The error of each instance can be retrieved with errors.As:
+//
+//	var instanceErr *report.InstanceError
+//	if errors.As(err, &instanceErr) { ... }
+//
+// (which finds the first one), or with the field Errors.
+type Error struct {
+	Action    string
+	Succeeded int
+	Errors    []*InstanceError
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("error on multiple instances: %s succeeded on %d instances, and failed on %d instances", e.Action, e.Succeeded, len(e.Errors))
+}
+
+func (e *Error) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Returns an empty result of the action.
+func New(action string) *Result {
+	return &Result{Action: action, Instances: []InstanceResult{}}
+}
+
+// Returns the error of the result: an *Error if the operation
+// failed on some instances, else nil.
+func (r *Result) Err() error {
+	if r.Failed == 0 {
+		return nil
+	}
+	err := &Error{Action: r.Action, Succeeded: r.Succeeded}
+	for _, instance := range r.Instances {
+		if instance.err != nil {
+			err.Errors = append(err.Errors, instance.err)
+		}
+	}
+	return err
+}
+
+func (r *Result) Table() ([]string, [][]string) {
+	// the final state is only shown if the instances were waited for
+	waited := false
+	for _, instance := range r.Instances {
+		waited = waited || instance.FinalState != ""
+	}
+	rows := make([][]string, len(r.Instances))
+	for i, instance := range r.Instances {
+		done := fmt.Sprint(instance.Done)
+		if instance.DryRun {
+			done = "dry run"
+		}
+		rows[i] = []string{instance.InstanceID, done, instance.PreviousState, instance.CurrentState}
+		if waited {
+			rows[i] = append(rows[i], instance.FinalState)
+		}
+		rows[i] = append(rows[i], instance.ErrorCode, instance.Error)
+	}
+	header := []string{"INSTANCE ID", strings.ToUpper(PastTense(r.Action)), "PREVIOUS STATE", "CURRENT STATE"}
+	if waited {
+		header = append(header, "FINAL STATE")
+	}
+	return append(header, "ERROR CODE", "ERROR"), rows
+}
+
+// Returns the IDs of the instances on which the operation was done
+// (or would have been done, in dry run mode).
+func (r *Result) IDs() []string {
+	var ids []string
+	for _, instance := range r.Instances {
+		if instance.Done || instance.DryRun {
+			ids = append(ids, instance.InstanceID)
+		}
+	}
+	return ids
+}
+
+// Records an instance on which the operation was done, with its state change
+// (nil if AWS doesn't return it, ex: reboot).
+func (r *Result) AddDone(instanceId string, change *types.InstanceStateChange) {
+	result := InstanceResult{InstanceID: instanceId, Done: true}
+	if change != nil {
+		if change.PreviousState != nil {
+			result.PreviousState = string(change.PreviousState.Name)
+		}
+		if change.CurrentState != nil {
+			result.CurrentState = string(change.CurrentState.Name)
+		}
+	}
+	r.Succeeded++
+	r.Instances = append(r.Instances, result)
+}
+
+// Records an instance on which the operation would have been done (dry run).
+func (r *Result) AddDryRun(instanceId string) {
+	r.Succeeded++
+	r.Instances = append(r.Instances, InstanceResult{InstanceID: instanceId, DryRun: true})
+}
+
+// Records the failure of the operation on instanceId, and returns its error.
+func (r *Result) AddError(instanceId string, err error) *InstanceError {
+	instanceErr := NewInstanceError(r.Action, instanceId, err)
+	r.Failed++
+	r.Instances = append(r.Instances, InstanceResult{
+		InstanceID: instanceId,
+		Error:      instanceErr.Error(),
+		ErrorCode:  instanceErr.Code,
+		Retryable:  instanceErr.Retryable,
+		err:        instanceErr,
+	})
+	return instanceErr
+}
+
+// Adds the instances of other to the result.
+func (r *Result) Merge(other *Result) {
+	r.Succeeded += other.Succeeded
+	r.Failed += other.Failed
+	r.Instances = append(r.Instances, other.Instances...)
+}
+
+// Replaces the results of the instances of IDs given in parameter
+// by their results in other (ex: after a retry).
+func (r *Result) Replace(instanceIds []string, other *Result) {
+	replaced := make(map[string]bool)
+	for _, id := range instanceIds {
+		replaced[id] = true
+	}
+	kept := New(r.Action)
+	for _, instance := range r.Instances {
+		if replaced[instance.InstanceID] {
+			continue
+		}
+		kept.Instances = append(kept.Instances, instance)
+		if instance.err != nil {
+			kept.Failed++
+		} else {
+			kept.Succeeded++
+		}
+	}
+	kept.Merge(other)
+	*r = *kept
+}
+
+// Returns the IDs of the instances of the result for which retrying may succeed.
+func (r *Result) Retryable() []string {
+	var ids []string
+	for _, instance := range r.Instances {
+		if instance.Retryable {
+			ids = append(ids, instance.InstanceID)
+		}
+	}
+	return ids
+}
+
+// Records the final state of the instances on which the operation was done
+// (states by instance ID, see describeEC2.WaitState).
+func (r *Result) SetFinalStates(states map[string]string) {
+	for i, instance := range r.Instances {
+		if state, ok := states[instance.InstanceID]; ok && instance.Done {
+			r.Instances[i].FinalState = state
+		}
+	}
+}
+
+// Returns a summary of the result, with the number of failures by error code
+// (ex: "8 instances deleted, 2 failed (OperationNotPermitted: 2)").
+func (r *Result) Summary(dryRun bool) string {
+	verb := PastTense(r.Action)
+	if dryRun {
+		verb = "would be " + verb
+	}
+	summary := fmt.Sprintf("%d instances %s, %d failed", r.Succeeded, verb, r.Failed)
+	if r.Failed == 0 {
+		return summary
+	}
+	codes := make(map[string]int)
+	for _, instance := range r.Instances {
+		if instance.err != nil {
+			code := instance.ErrorCode
+			if code == "" {
+				code = "other error"
+			}
+			codes[code]++
+		}
+	}
+	var counts []string
+	for code, count := range codes {
+		counts = append(counts, fmt.Sprintf("%s: %d", code, count))
+	}
+	sort.Strings(counts)
+	return summary + " (" + strings.Join(counts, ", ") + ")"
+}
+
+// Returns the past tense of the action (ex: "stopped" for "stop").
+func PastTense(action string) string {
+	switch {
+	case action == "":
+		return "done"
+	case strings.HasSuffix(action, "e"):
+		return action + "d"
+	case strings.HasSuffix(action, "p"), strings.HasSuffix(action, "g"):
+		// stop, tag
+		return action + action[len(action)-1:] + "ed"
+	}
+	return action + "ed"
+}
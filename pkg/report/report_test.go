@@ -0,0 +1,85 @@
+package report
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestPastTense(t *testing.T) {
+	for action, expected := range map[string]string{"delete": "deleted", "stop": "stopped", "start": "started", "reboot": "rebooted", "resize": "resized", "tag": "tagged"} {
+		if got := PastTense(action); got != expected {
+			t.Errorf("PastTense(%q) = %q, expected %q", action, got, expected)
+		}
+	}
+}
+
+func TestNewInstanceError(t *testing.T) {
+	tests := []struct {
+		err       error
+		code      string
+		retryable bool
+	}{
+		{&smithy.GenericAPIError{Code: "InvalidInstanceID.NotFound"}, "InvalidInstanceID.NotFound", false},
+		{&smithy.GenericAPIError{Code: "OperationNotPermitted"}, "OperationNotPermitted", false},
+		{&smithy.GenericAPIError{Code: "UnauthorizedOperation"}, "UnauthorizedOperation", false},
+		{&smithy.GenericAPIError{Code: "RequestLimitExceeded"}, "RequestLimitExceeded", true},
+		{&smithy.GenericAPIError{Code: "IncorrectInstanceState"}, "IncorrectInstanceState", true},
+		// not an answer of AWS
+		{errors.New("connection reset by peer"), "", true},
+	}
+	for _, test := range tests {
+		instanceErr := NewInstanceError("delete", "i-1", test.err)
+		if instanceErr.Code != test.code || instanceErr.Retryable != test.retryable {
+			t.Errorf("NewInstanceError(%v) = {Code: %q, Retryable: %v}, expected {Code: %q, Retryable: %v}",
+				test.err, instanceErr.Code, instanceErr.Retryable, test.code, test.retryable)
+		}
+		if !errors.Is(instanceErr, test.err) {
+			t.Errorf("NewInstanceError(%v) doesn't wrap the error", test.err)
+		}
+	}
+
+	instanceErr := NewInstanceError("delete", "i-1", errors.New("no state change returned by AWS"))
+	expected := "couldn't delete instance i-1: no state change returned by AWS"
+	if instanceErr.Error() != expected {
+		t.Errorf("Error() = %q, expected %q", instanceErr.Error(), expected)
+	}
+}
+
+func TestResult(t *testing.T) {
+	result := New("stop")
+	result.AddDone("i-1", nil)
+	result.AddError("i-2", &smithy.GenericAPIError{Code: "IncorrectInstanceState"})
+	result.AddError("i-3", &smithy.GenericAPIError{Code: "UnauthorizedOperation"})
+	result.AddError("i-4", errors.New("connection reset"))
+
+	if ids := result.Retryable(); len(ids) != 2 || ids[0] != "i-2" || ids[1] != "i-4" {
+		t.Errorf("Retryable() = %v, expected [i-2 i-4]", ids)
+	}
+	expected := "1 instances stopped, 3 failed (IncorrectInstanceState: 1, UnauthorizedOperation: 1, other error: 1)"
+	if summary := result.Summary(false); summary != expected {
+		t.Errorf("Summary() = %q, expected %q", summary, expected)
+	}
+
+	retried := New("stop")
+	retried.AddDone("i-2", nil)
+	retried.AddError("i-4", errors.New("connection reset"))
+	result.Replace([]string{"i-2", "i-4"}, retried)
+	if result.Succeeded != 2 || result.Failed != 2 || len(result.Instances) != 4 {
+		t.Errorf("after Replace: %d succeeded, %d failed, %d instances, expected 2, 2, 4", result.Succeeded, result.Failed, len(result.Instances))
+	}
+
+	var errs *Error
+	if err := result.Err(); !errors.As(err, &errs) || len(errs.Errors) != 2 || errs.Succeeded != 2 {
+		t.Fatalf("Err() = %v, expected an *Error with 2 errors", err)
+	}
+	var instanceErr *InstanceError
+	if !errors.As(result.Err(), &instanceErr) || instanceErr.InstanceID != "i-3" || instanceErr.Code != "UnauthorizedOperation" || instanceErr.Retryable {
+		t.Errorf("first instance error = %+v, expected the non-retryable error of i-3", instanceErr)
+	}
+	if msg := instanceErr.Error(); !strings.HasPrefix(msg, "couldn't stop instance i-3: ") {
+		t.Errorf("Error() = %q", msg)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Package retention chooses the backups to keep, with rules such as
+"keep 7 daily, 4 weekly, 6 monthly": the last backup of each of the 7 last days
+having a backup is kept, and the last backup of each of the 4 last weeks,
+and of each of the 6 last months (a backup can be kept by several rules).
+
+The periods are hourly, daily, weekly (ISO weeks, starting on monday), monthly
+and yearly, in UTC.
+*/
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of backups kept for each period.
+type Rules struct {
+	Hourly  int `json:"hourly,omitempty" yaml:"hourly,omitempty"`
+	Daily   int `json:"daily,omitempty" yaml:"daily,omitempty"`
+	Weekly  int `json:"weekly,omitempty" yaml:"weekly,omitempty"`
+	Monthly int `json:"monthly,omitempty" yaml:"monthly,omitempty"`
+	Yearly  int `json:"yearly,omitempty" yaml:"yearly,omitempty"`
+}
+
+// Parses rules such as "keep 7 daily, 4 weekly, 6 monthly" ("keep" is optional).
+func ParseRules(value string) (Rules, error) {
+	var rules Rules
+	text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), "keep"))
+	for _, part := range strings.Split(text, ",") {
+		fields := strings.Fields(part)
+		if len(fields) != 2 {
+			return rules, fmt.Errorf("invalid retention rule %q (expected <count> <period>, ex: 7 daily)", strings.TrimSpace(part))
+		}
+		count, err := strconv.Atoi(fields[0])
+		if err != nil || count < 0 {
+			return rules, fmt.Errorf("invalid count %q in retention rule %q", fields[0], strings.TrimSpace(part))
+		}
+		switch fields[1] {
+		case "hourly":
+			rules.Hourly = count
+		case "daily":
+			rules.Daily = count
+		case "weekly":
+			rules.Weekly = count
+		case "monthly":
+			rules.Monthly = count
+		case "yearly":
+			rules.Yearly = count
+		default:
+			return rules, fmt.Errorf("unknown period %q (expected hourly, daily, weekly, monthly or yearly)", fields[1])
+		}
+	}
+	if rules.Empty() {
+		return rules, fmt.Errorf("invalid retention rules %q: nothing would be kept", value)
+	}
+	return rules, nil
+}
+
+// Returns true if the rules keep nothing.
+func (r Rules) Empty() bool {
+	return r.Hourly+r.Daily+r.Weekly+r.Monthly+r.Yearly == 0
+}
+
+func (r Rules) String() string {
+	var parts []string
+	for _, p := range r.periods() {
+		if p.count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", p.count, p.name))
+		}
+	}
+	return "keep " + strings.Join(parts, ", ")
+}
+
+// A period of the rules: its name, the number of backups kept, and the key
+// of the period of a time (the backups of the same period have the same key).
+type period struct {
+	name  string
+	count int
+	key   func(t time.Time) string
+}
+
+func (r Rules) periods() []period {
+	return []period{
+		{"hourly", r.Hourly, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+		{"daily", r.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", r.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", r.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", r.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+}
+
+// Returns, for each of the times of the backups given in parameter (in any order),
+// the rules keeping it (ex: "daily, weekly"), or "" if the backup can be deleted.
+func (r Rules) Keep(times []time.Time) []string {
+	order := make([]int, len(times))
+	for i := range order {
+		order[i] = i
+	}
+	// most recent first: the last backup of each period is kept
+	sort.SliceStable(order, func(a, b int) bool { return times[order[a]].After(times[order[b]]) })
+
+	reasons := make([][]string, len(times))
+	for _, p := range r.periods() {
+		seen := make(map[string]bool)
+		for _, i := range order {
+			if len(seen) == p.count {
+				break
+			}
+			key := p.key(times[i].UTC())
+			if !seen[key] {
+				seen[key] = true
+				reasons[i] = append(reasons[i], p.name)
+			}
+		}
+	}
+	kept := make([]string, len(times))
+	for i, r := range reasons {
+		kept[i] = strings.Join(r, ", ")
+	}
+	return kept
+}
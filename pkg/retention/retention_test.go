@@ -0,0 +1,100 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		value  string
+		rules  Rules
+		string string
+	}{
+		{"keep 7 daily, 4 weekly, 6 monthly", Rules{Daily: 7, Weekly: 4, Monthly: 6}, "keep 7 daily, 4 weekly, 6 monthly"},
+		{"6 monthly,7 daily", Rules{Daily: 7, Monthly: 6}, "keep 7 daily, 6 monthly"},
+		{"  keep 24 hourly , 1 yearly ", Rules{Hourly: 24, Yearly: 1}, "keep 24 hourly, 1 yearly"},
+		{"keep 7 daily, 0 weekly", Rules{Daily: 7}, "keep 7 daily"},
+	}
+	for _, test := range tests {
+		rules, err := ParseRules(test.value)
+		if err != nil {
+			t.Errorf("ParseRules(%q): %v", test.value, err)
+			continue
+		}
+		if rules != test.rules {
+			t.Errorf("ParseRules(%q) = %+v, expected %+v", test.value, rules, test.rules)
+		}
+		if rules.String() != test.string {
+			t.Errorf("ParseRules(%q).String() = %q, expected %q", test.value, rules.String(), test.string)
+		}
+	}
+	for _, value := range []string{
+		"",
+		"keep",
+		"keep 0 daily",
+		"keep daily",
+		"keep -1 daily",
+		"keep seven daily",
+		"keep 7 days",
+		"keep 7 daily 4 weekly",
+		"keep 7 daily,",
+	} {
+		if _, err := ParseRules(value); err == nil {
+			t.Errorf("ParseRules(%q): expected an error", value)
+		}
+	}
+}
+
+func TestKeep(t *testing.T) {
+	date := func(value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	// given in any order
+	times := []time.Time{
+		date("2024-01-13T10:00:00Z"), // saturday, week 2
+		date("2024-01-15T02:00:00Z"), // monday, week 3 (not the last of its day)
+		date("2023-12-31T10:00:00Z"), // sunday, week 52 of 2023
+		date("2024-01-15T10:00:00Z"), // monday, week 3
+		date("2024-01-12T10:00:00Z"), // friday, week 2
+		date("2024-01-14T10:00:00Z"), // sunday, week 2
+		date("2024-01-07T10:00:00Z"), // sunday, week 1
+	}
+	rules := Rules{Daily: 3, Weekly: 2, Monthly: 2, Yearly: 1}
+	expected := []string{
+		"daily",
+		"",
+		"monthly",
+		"daily, weekly, monthly, yearly",
+		"",
+		"daily, weekly",
+		"",
+	}
+	if kept := rules.Keep(times); !reflect.DeepEqual(kept, expected) {
+		t.Errorf("Keep() = %q, expected %q", kept, expected)
+	}
+}
+
+func TestKeepUTC(t *testing.T) {
+	// both backups are on 2024-01-15 in UTC, but not in this time zone
+	zone := time.FixedZone("UTC+2", 2*3600)
+	times := []time.Time{
+		time.Date(2024, 1, 15, 23, 30, 0, 0, zone),
+		time.Date(2024, 1, 16, 0, 30, 0, 0, zone),
+	}
+	expected := []string{"", "daily"}
+	if kept := (Rules{Daily: 2}).Keep(times); !reflect.DeepEqual(kept, expected) {
+		t.Errorf("Keep() = %q, expected %q", kept, expected)
+	}
+}
+
+func TestKeepNothing(t *testing.T) {
+	if kept := (Rules{Daily: 7}).Keep(nil); len(kept) != 0 {
+		t.Errorf("Keep(nil) = %q, expected no result", kept)
+	}
+}
@@ -0,0 +1,408 @@
+/*
+Package schedule parses the schedules of the instances, telling when they
+should be running. A schedule is either a list of time windows:
+
+	weekdays 08:00-19:00 Europe/Paris
+	mon-fri 08:00-12:00, mon-fri 14:00-18:00
+	daily 22:00-06:00 UTC               (overnight)
+	sat+sun 10:00-16:00 America/New_York
+
+or cron expressions (minute hour day-of-month month day-of-week) of the start and the stop:
+
+	start=0 8 * * 1-5; stop=30 19 * * 1-5; tz=Europe/Paris
+
+The days are mon, tue, wed, thu, fri, sat, sun (or monday, tuesday...), ranges
+(mon-fri), lists (mon+wed), weekdays, weekends and daily. They can be omitted:
+"08:00-19:00 Europe/Paris" is every day. The time zone is optional (default: UTC);
+it applies to all the windows, and can be given after any of them.
+*/
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the time zones work even if the system has no time zone database
+)
+
+// Maximum time searched for the next transition of a schedule.
+const horizon = 366 * 24 * time.Hour
+
+// A schedule, telling when an instance should be running.
+type Schedule struct {
+	Location *time.Location
+	// time windows (ex: weekdays 08:00-19:00)
+	windows []window
+	// or cron expressions
+	start, stop *cron
+
+	text string
+}
+
+// Parses the schedule given in parameter (see the package documentation).
+func Parse(value string) (*Schedule, error) {
+	s := &Schedule{Location: time.UTC, text: strings.TrimSpace(value)}
+	var err error
+	if strings.Contains(value, "=") {
+		err = s.parseCron(value)
+	} else {
+		err = s.parseWindows(value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", value, err)
+	}
+	return s, nil
+}
+
+func (s *Schedule) String() string {
+	return s.text
+}
+
+// Returns true if the instance should be running at time t.
+func (s *Schedule) Running(t time.Time) bool {
+	t = t.In(s.Location)
+	if s.windows != nil {
+		for _, w := range s.windows {
+			if w.contains(t) {
+				return true
+			}
+		}
+		return false
+	}
+	// running if the last start is after the last stop
+	lastStart, okStart := s.start.prev(t)
+	lastStop, okStop := s.stop.prev(t)
+	return okStart && (!okStop || lastStart.After(lastStop))
+}
+
+// Returns the time of the next transition after t, and true if the instance should
+// be running after it (start) or false (stop). ok is false if the state never changes.
+func (s *Schedule) Next(t time.Time) (next time.Time, running bool, ok bool) {
+	t = t.In(s.Location)
+	current := s.Running(t)
+	if s.windows == nil {
+		// the next start (if stopped) or stop (if running) that changes the state
+		c := s.start
+		if current {
+			c = s.stop
+		}
+		next, ok = c.next(t)
+		return next, !current, ok
+	}
+	// the windows change at minute boundaries
+	minute := t.Truncate(time.Minute).Add(time.Minute)
+	for end := t.Add(8 * 24 * time.Hour); minute.Before(end); minute = minute.Add(time.Minute) {
+		if s.Running(minute) != current {
+			return minute, !current, true
+		}
+	}
+	return time.Time{}, current, false
+}
+
+// A time window on some days of the week, in minutes since midnight.
+// If to < from, the window ends the next day.
+type window struct {
+	days     [7]bool // indexed by time.Weekday
+	from, to int
+}
+
+func (w window) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.from <= w.to {
+		return w.days[day] && minute >= w.from && minute < w.to
+	}
+	// overnight window: started today, or started yesterday
+	yesterday := (day + 6) % 7
+	return (w.days[day] && minute >= w.from) || (w.days[yesterday] && minute < w.to)
+}
+
+// Parses windows such as "weekdays 08:00-19:00, sat 10:00-12:00 Europe/Paris":
+// each window is [<days>] <HH:MM>-<HH:MM> [<time zone>]. The time zone applies
+// to all the windows, so it can be given after any of them, but only once
+// (or repeated identically).
+func (s *Schedule) parseWindows(value string) error {
+	zone := ""
+	for _, part := range strings.Split(value, ",") {
+		fields := strings.Fields(part)
+		// the time range is the only field with a ":"
+		clock := -1
+		for i, field := range fields {
+			if strings.Contains(field, ":") {
+				if clock >= 0 {
+					return fmt.Errorf("expected one time range in window %q", strings.TrimSpace(part))
+				}
+				clock = i
+			}
+		}
+		if clock < 0 || clock > 1 || len(fields)-clock > 2 {
+			return fmt.Errorf("expected \"[<days>] <HH:MM>-<HH:MM> [<time zone>]\", got %q", strings.TrimSpace(part))
+		}
+
+		w := window{days: everyDay}
+		if clock == 1 {
+			days, err := parseDays(fields[0])
+			if err != nil {
+				return err
+			}
+			w.days = days
+		}
+		from, to, ok := strings.Cut(fields[clock], "-")
+		if !ok {
+			return fmt.Errorf("expected a time range <HH:MM>-<HH:MM>, got %q", fields[clock])
+		}
+		var err error
+		if w.from, err = parseClock(from); err != nil {
+			return err
+		}
+		if w.to, err = parseClock(to); err != nil {
+			return err
+		}
+		if w.from == w.to {
+			return fmt.Errorf("empty time range %q", fields[clock])
+		}
+		s.windows = append(s.windows, w)
+
+		if clock+1 < len(fields) {
+			name := fields[clock+1]
+			if zone != "" && zone != name {
+				return fmt.Errorf("several time zones (%s and %s): a schedule has one time zone", zone, name)
+			}
+			location, err := time.LoadLocation(name)
+			if err != nil {
+				return fmt.Errorf("unknown time zone %q", name)
+			}
+			zone, s.Location = name, location
+		}
+	}
+	return nil
+}
+
+// Parses a time of the day (ex: 08:00, 8:30, 24:00), in minutes since midnight.
+func parseClock(value string) (int, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	h, errH := strconv.Atoi(hours)
+	m, errM := strconv.Atoi(minutes)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", value)
+	}
+	return h*60 + m, nil
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+var everyDay = [7]bool{true, true, true, true, true, true, true}
+
+// Parses days such as weekdays, mon-fri or mon+wed+fri (separated by "+" or "/",
+// as "," separates the windows). The days are named by their 3 first letters
+// or their full names (ex: tue or tuesday).
+func parseDays(value string) ([7]bool, error) {
+	var days [7]bool
+	switch strings.ToLower(value) {
+	case "daily", "everyday", "*":
+		return everyDay, nil
+	case "weekdays":
+		return [7]bool{false, true, true, true, true, true, false}, nil
+	case "weekends":
+		return [7]bool{true, false, false, false, false, false, true}, nil
+	}
+	for _, item := range strings.Split(strings.ReplaceAll(strings.ToLower(value), "/", "+"), "+") {
+		if item == "" {
+			// ex: "+", or "mon++wed" (a day list without day would never run the instance)
+			return days, fmt.Errorf("missing day in %q", value)
+		}
+		first, last, isRange := strings.Cut(item, "-")
+		from, ok := dayNames[first]
+		if !ok {
+			return days, fmt.Errorf("unknown day %q (expected mon, tue... or monday, tuesday...)", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = dayNames[last]; !ok {
+				return days, fmt.Errorf("unknown day %q (expected mon, tue... or monday, tuesday...)", last)
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// Parses "start=<cron>; stop=<cron>; tz=<time zone>".
+func (s *Schedule) parseCron(value string) error {
+	for _, part := range strings.Split(value, ";") {
+		key, expr, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return fmt.Errorf("expected start=<cron>, stop=<cron> or tz=<time zone>, got %q", part)
+		}
+		var err error
+		switch strings.TrimSpace(key) {
+		case "start":
+			s.start, err = parseCronExpr(expr)
+		case "stop":
+			s.stop, err = parseCronExpr(expr)
+		case "tz":
+			s.Location, err = time.LoadLocation(strings.TrimSpace(expr))
+		default:
+			err = fmt.Errorf("unknown key %q (expected start, stop or tz)", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if s.start == nil || s.stop == nil {
+		return fmt.Errorf("both start and stop are required")
+	}
+	return nil
+}
+
+// A cron expression: the allowed minutes, hours, days of the month, months and days of the week.
+type cron struct {
+	minutes [60]bool
+	hours   [24]bool
+	dom     [32]bool
+	months  [13]bool
+	dow     [7]bool
+	anyDom  bool // the day of the month is "*"
+	anyDow  bool // the day of the week is "*"
+}
+
+// Parses a cron expression "minute hour day-of-month month day-of-week".
+func parseCronExpr(expr string) (*cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q (expected 5 fields: minute hour day-of-month month day-of-week)", expr)
+	}
+	c := &cron{anyDom: fields[2] == "*", anyDow: fields[4] == "*"}
+	if err := parseCronField(fields[0], 0, 59, c.minutes[:]); err != nil {
+		return nil, err
+	}
+	if err := parseCronField(fields[1], 0, 23, c.hours[:]); err != nil {
+		return nil, err
+	}
+	if err := parseCronField(fields[2], 1, 31, c.dom[:]); err != nil {
+		return nil, err
+	}
+	if err := parseCronField(fields[3], 1, 12, c.months[:]); err != nil {
+		return nil, err
+	}
+	// days of the week: 0-7 (0 and 7 are sunday), or names
+	var dow [8]bool
+	field := strings.ToLower(fields[4])
+	// the full names first, as they start with the short names
+	for _, full := range []bool{true, false} {
+		for name, day := range dayNames {
+			if (len(name) > 3) == full {
+				field = strings.ReplaceAll(field, name, strconv.Itoa(int(day)))
+			}
+		}
+	}
+	if err := parseCronField(field, 0, 7, dow[:]); err != nil {
+		return nil, err
+	}
+	copy(c.dow[:], dow[:7])
+	c.dow[0] = c.dow[0] || dow[7]
+	return c, nil
+}
+
+// Parses a field of a cron expression: *, 5, 1-5, */15, 0-30/10, or a list (1,3,5).
+func parseCronField(field string, low int, high int, allowed []bool) error {
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return fmt.Errorf("invalid step in cron field %q", field)
+			}
+		}
+		from, to := low, high
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if from, err = strconv.Atoi(first); err != nil {
+				return fmt.Errorf("invalid cron field %q", field)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil {
+					return fmt.Errorf("invalid cron field %q", field)
+				}
+			} else if hasStep {
+				to = high
+			}
+		}
+		if from < low || to > high || from > to {
+			return fmt.Errorf("cron field %q out of range %d-%d", field, low, high)
+		}
+		for i := from; i <= to; i += step {
+			allowed[i] = true
+		}
+	}
+	return nil
+}
+
+// Returns true if the day of t is allowed (as in cron: if both the day of the month
+// and the day of the week are restricted, one of them must match).
+func (c *cron) matchDay(t time.Time) bool {
+	if !c.months[t.Month()] {
+		return false
+	}
+	dom, dow := c.dom[t.Day()], c.dow[t.Weekday()]
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+// Returns the first time after t (excluded) matching the expression.
+func (c *cron) next(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for end := t.Add(horizon); t.Before(end); {
+		switch {
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.hours[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Returns the last time before t (included) matching the expression.
+func (c *cron) prev(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	for end := t.Add(-horizon); t.After(end); {
+		switch {
+		case !c.matchDay(t):
+			// last minute of the previous day
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+		case !c.hours[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location()).Add(-time.Minute)
+		case !c.minutes[t.Minute()]:
+			t = t.Add(-time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,170 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, value string) *Schedule {
+	t.Helper()
+	s, err := Parse(value)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", value, err)
+	}
+	return s
+}
+
+func utc(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"monkey 08:00-10:00",
+		"thunder 08:00-10:00",
+		"mo 08:00-10:00",
+		"+ 08:00-10:00",
+		"mon+/ 08:00-10:00",
+		"mon- 08:00-10:00",
+		"mon 08:00",
+		"mon 08:00-08:00",
+		"mon 08:00-25:00",
+		"mon 08:60-10:00",
+		"mon 08:00-10:00 10:00-12:00",
+		"mon tue 08:00-10:00",
+		"mon 08:00-10:00 Europe/Paris extra",
+		"mon 08:00-10:00 Mars/Olympus",
+		"mon 08:00-10:00 Europe/Paris, tue 08:00-10:00 UTC",
+		"start=0 8 * * *",
+		"start=0 8 * *; stop=0 19 * * *",
+		"start=60 8 * * *; stop=0 19 * * *",
+		"start=0 8 * * 1-9; stop=0 19 * * *",
+		"start=0 8 * * *; stop=0 19 * * *; tz=Mars/Olympus",
+		"start=0 8 * * *; stop=0 19 * * *; color=blue",
+	} {
+		if _, err := Parse(value); err == nil {
+			t.Errorf("Parse(%q): expected an error", value)
+		}
+	}
+}
+
+func TestRunning(t *testing.T) {
+	// 2024-01-01 is a monday
+	tests := []struct {
+		schedule string
+		at       string
+		running  bool
+	}{
+		{"weekdays 08:00-19:00", "2024-01-01T08:00:00Z", true},
+		{"weekdays 08:00-19:00", "2024-01-01T07:59:00Z", false},
+		{"weekdays 08:00-19:00", "2024-01-01T19:00:00Z", false},
+		{"weekdays 08:00-19:00", "2024-01-06T10:00:00Z", false},
+		{"monday-friday 08:00-19:00", "2024-01-05T10:00:00Z", true},
+		{"sat+sun 10:00-16:00", "2024-01-07T10:00:00Z", true},
+		{"sat/sun 10:00-16:00", "2024-01-05T10:00:00Z", false},
+		{"fri-mon 10:00-16:00", "2024-01-07T10:00:00Z", true},
+		{"fri-mon 10:00-16:00", "2024-01-03T10:00:00Z", false},
+		{"mon-fri 08:00-12:00, mon-fri 14:00-18:00", "2024-01-02T13:00:00Z", false},
+		{"mon-fri 08:00-12:00, mon-fri 14:00-18:00", "2024-01-02T15:00:00Z", true},
+		// days omitted: every day
+		{"08:00-19:00", "2024-01-07T10:00:00Z", true},
+		{"08:00-19:00 Europe/Paris", "2024-01-07T07:30:00Z", true},
+		{"08:00-19:00 Europe/Paris", "2024-01-07T18:30:00Z", false},
+		// overnight windows
+		{"daily 22:00-06:00", "2024-01-01T23:00:00Z", true},
+		{"daily 22:00-06:00", "2024-01-02T05:59:00Z", true},
+		{"daily 22:00-06:00", "2024-01-02T06:00:00Z", false},
+		{"daily 22:00-06:00", "2024-01-02T21:59:00Z", false},
+		{"fri 22:00-02:00", "2024-01-06T01:00:00Z", true},  // saturday morning
+		{"fri 22:00-02:00", "2024-01-05T01:00:00Z", false}, // friday morning: started on thursday
+		{"fri 22:00-02:00", "2024-01-07T01:00:00Z", false},
+		{"daily 00:00-24:00", "2024-01-03T23:59:00Z", true},
+		// the time zone applies to all the windows, wherever it's given
+		{"mon 08:00-10:00 Europe/Paris, tue 08:00-10:00", "2024-01-02T07:30:00Z", true},
+		{"mon 08:00-10:00 Europe/Paris, tue 08:00-10:00", "2024-01-02T09:30:00Z", false},
+		{"mon 08:00-10:00, tue 08:00-10:00 Europe/Paris", "2024-01-01T07:30:00Z", true},
+		// cron: day of the week
+		{"start=0 8 * * 1-5; stop=30 19 * * 1-5", "2024-01-01T12:00:00Z", true},
+		{"start=0 8 * * 1-5; stop=30 19 * * 1-5", "2024-01-01T19:30:00Z", false},
+		{"start=0 8 * * mon-fri; stop=30 19 * * mon-fri", "2024-01-06T12:00:00Z", false},
+		{"start=0 8 * * monday-friday; stop=30 19 * * monday-friday", "2024-01-05T12:00:00Z", true},
+		// cron: day of the month (stopped the next day)
+		{"start=0 8 1 * *; stop=0 8 2 * *", "2024-02-01T12:00:00Z", true},
+		{"start=0 8 1 * *; stop=0 8 2 * *", "2024-02-02T12:00:00Z", false},
+		// cron: day of the month or day of the week, as in cron
+		{"start=0 8 15 * sat; stop=0 18 * * *", "2024-01-15T12:00:00Z", true}, // a monday
+		{"start=0 8 15 * sat; stop=0 18 * * *", "2024-01-06T12:00:00Z", true}, // a saturday
+		{"start=0 8 15 * sat; stop=0 18 * * *", "2024-01-16T12:00:00Z", false},
+		// cron: never started
+		{"start=0 8 30 2 *; stop=0 18 * * *", "2024-03-01T12:00:00Z", false},
+	}
+	for _, test := range tests {
+		s := mustParse(t, test.schedule)
+		if running := s.Running(utc(test.at)); running != test.running {
+			t.Errorf("%q at %s: running = %v, expected %v", test.schedule, test.at, running, test.running)
+		}
+	}
+}
+
+func TestRunningDST(t *testing.T) {
+	// in Paris, the clocks go from 02:00 to 03:00 on 2024-03-31: the window
+	// stays at 08:00-19:00 local time, so it moves one hour earlier in UTC
+	s := mustParse(t, "daily 08:00-19:00 Europe/Paris")
+	tests := []struct {
+		at      string
+		running bool
+	}{
+		{"2024-03-30T06:30:00Z", false}, // 07:30 CET
+		{"2024-03-30T07:30:00Z", true},  // 08:30 CET
+		{"2024-03-31T05:30:00Z", false}, // 07:30 CEST
+		{"2024-03-31T06:30:00Z", true},  // 08:30 CEST
+		{"2024-03-31T17:30:00Z", false}, // 19:30 CEST
+	}
+	for _, test := range tests {
+		if running := s.Running(utc(test.at)); running != test.running {
+			t.Errorf("at %s: running = %v, expected %v", test.at, running, test.running)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		schedule string
+		at       string
+		next     string // "" if the state never changes
+		running  bool
+	}{
+		{"weekdays 08:00-19:00", "2024-01-01T12:00:00Z", "2024-01-01T19:00:00Z", false},
+		{"weekdays 08:00-19:00", "2024-01-05T19:00:00Z", "2024-01-08T08:00:00Z", true},
+		{"weekdays 08:00-19:00", "2024-01-01T07:59:30Z", "2024-01-01T08:00:00Z", true},
+		{"daily 22:00-06:00", "2024-01-01T23:00:00Z", "2024-01-02T06:00:00Z", false},
+		{"fri 22:00-02:00", "2024-01-01T12:00:00Z", "2024-01-05T22:00:00Z", true},
+		{"daily 00:00-24:00", "2024-01-01T12:00:00Z", "", true},
+		{"start=0 8 * * 1-5; stop=30 19 * * 1-5", "2024-01-01T12:00:00Z", "2024-01-01T19:30:00Z", false},
+		{"start=0 8 * * 1-5; stop=30 19 * * 1-5", "2024-01-05T20:00:00Z", "2024-01-08T08:00:00Z", true},
+		{"start=0 8 1 * *; stop=0 8 2 * *", "2024-01-02T12:00:00Z", "2024-02-01T08:00:00Z", true},
+		// across the DST change: 08:00 local time is 07:00 UTC, then 06:00 UTC
+		{"daily 08:00-19:00 Europe/Paris", "2024-03-30T20:00:00Z", "2024-03-31T06:00:00Z", true},
+		{"start=0 8 * * *; stop=0 19 * * *; tz=Europe/Paris", "2024-03-30T20:00:00Z", "2024-03-31T06:00:00Z", true},
+		{"start=0 8 * * *; stop=0 19 * * *; tz=Europe/Paris", "2024-03-31T12:00:00Z", "2024-03-31T17:00:00Z", false},
+	}
+	for _, test := range tests {
+		s := mustParse(t, test.schedule)
+		next, running, ok := s.Next(utc(test.at))
+		if test.next == "" {
+			if ok {
+				t.Errorf("%q after %s: expected no transition, got %s", test.schedule, test.at, next)
+			}
+			continue
+		}
+		if !ok || !next.Equal(utc(test.next)) || running != test.running {
+			t.Errorf("%q after %s: got %s (running: %v, ok: %v), expected %s (running: %v)",
+				test.schedule, test.at, next, running, ok, test.next, test.running)
+		}
+	}
+}
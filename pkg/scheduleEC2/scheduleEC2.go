@@ -0,0 +1,196 @@
+package scheduleEC2
+
+import (
+	"aws/pkg/describeEC2"
+	"aws/pkg/lifecycleEC2"
+	"aws/pkg/schedule"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Tag giving the schedule of an instance (ex: "weekdays 08:00-19:00 Europe/Paris",
+// see package schedule).
+const Tag = "schedule"
+
+// What the scheduler does to an instance.
+const (
+	ActionStart = lifecycleEC2.ActionStart
+	ActionStop  = lifecycleEC2.ActionStop
+	ActionNone  = "none"
+)
+
+// Options of the scheduler. The zero value uses the defaults.
+type Options struct {
+	// options of the start and stop requests (Lifecycle.DryRun and
+	// Lifecycle.Output are set to DryRun and Output)
+	Lifecycle lifecycleEC2.Options
+	// returns the current time (default: time.Now)
+	Now func() time.Time
+	// if true, nothing is changed: the result shows what would be done
+	// (the requests are sent with DryRun=true, see package dryrun)
+	DryRun bool
+	// writer where the progress messages are printed (default: os.Stdout; set it
+	// to os.Stderr to keep stdout for the results, or to io.Discard)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// What the scheduler did to one instance, and its next transition.
+type InstanceResult struct {
+	InstanceID string `json:"instance_id" yaml:"instance_id"`
+	Name       string `json:"name,omitempty" yaml:"name,omitempty"`
+	Schedule   string `json:"schedule" yaml:"schedule"`
+	State      string `json:"state" yaml:"state"`                                 // state before the scheduler ran (ex: running)
+	Action     string `json:"action" yaml:"action"`                               // start, stop or none
+	Done       bool   `json:"done" yaml:"done"`                                   // true if the action was done
+	DryRun     bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`         // true if the action would have been done
+	NextAction string `json:"next_action,omitempty" yaml:"next_action,omitempty"` // start or stop
+	NextAt     string `json:"next_at,omitempty" yaml:"next_at,omitempty"`         // time of the next action (RFC 3339, in the time zone of the schedule)
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`             // why the action failed, or why the schedule is invalid
+}
+
+// Result of Reconcile.
+type Result struct {
+	Now       string           `json:"now" yaml:"now"` // time at which the schedules were evaluated
+	Instances []InstanceResult `json:"instances" yaml:"instances"`
+}
+
+func (r *Result) Table() ([]string, [][]string) {
+	rows := make([][]string, len(r.Instances))
+	for i, instance := range r.Instances {
+		action := instance.Action
+		if instance.DryRun {
+			action += " (dry run)"
+		}
+		next := ""
+		if instance.NextAction != "" {
+			next = instance.NextAction + " at " + instance.NextAt
+		}
+		rows[i] = []string{instance.InstanceID, instance.Name, instance.Schedule, instance.State, action, next, instance.Error}
+	}
+	return []string{"INSTANCE ID", "NAME", "SCHEDULE", "STATE", "ACTION", "NEXT TRANSITION", "ERROR"}, rows
+}
+
+func (r *Result) IDs() []string {
+	ids := make([]string, len(r.Instances))
+	for i, instance := range r.Instances {
+		ids[i] = instance.InstanceID
+	}
+	return ids
+}
+
+// Returns the action needed for an instance in the state given in parameter to be
+// running or not (the instances being stopped are left alone until they're stopped).
+func actionFor(state types.InstanceStateName, running bool) string {
+	switch {
+	case running && state == types.InstanceStateNameStopped:
+		return ActionStart
+	case !running && (state == types.InstanceStateNameRunning || state == types.InstanceStateNamePending):
+		return ActionStop
+	}
+	return ActionNone
+}
+
+// Finds the instances with a schedule tag (see package schedule), and starts or stops
+// them so that they're running during their schedule and stopped outside of it.
+// The result lists every scheduled instance with its next transition (with
+// options.DryRun, nothing is changed: the result shows what would be done).
+// An invalid schedule is reported in the result and makes Reconcile return an error,
+// but the other instances are still reconciled. Run it periodically (ex: every 5 minutes).
+func Reconcile(ec2client *ec2.Client, options Options) (*Result, error) {
+	now := time.Now()
+	if options.Now != nil {
+		now = options.Now()
+	}
+	options.Lifecycle.DryRun = options.DryRun
+	options.Lifecycle.Output = options.Output
+	result := &Result{Now: now.Format(time.RFC3339), Instances: []InstanceResult{}}
+
+	filterName := "tag-key"
+	input := &ec2.DescribeInstancesInput{Filters: []types.Filter{{Name: &filterName, Values: []string{Tag}}}}
+	var errs []error
+	var toStart, toStop []string
+	err := describeEC2.EachInstance(ec2client, input, func(instance types.Instance) error {
+		if describeEC2.IsTerminated(instance) {
+			return nil
+		}
+		described := describeEC2.NewInstance(instance)
+		r := InstanceResult{
+			InstanceID: described.InstanceID,
+			Name:       described.Name,
+			Schedule:   described.Tags[Tag],
+			State:      string(instance.State.Name),
+			Action:     ActionNone,
+		}
+		s, err := schedule.Parse(r.Schedule)
+		if err != nil {
+			r.Error = err.Error()
+			errs = append(errs, fmt.Errorf("instance %s: %w", r.InstanceID, err))
+			result.Instances = append(result.Instances, r)
+			return nil
+		}
+		r.Action = actionFor(instance.State.Name, s.Running(now))
+		switch r.Action {
+		case ActionStart:
+			toStart = append(toStart, r.InstanceID)
+		case ActionStop:
+			toStop = append(toStop, r.InstanceID)
+		}
+		if next, running, ok := s.Next(now); ok {
+			r.NextAction = ActionStop
+			if running {
+				r.NextAction = ActionStart
+			}
+			r.NextAt = next.Format(time.RFC3339)
+		}
+		result.Instances = append(result.Instances, r)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching info on scheduled instances failed: %w", err)
+	}
+	fmt.Fprintf(options.messages(), "%d scheduled instances: %d to start, %d to stop.\n", len(result.Instances), len(toStart), len(toStop))
+
+	if len(toStart) > 0 {
+		started, err := lifecycleEC2.Start(ec2client, toStart, options.Lifecycle)
+		result.record(started)
+		errs = append(errs, err)
+	}
+	if len(toStop) > 0 {
+		stopped, err := lifecycleEC2.Stop(ec2client, toStop, options.Lifecycle)
+		result.record(stopped)
+		errs = append(errs, err)
+	}
+	return result, errors.Join(errs...)
+}
+
+// Records the result of the start or stop requests in the result of the instances.
+func (r *Result) record(lifecycle *lifecycleEC2.Result) {
+	if lifecycle == nil {
+		return
+	}
+	byID := make(map[string]lifecycleEC2.InstanceResult)
+	for _, instance := range lifecycle.Instances {
+		byID[instance.InstanceID] = instance
+	}
+	for i, instance := range r.Instances {
+		if done, ok := byID[instance.InstanceID]; ok && instance.Action == lifecycle.Action {
+			r.Instances[i].Done = done.Done
+			r.Instances[i].DryRun = done.DryRun
+			r.Instances[i].Error = done.Error
+		}
+	}
+}
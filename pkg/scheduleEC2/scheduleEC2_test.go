@@ -0,0 +1,37 @@
+package scheduleEC2
+
+import (
+	"aws/pkg/schedule"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestActionFor(t *testing.T) {
+	s, err := schedule.Parse("weekdays 08:00-19:00 Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a monday, during the window (09:00 in Paris), and a saturday
+	monday := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	saturday := time.Date(2024, 1, 6, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		state  types.InstanceStateName
+		now    time.Time
+		action string
+	}{
+		{types.InstanceStateNameStopped, monday, ActionStart},
+		{types.InstanceStateNameRunning, monday, ActionNone},
+		{types.InstanceStateNameStopping, monday, ActionNone},
+		{types.InstanceStateNameRunning, saturday, ActionStop},
+		{types.InstanceStateNamePending, saturday, ActionStop},
+		{types.InstanceStateNameStopped, saturday, ActionNone},
+		{types.InstanceStateNameStopping, saturday, ActionNone},
+	}
+	for _, test := range tests {
+		if action := actionFor(test.state, s.Running(test.now)); action != test.action {
+			t.Errorf("%s instance at %s: action %s, expected %s", test.state, test.now, action, test.action)
+		}
+	}
+}
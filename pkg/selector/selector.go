@@ -0,0 +1,329 @@
+/*
+Package selector parses the expressions selecting EC2 instances, such as:
+
+	tag:env=dev,state=running,type=t2.*,launched<7d,name~web-*
+
+An expression is a list of conditions separated by commas, which must all be true.
+Each condition is "<field><operator><value>". The fields are:
+
+	id, name, state, type, az, ami, key, vpc, subnet, sg, public-ip, private-ip,
+	tag:<key> (value of the tag <key>), launched (age of the instance)
+
+The operators are:
+
+	=   equals (* and ? are wildcards, and | separates alternatives: state=running|stopped)
+	!=  doesn't equal (same syntax as =)
+	~   matches, ignoring case (* and ? are wildcards, | separates alternatives)
+	!~  doesn't match
+	<   for launched only: launched less than <duration> ago (ex: launched<7d)
+	>   for launched only: launched more than <duration> ago
+
+Durations are numbers followed by s, m, h, d (days) or w (weeks), ex: 90m, 12h, 7d.
+Values can't contain the characters of the operators (=, ~, < and >): a condition
+such as name=a=b is rejected.
+
+Conditions with "=" are sent to AWS as filters of the DescribeInstances request
+(so the instances are filtered server-side), the others are checked on each instance
+returned by AWS (client-side).
+*/
+package selector
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// A compiled selector expression.
+type Selector struct {
+	expr string
+	// server-side filters, to give to the DescribeInstances request
+	Filters []types.Filter
+	// client-side conditions, checked by Match
+	predicates []func(instance types.Instance, now time.Time) bool
+	// client-side version of the server-side conditions, checked by MatchAll
+	filterPredicates []func(instance types.Instance, now time.Time) bool
+	// returns the current time (time.Now if nil), used by the launched conditions
+	Now func() time.Time
+}
+
+// A field that can be used in a condition.
+type field struct {
+	filter string                        // name of the DescribeInstances filter
+	values func(types.Instance) []string // values of the field for an instance
+}
+
+func str(s *string) []string {
+	if s == nil {
+		return nil
+	}
+	return []string{*s}
+}
+
+var fields = map[string]field{
+	"id":   {"instance-id", func(i types.Instance) []string { return str(i.InstanceId) }},
+	"name": {"tag:Name", func(i types.Instance) []string { return tagValue(i, "Name") }},
+	"state": {"instance-state-name", func(i types.Instance) []string {
+		if i.State == nil {
+			return nil
+		}
+		return []string{string(i.State.Name)}
+	}},
+	"type": {"instance-type", func(i types.Instance) []string { return []string{string(i.InstanceType)} }},
+	"az": {"availability-zone", func(i types.Instance) []string {
+		if i.Placement == nil {
+			return nil
+		}
+		return str(i.Placement.AvailabilityZone)
+	}},
+	"ami":        {"image-id", func(i types.Instance) []string { return str(i.ImageId) }},
+	"key":        {"key-name", func(i types.Instance) []string { return str(i.KeyName) }},
+	"vpc":        {"vpc-id", func(i types.Instance) []string { return str(i.VpcId) }},
+	"subnet":     {"subnet-id", func(i types.Instance) []string { return str(i.SubnetId) }},
+	"public-ip":  {"ip-address", func(i types.Instance) []string { return str(i.PublicIpAddress) }},
+	"private-ip": {"private-ip-address", func(i types.Instance) []string { return str(i.PrivateIpAddress) }},
+	"sg": {"instance.group-name", func(i types.Instance) []string {
+		var groups []string
+		for _, group := range i.SecurityGroups {
+			groups = append(groups, str(group.GroupName)...)
+		}
+		return groups
+	}},
+}
+
+func tagValue(instance types.Instance, key string) []string {
+	for _, tag := range instance.Tags {
+		if tag.Key != nil && *tag.Key == key {
+			return str(tag.Value)
+		}
+	}
+	return nil
+}
+
+// Operators, longest first (so that "!=" isn't read as "=").
+var operators = []string{"!=", "!~", "=", "~", "<", ">"}
+
+// Parses and compiles the selector expression given in parameter.
+// An empty expression selects all instances.
+func Parse(expr string) (*Selector, error) {
+	s := &Selector{expr: expr}
+	for _, condition := range strings.Split(expr, ",") {
+		condition = strings.TrimSpace(condition)
+		if condition == "" {
+			continue
+		}
+		if err := s.add(condition); err != nil {
+			return nil, fmt.Errorf("invalid selector condition %q: %w", condition, err)
+		}
+	}
+	return s, nil
+}
+
+// Compiles one condition and adds it to the selector.
+func (s *Selector) add(condition string) error {
+	// find the operator
+	index, operator := -1, ""
+	for _, op := range operators {
+		if i := strings.Index(condition, op); i > 0 && (index == -1 || i < index || (i == index && len(op) > len(operator))) {
+			index, operator = i, op
+		}
+	}
+	if index == -1 {
+		return fmt.Errorf("expected <field><operator><value> (operators: = != ~ !~ < >)")
+	}
+	name, value := strings.TrimSpace(condition[:index]), strings.TrimSpace(condition[index+len(operator):])
+	if i := strings.IndexAny(value, "=~<>"); i != -1 {
+		return fmt.Errorf("unexpected %q in value %q (one operator per condition, separate the conditions with commas)", value[i], value)
+	}
+
+	if name == "launched" {
+		return s.addLaunched(operator, value)
+	}
+	if operator == "<" || operator == ">" {
+		return fmt.Errorf("operator %s can only be used with launched", operator)
+	}
+
+	f, err := lookupField(name)
+	if err != nil {
+		return err
+	}
+	patterns := strings.Split(value, "|")
+
+	switch operator {
+	case "=":
+		// sent to AWS, which supports the same wildcards
+		filterName := f.filter
+		s.Filters = append(s.Filters, types.Filter{Name: &filterName, Values: patterns})
+		glob := compileGlob(patterns, false)
+		s.filterPredicates = append(s.filterPredicates, func(i types.Instance, now time.Time) bool {
+			return matchAny(f.values(i), glob)
+		})
+	case "!=":
+		glob := compileGlob(patterns, false)
+		s.predicates = append(s.predicates, func(i types.Instance, now time.Time) bool {
+			return !matchAny(f.values(i), glob)
+		})
+	case "~":
+		glob := compileGlob(patterns, true)
+		s.predicates = append(s.predicates, func(i types.Instance, now time.Time) bool {
+			return matchAny(f.values(i), glob)
+		})
+	case "!~":
+		glob := compileGlob(patterns, true)
+		s.predicates = append(s.predicates, func(i types.Instance, now time.Time) bool {
+			return !matchAny(f.values(i), glob)
+		})
+	}
+	return nil
+}
+
+// Returns the field of name given in parameter (ex: "name", "tag:env").
+func lookupField(name string) (field, error) {
+	if strings.HasPrefix(name, "tag:") && len(name) > len("tag:") {
+		key := strings.TrimPrefix(name, "tag:")
+		return field{name, func(i types.Instance) []string { return tagValue(i, key) }}, nil
+	}
+	f, ok := fields[name]
+	if !ok {
+		return field{}, fmt.Errorf("unknown field %q", name)
+	}
+	return f, nil
+}
+
+// Escapes the wildcards of a value of a DescribeInstances filter.
+var filterEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`)
+
+// Adds the condition that the field (ex: "name", "tag:env") is exactly the value given
+// in parameter, for values that aren't part of an expression (ex: the value of a flag):
+// the wildcards (* and ?), | and the characters of the operators are part of the value.
+// Like the conditions with "=", it's sent to AWS as a filter.
+func (s *Selector) AddExact(name string, value string) error {
+	f, err := lookupField(name)
+	if err != nil {
+		return err
+	}
+	filterName := f.filter
+	s.Filters = append(s.Filters, types.Filter{Name: &filterName, Values: []string{filterEscaper.Replace(value)}})
+	s.filterPredicates = append(s.filterPredicates, func(i types.Instance, now time.Time) bool {
+		return slices.Contains(f.values(i), value)
+	})
+	if s.expr != "" {
+		s.expr += ","
+	}
+	s.expr += name + "=" + value
+	return nil
+}
+
+// Compiles the patterns (with the wildcards * and ?) into a regular expression
+// matching any of them.
+func compileGlob(patterns []string, ignoreCase bool) *regexp.Regexp {
+	expr := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		quoted := regexp.QuoteMeta(pattern)
+		quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+		quoted = strings.ReplaceAll(quoted, `\?`, ".")
+		expr[i] = quoted
+	}
+	flags := ""
+	if ignoreCase {
+		flags = "(?i)"
+	}
+	return regexp.MustCompile(flags + "^(?:" + strings.Join(expr, "|") + ")$")
+}
+
+// Compiles a condition on the launch time (launched<7d or launched>7d).
+func (s *Selector) addLaunched(operator string, value string) error {
+	if operator != "<" && operator != ">" {
+		return fmt.Errorf("launched can only be used with < or > (ex: launched<7d)")
+	}
+	age, err := ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	s.predicates = append(s.predicates, func(i types.Instance, now time.Time) bool {
+		if i.LaunchTime == nil {
+			return false
+		}
+		launchedSince := now.Sub(*i.LaunchTime)
+		if operator == "<" {
+			return launchedSince < age
+		}
+		return launchedSince > age
+	})
+	return nil
+}
+
+// Returns true if one of the values matches the glob.
+func matchAny(values []string, glob *regexp.Regexp) bool {
+	for _, value := range values {
+		if glob.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses a duration such as 90m, 12h, 7d (days) or 2w (weeks).
+func ParseDuration(value string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		's': time.Second,
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+	if len(value) < 2 {
+		return 0, fmt.Errorf("invalid duration %q (ex: 12h, 7d)", value)
+	}
+	unit, ok := units[value[len(value)-1]]
+	number, err := strconv.ParseFloat(value[:len(value)-1], 64)
+	if !ok || err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid duration %q (ex: 12h, 7d)", value)
+	}
+	return time.Duration(number * float64(unit)), nil
+}
+
+// Returns true if the instance satisfies the client-side conditions of the selector.
+// (the server-side conditions are checked by AWS, with Filters)
+func (s *Selector) Match(instance types.Instance) bool {
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	for _, predicate := range s.predicates {
+		if !predicate(instance, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the instance satisfies all the conditions of the selector,
+// including the server-side ones (for instances that weren't described with Filters).
+func (s *Selector) MatchAll(instance types.Instance) bool {
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	for _, predicate := range s.filterPredicates {
+		if !predicate(instance, now) {
+			return false
+		}
+	}
+	return s.Match(instance)
+}
+
+// Returns true if the selector selects all instances.
+func (s *Selector) Empty() bool {
+	return len(s.Filters) == 0 && len(s.predicates) == 0
+}
+
+// Returns the expression of the selector.
+func (s *Selector) String() string {
+	return s.expr
+}
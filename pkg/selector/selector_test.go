@@ -0,0 +1,243 @@
+package selector
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+var now = time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+// Returns a running t2.micro instance named web-1, with the tag env=dev,
+// launched 2 days before now.
+func instance() types.Instance {
+	return types.Instance{
+		InstanceId:     aws.String("i-0123456789abcdef0"),
+		InstanceType:   types.InstanceTypeT2Micro,
+		State:          &types.InstanceState{Name: types.InstanceStateNameRunning},
+		LaunchTime:     aws.Time(now.Add(-48 * time.Hour)),
+		SecurityGroups: []types.GroupIdentifier{{GroupName: aws.String("default")}, {GroupName: aws.String("web")}},
+		Tags: []types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("web-1")},
+			{Key: aws.String("env"), Value: aws.String("dev")},
+		},
+	}
+}
+
+func mustParse(t *testing.T, expr string) *Selector {
+	t.Helper()
+	s, err := Parse(expr)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", expr, err)
+	}
+	s.Now = func() time.Time { return now }
+	return s
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"name",
+		"=web-1",
+		"color=red",
+		"tag:=dev",
+		"name=a=b",
+		"name!=a=b",
+		"name=a~b",
+		"name~a<b",
+		"tag:env=dev,name",
+		"state<running",
+		"type>t2.micro",
+		"launched=7d",
+		"launched~7d",
+		"launched<7",
+		"launched<d",
+		"launched<7y",
+		"launched<-1d",
+		"launched<7d=",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q): expected an error", expr)
+		}
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		expr    string
+		filters map[string][]string
+		empty   bool
+	}{
+		{"", map[string][]string{}, true},
+		{" , ", map[string][]string{}, true},
+		{"tag:env=dev", map[string][]string{"tag:env": {"dev"}}, false},
+		{"state=running|stopped, type=t2.*", map[string][]string{"instance-state-name": {"running", "stopped"}, "instance-type": {"t2.*"}}, false},
+		// only the conditions with = are sent to AWS
+		{"name!=web-1", map[string][]string{}, false},
+		{"name~WEB-*,launched<7d", map[string][]string{}, false},
+	}
+	for _, test := range tests {
+		s := mustParse(t, test.expr)
+		filters := map[string][]string{}
+		for _, filter := range s.Filters {
+			filters[*filter.Name] = filter.Values
+		}
+		if !reflect.DeepEqual(filters, test.filters) {
+			t.Errorf("Parse(%q).Filters = %v, expected %v", test.expr, filters, test.filters)
+		}
+		if s.Empty() != test.empty {
+			t.Errorf("Parse(%q).Empty() = %v, expected %v", test.expr, s.Empty(), test.empty)
+		}
+		if s.String() != test.expr {
+			t.Errorf("Parse(%q).String() = %q", test.expr, s.String())
+		}
+	}
+}
+
+func TestAddExact(t *testing.T) {
+	i := instance()
+	i.Tags = append(i.Tags, types.Tag{Key: aws.String("team"), Value: aws.String("a=b,c*")})
+	tests := []struct {
+		name   string
+		value  string
+		filter string
+		match  bool
+	}{
+		{"name", "web-1", "web-1", true},
+		// the wildcards are escaped in the filter, and not interpreted
+		{"name", "web-*", `web-\*`, false},
+		{"name", "web-?", `web-\?`, false},
+		// the characters of the operators and the commas are part of the value
+		{"tag:team", "a=b,c*", `a=b,c\*`, true},
+		{"tag:env", "dev|test", "dev|test", false},
+	}
+	for _, test := range tests {
+		s := mustParse(t, "state=running")
+		if err := s.AddExact(test.name, test.value); err != nil {
+			t.Errorf("AddExact(%s, %q) = %v", test.name, test.value, err)
+			continue
+		}
+		if len(s.Filters) != 2 || !reflect.DeepEqual(s.Filters[1].Values, []string{test.filter}) {
+			t.Errorf("AddExact(%s, %q).Filters = %v, expected the value %q", test.name, test.value, s.Filters, test.filter)
+		}
+		if match := s.MatchAll(i); match != test.match {
+			t.Errorf("AddExact(%s, %q).MatchAll() = %v, expected %v", test.name, test.value, match, test.match)
+		}
+	}
+
+	if err := mustParse(t, "").AddExact("size", "3"); err == nil {
+		t.Errorf("AddExact() with an unknown field: expected an error")
+	}
+}
+
+func TestMatchAll(t *testing.T) {
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{"", true},
+		{"id=i-0123456789abcdef0", true},
+		{"name=web-1", true},
+		{"name=web-*", true},
+		{"name=web-?", true},
+		{"name=web.1", false},
+		{"name=web-", false},
+		{"name=WEB-1", false},
+		{"name~WEB-*", true},
+		{"name~db-*", false},
+		{"name!=web-*", false},
+		{"name!=db-*", true},
+		{"name!~WEB-*", false},
+		{"name!~db-*", true},
+		{"state=stopped|running", true},
+		{"state=stopped", false},
+		{"type=t2.*", true},
+		{"type=t3.*|t2.micro", true},
+		{"sg=web", true},
+		{"sg!=web", false},
+		{"tag:env=dev", true},
+		{"tag:env=prod", false},
+		{"tag:env=dev,state=running,name~web-*", true},
+		{"tag:env=dev,state=stopped", false},
+		// an instance without the tag (or field) has no value
+		{"tag:team=*", false},
+		{"tag:team!=backend", true},
+		{"public-ip=*", false},
+		{"launched<7d", true},
+		{"launched<3d", true},
+		{"launched<36h", false},
+		{"launched>1d", true},
+		{"launched>2880m", false},
+		{"launched>1w", false},
+		{"launched>1.5d", true},
+	}
+	for _, test := range tests {
+		if match := mustParse(t, test.expr).MatchAll(instance()); match != test.match {
+			t.Errorf("Parse(%q).MatchAll() = %v, expected %v", test.expr, match, test.match)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	// Match only checks the client-side conditions: the ones with = are checked by AWS
+	tests := []struct {
+		expr     string
+		match    bool
+		matchAll bool
+	}{
+		{"state=stopped", true, false},
+		{"state=stopped,name~web-*", true, false},
+		{"state=running,name!~web-*", false, false},
+		{"state=running,launched<1d", false, false},
+		{"state=running,launched>1d", true, true},
+	}
+	for _, test := range tests {
+		s := mustParse(t, test.expr)
+		if match := s.Match(instance()); match != test.match {
+			t.Errorf("Parse(%q).Match() = %v, expected %v", test.expr, match, test.match)
+		}
+		if matchAll := s.MatchAll(instance()); matchAll != test.matchAll {
+			t.Errorf("Parse(%q).MatchAll() = %v, expected %v", test.expr, matchAll, test.matchAll)
+		}
+	}
+}
+
+func TestMatchWithoutLaunchTime(t *testing.T) {
+	i := instance()
+	i.LaunchTime = nil
+	for _, expr := range []string{"launched<7d", "launched>7d"} {
+		if mustParse(t, expr).MatchAll(i) {
+			t.Errorf("Parse(%q).MatchAll(): expected no match for an instance without launch time", expr)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		duration time.Duration
+	}{
+		{"30s", 30 * time.Second},
+		{"90m", 90 * time.Minute},
+		{"12h", 12 * time.Hour},
+		{"1.5h", 90 * time.Minute},
+		{"7d", 7 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"0d", 0},
+	}
+	for _, test := range tests {
+		duration, err := ParseDuration(test.value)
+		if err != nil {
+			t.Errorf("ParseDuration(%q): %v", test.value, err)
+		} else if duration != test.duration {
+			t.Errorf("ParseDuration(%q) = %s, expected %s", test.value, duration, test.duration)
+		}
+	}
+	for _, value := range []string{"", "d", "7", "7y", "-1d", "xd", "7 d"} {
+		if _, err := ParseDuration(value); err == nil {
+			t.Errorf("ParseDuration(%q): expected an error", value)
+		}
+	}
+}
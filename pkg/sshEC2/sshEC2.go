@@ -0,0 +1,407 @@
+package sshEC2
+
+import (
+	"aws/pkg/describeEC2"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+)
+
+// Login user used when the family of the AMI isn't recognized.
+const DefaultUser = "ec2-user"
+
+// Default timeout of the TCP connection and SSH handshake.
+const DefaultTimeout = 15 * time.Second
+
+// Options of Resolve and Dial. The zero value uses the defaults.
+type Options struct {
+	User    string // login user (default: depends on the AMI of the instance, see ImageUser)
+	KeyDir  string // directory of the private key files <key name>.pem (default: current directory)
+	KeyFile string // private key file (default: <KeyDir>/<key name of the instance>.pem)
+	Port    int    // default: 22
+	// connect to the private IP of the instances instead of their public IP
+	// (ex: from another instance of the VPC)
+	PrivateIP bool
+	// file of the known host keys (default: ~/.ssh/known_hosts). The key of an unknown
+	// host is added to it, the connection fails if the key of a known host changed.
+	KnownHosts string
+	// don't check the host keys (the connection can be intercepted)
+	InsecureIgnoreHostKey bool
+	Timeout               time.Duration // default: DefaultTimeout
+	// writer where the messages are printed, ex: host key added to the known hosts
+	// (default: os.Stdout; set it to os.Stderr to keep stdout for the results)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// An instance to connect to.
+type Target struct {
+	InstanceID string `json:"instance_id" yaml:"instance_id"`
+	Name       string `json:"name,omitempty" yaml:"name,omitempty"`
+	Host       string `json:"host" yaml:"host"` // public IP (private IP with Options.PrivateIP)
+	Port       int    `json:"port" yaml:"port"`
+	User       string `json:"user" yaml:"user"`
+	KeyFile    string `json:"key_file" yaml:"key_file"`
+}
+
+// Returns the address of the SSH server (ex: 203.0.113.10:22).
+func (t *Target) Address() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+func (t *Target) String() string {
+	return t.User + "@" + t.Host
+}
+
+// Returns the target of the instance of ID (starting with "i-") or name given in parameter.
+func Resolve(ec2client *ec2.Client, instance string, options Options) (*Target, error) {
+	listOptions := describeEC2.ListOptions{InstanceIDs: []string{instance}}
+	if !strings.HasPrefix(instance, "i-") {
+		name := "tag:Name"
+		listOptions = describeEC2.ListOptions{Filters: []types.Filter{{Name: &name, Values: []string{instance}}}}
+	}
+	instances, err := describeEC2.ListInstances(ec2client, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) != 1 {
+		return nil, fmt.Errorf("found %d instances matching %q, expected 1 (use the instance ID instead)", len(instances), instance)
+	}
+	targets, err := Targets(ec2client, instances, options)
+	if err != nil {
+		return nil, err
+	}
+	return targets[0], nil
+}
+
+// Returns the targets of the instances given in parameter (in the same order).
+// The AMIs of the instances are described with one request, to choose their login users.
+func Targets(ec2client *ec2.Client, instances []describeEC2.Instance, options Options) ([]*Target, error) {
+	users, err := ImageUsers(ec2client, instances, options)
+	if err != nil {
+		return nil, err
+	}
+	var targets []*Target
+	for _, instance := range instances {
+		target, err := NewTarget(instance, users[instance.AMI], options)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// Returns the target of an instance, whose login user is options.User if set,
+// else imageUser (see ImageUsers), else DefaultUser.
+func NewTarget(instance describeEC2.Instance, imageUser string, options Options) (*Target, error) {
+	target := &Target{
+		InstanceID: instance.InstanceID,
+		Name:       instance.Name,
+		Host:       instance.PublicIP,
+		Port:       options.Port,
+		User:       options.User,
+		KeyFile:    options.KeyFile,
+	}
+	if options.PrivateIP {
+		target.Host = instance.PrivateIP
+	}
+	if target.Host == "" {
+		ip := "public"
+		if options.PrivateIP {
+			ip = "private"
+		}
+		return nil, fmt.Errorf("instance %s has no %s IP (state: %s)", instance.InstanceID, ip, instance.State)
+	}
+	if target.Port == 0 {
+		target.Port = 22
+	}
+	if target.User == "" {
+		target.User = imageUser
+	}
+	if target.User == "" {
+		target.User = DefaultUser
+	}
+	if target.KeyFile == "" {
+		if instance.KeyName == "" {
+			return nil, fmt.Errorf("instance %s has no key pair", instance.InstanceID)
+		}
+		target.KeyFile = filepath.Join(options.KeyDir, instance.KeyName+".pem")
+	}
+	return target, nil
+}
+
+// Login users of the AMI families, searched in the name and description of the AMIs
+// (in this order: the Bitnami images are named bitnami-<app>-...-ubuntu-...).
+var familyUsers = []struct{ family, user string }{
+	{"bitnami", "bitnami"},
+	{"ubuntu", "ubuntu"},
+	{"debian", "admin"},
+	{"centos", "centos"},
+	{"fedora", "fedora"},
+	{"rocky", "rocky"},
+	{"amzn", "ec2-user"},
+	{"al2023", "ec2-user"},
+	{"amazon linux", "ec2-user"},
+	{"rhel", "ec2-user"},
+	{"red hat", "ec2-user"},
+	{"suse", "ec2-user"},
+	{"almalinux", "ec2-user"},
+}
+
+// Returns the login user of an AMI from its name, description and platform
+// (ex: ubuntu for ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-20240301),
+// or "" if its family isn't recognized.
+func ImageUser(image types.Image) string {
+	if strings.EqualFold(string(image.Platform), "windows") {
+		return "Administrator"
+	}
+	text := strings.ToLower(deref(image.Name) + " " + deref(image.Description) + " " + deref(image.PlatformDetails))
+	for _, f := range familyUsers {
+		if strings.Contains(text, f.family) {
+			return f.user
+		}
+	}
+	return ""
+}
+
+// Returns the login users of the AMIs of the instances, by AMI ID (see ImageUser),
+// with one request. AMIs which are not found (ex: deregistered) are absent.
+// Nothing is requested if options.User is set.
+func ImageUsers(ec2client *ec2.Client, instances []describeEC2.Instance, options Options) (map[string]string, error) {
+	users := map[string]string{}
+	var imageIds []string
+	for _, instance := range instances {
+		if _, ok := users[instance.AMI]; !ok && instance.AMI != "" {
+			users[instance.AMI] = ""
+			imageIds = append(imageIds, instance.AMI)
+		}
+	}
+	if len(imageIds) == 0 || options.User != "" {
+		return users, nil
+	}
+	// a filter instead of ImageIds: a missing image doesn't fail the request
+	filter := "image-id"
+	output, err := ec2client.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{
+		Filters: []types.Filter{{Name: &filter, Values: imageIds}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing the images of the instances: %w", err)
+	}
+	for _, image := range output.Images {
+		users[deref(image.ImageId)] = ImageUser(image)
+	}
+	return users, nil
+}
+
+// Connects to the target. Close the client once done.
+func Dial(target *Target, options Options) (*ssh.Client, error) {
+	pem, err := os.ReadFile(target.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the private key of instance %s: %w", target.InstanceID, err)
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key %s: %w", target.KeyFile, err)
+	}
+	hostKeyCallback, err := hostKeyCallback(options)
+	if err != nil {
+		return nil, err
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	client, err := ssh.Dial("tcp", target.Address(), &ssh.ClientConfig{
+		User:            target.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s (instance %s): %w", target, target.InstanceID, err)
+	}
+	return client, nil
+}
+
+// serializes the additions to the known hosts files (the connections can be concurrent)
+var knownHostsLock sync.Mutex
+
+// Returns the callback checking the host keys against the known hosts file.
+// Like ssh -o StrictHostKeyChecking=accept-new, the key of an unknown host is added to the file.
+func hostKeyCallback(options Options) (ssh.HostKeyCallback, error) {
+	if options.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	path := options.KnownHosts
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("error finding the known hosts file: %w", err)
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	// knownhosts.New fails if the file doesn't exist
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating the known hosts file: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the known hosts file: %w", err)
+	}
+	file.Close()
+	check, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the known hosts file: %w", err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			// the public IPs are reused: the host may be another instance, or the connection intercepted
+			return fmt.Errorf("the host key of %s changed since it was added to %s (line %d). If the IP is now used by another instance, remove this line: %w",
+				hostname, keyErr.Want[0].Filename, keyErr.Want[0].Line, err)
+		}
+		knownHostsLock.Lock()
+		defer knownHostsLock.Unlock()
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("error adding the host key of %s to %s: %w", hostname, path, err)
+		}
+		defer file.Close()
+		if _, err := fmt.Fprintln(file, knownhosts.Line([]string{hostname}, key)); err != nil {
+			return fmt.Errorf("error adding the host key of %s to %s: %w", hostname, path, err)
+		}
+		fmt.Fprintf(options.messages(), "Host key of %s (%s) added to %s.\n", knownhosts.Normalize(hostname), key.Type(), path)
+		return nil
+	}, nil
+}
+
+// Runs a command on the instance, and returns its exit code.
+// The error is only set if the command couldn't be run, or ended without exit code
+// (ex: killed by a signal, or connection lost).
+func Run(client *ssh.Client, command string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return 255, fmt.Errorf("error opening an SSH session: %w", err)
+	}
+	defer session.Close()
+	session.Stdin, session.Stdout, session.Stderr = stdin, stdout, stderr
+	return exitCode(session.Run(command))
+}
+
+// Opens an interactive shell on the instance, connected to the terminal
+// (in raw mode, with a pseudo-terminal on the instance), and returns the exit code
+// of the shell. If stdin isn't a terminal, the shell reads its commands from it.
+func Shell(client *ssh.Client) (int, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return 255, fmt.Errorf("error opening an SSH session: %w", err)
+	}
+	defer session.Close()
+	session.Stdin, session.Stdout, session.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		width, height := terminalSize()
+		termType := os.Getenv("TERM")
+		if termType == "" {
+			termType = "xterm"
+		}
+		modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 14400, ssh.TTY_OP_OSPEED: 14400}
+		if err := session.RequestPty(termType, height, width, modes); err != nil {
+			return 255, fmt.Errorf("error requesting a pseudo-terminal: %w", err)
+		}
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return 255, fmt.Errorf("error setting the terminal in raw mode: %w", err)
+		}
+		defer term.Restore(fd, state)
+
+		// the size of the terminal is polled (SIGWINCH doesn't exist on Windows)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(500 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if w, h := terminalSize(); w != width || h != height {
+						width, height = w, h
+						session.WindowChange(height, width)
+					}
+				}
+			}
+		}()
+	}
+
+	if err := session.Shell(); err != nil {
+		return 255, fmt.Errorf("error starting the shell: %w", err)
+	}
+	return exitCode(session.Wait())
+}
+
+// Returns the width and height of the terminal (80x24 if unknown).
+func terminalSize() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// Returns the exit code of a command from the error of ssh.Session.Run or Wait
+// (255 if it has none, as the ssh program).
+func exitCode(err error) (int, error) {
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		if exitErr.Signal() != "" {
+			return 255, fmt.Errorf("remote command killed by signal %s", exitErr.Signal())
+		}
+		return exitErr.ExitStatus(), nil
+	}
+	var missing *ssh.ExitMissingError
+	if errors.As(err, &missing) {
+		return 255, fmt.Errorf("remote command ended without exit status (connection lost?)")
+	}
+	return 255, fmt.Errorf("error running the remote command: %w", err)
+}
+
+// Returns the string pointed by s, or "" if s is nil.
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
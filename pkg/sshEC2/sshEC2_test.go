@@ -0,0 +1,89 @@
+package sshEC2
+
+import (
+	"aws/pkg/describeEC2"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestImageUser(t *testing.T) {
+	tests := []struct {
+		image    types.Image
+		expected string
+	}{
+		{types.Image{Name: aws.String("ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-amd64-server-20240301")}, "ubuntu"},
+		{types.Image{Name: aws.String("debian-12-amd64-20240201-1644")}, "admin"},
+		{types.Image{Name: aws.String("al2023-ami-2023.3.20240304.0-kernel-6.1-x86_64")}, "ec2-user"},
+		{types.Image{Name: aws.String("amzn2-ami-kernel-5.10-hvm-2.0.20240306.2-x86_64-gp2")}, "ec2-user"},
+		{types.Image{Name: aws.String("RHEL-9.3.0_HVM-20240117-x86_64-49-Hourly2-GP3")}, "ec2-user"},
+		// the Bitnami images are named after the distribution they're based on too
+		{types.Image{Name: aws.String("bitnami-wordpress-6.4.3-0-linux-ubuntu-22.04-x86_64-hvm-ebs")}, "bitnami"},
+		// the family can be found in the description or the platform details
+		{types.Image{Name: aws.String("my-image"), Description: aws.String("Rocky Linux 9 base")}, "rocky"},
+		{types.Image{Name: aws.String("my-image"), PlatformDetails: aws.String("Red Hat Enterprise Linux")}, "ec2-user"},
+		{types.Image{Name: aws.String("Windows_Server-2022-English-Full-Base"), Platform: types.PlatformValuesWindows}, "Administrator"},
+		{types.Image{Name: aws.String("my-image")}, ""},
+		{types.Image{}, ""},
+	}
+	for _, test := range tests {
+		if user := ImageUser(test.image); user != test.expected {
+			t.Errorf("ImageUser(%q) = %q, expected %q", aws.ToString(test.image.Name), user, test.expected)
+		}
+	}
+}
+
+func TestNewTarget(t *testing.T) {
+	instance := describeEC2.Instance{InstanceID: "i-1", Name: "web", PublicIP: "203.0.113.10", PrivateIP: "10.0.0.10", KeyName: "mykey", State: "running"}
+	tests := []struct {
+		name      string
+		instance  describeEC2.Instance
+		imageUser string
+		options   Options
+		expected  Target
+	}{
+		{
+			"defaults", instance, "", Options{},
+			Target{InstanceID: "i-1", Name: "web", Host: "203.0.113.10", Port: 22, User: DefaultUser, KeyFile: "mykey.pem"},
+		},
+		{
+			"user of the image", instance, "ubuntu", Options{KeyDir: "keys"},
+			Target{InstanceID: "i-1", Name: "web", Host: "203.0.113.10", Port: 22, User: "ubuntu", KeyFile: filepath.Join("keys", "mykey.pem")},
+		},
+		{
+			"options", instance, "ubuntu", Options{User: "admin", KeyFile: "other.pem", Port: 2222, PrivateIP: true},
+			Target{InstanceID: "i-1", Name: "web", Host: "10.0.0.10", Port: 2222, User: "admin", KeyFile: "other.pem"},
+		},
+	}
+	for _, test := range tests {
+		target, err := NewTarget(test.instance, test.imageUser, test.options)
+		if err != nil {
+			t.Errorf("%s: NewTarget() = %v", test.name, err)
+			continue
+		}
+		if *target != test.expected {
+			t.Errorf("%s: NewTarget() = %+v, expected %+v", test.name, *target, test.expected)
+		}
+	}
+
+	// an instance without IP (ex: stopped) or without key pair can't be a target
+	invalid := []struct {
+		instance describeEC2.Instance
+		options  Options
+	}{
+		{describeEC2.Instance{InstanceID: "i-2", KeyName: "mykey", State: "stopped"}, Options{}},
+		{describeEC2.Instance{InstanceID: "i-3", PublicIP: "203.0.113.11", KeyName: "mykey"}, Options{PrivateIP: true}},
+		{describeEC2.Instance{InstanceID: "i-4", PublicIP: "203.0.113.12"}, Options{}},
+	}
+	for _, test := range invalid {
+		if _, err := NewTarget(test.instance, "", test.options); err == nil {
+			t.Errorf("NewTarget(%s): expected an error", test.instance.InstanceID)
+		}
+	}
+	// the key file given in the options doesn't need a key pair
+	if _, err := NewTarget(describeEC2.Instance{InstanceID: "i-5", PublicIP: "203.0.113.13"}, "", Options{KeyFile: "key.pem"}); err != nil {
+		t.Errorf("NewTarget(i-5) with a key file = %v", err)
+	}
+}
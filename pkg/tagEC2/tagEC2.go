@@ -0,0 +1,210 @@
+package tagEC2
+
+import (
+	"aws/pkg/dryrun"
+	"aws/pkg/fleet"
+	"aws/pkg/report"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// Options of TagInstances. The zero value uses the defaults.
+type Options struct {
+	// number of IDs per CreateTags and DeleteTags request (default and maximum: MaxBatchSize)
+	BatchSize int
+	// number of requests sent at the same time
+	Fleet fleet.Options
+	// if true, the tagging requests are sent with DryRun=true: AWS checks the
+	// permissions without changing the tags, and the changes that would be done
+	// are printed (see package dryrun)
+	DryRun bool
+	// writer where the progress messages are printed (default: os.Stdout; set it
+	// to os.Stderr to keep stdout for the results, or to io.Discard)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// Maximum number of instance IDs in one CreateTags or DeleteTags request.
+const MaxBatchSize = 1000
+
+// Action of the results and errors of this package.
+const actionTag = "tag"
+
+// Tags added and removed on the instances.
+type changes struct {
+	set    []types.Tag // tags to add or replace, sorted by key
+	remove []types.Tag // keys of the tags to remove
+	text   string      // as printed in dry run mode (ex: "set env=dev, remove owner")
+}
+
+// Adds (or replaces) the tags "key=value" given in parameter on the instances,
+// and removes the tags of keys given in removeKeys.
+// The instances are tagged in batches (of options.BatchSize IDs per request),
+// options.Fleet.Concurrency requests at the same time.
+// The result is returned even if some instances couldn't be tagged (along with a *report.Error).
+func TagInstances(ec2client *ec2.Client, instanceIds []string, tags map[string]string, removeKeys []string, options Options) (*report.Result, error) {
+	// sorted, so that the tags are always in the same order
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	changes := changes{text: describeChanges(keys, tags, removeKeys)}
+	for _, key := range keys {
+		key, value := key, tags[key]
+		changes.set = append(changes.set, types.Tag{Key: &key, Value: &value})
+	}
+	for _, key := range removeKeys {
+		key := key
+		changes.remove = append(changes.remove, types.Tag{Key: &key})
+	}
+
+	batchSize := options.BatchSize
+	if batchSize <= 0 || batchSize > MaxBatchSize {
+		batchSize = MaxBatchSize
+	}
+	var batches [][]string
+	for start := 0; start < len(instanceIds); start += batchSize {
+		batches = append(batches, instanceIds[start:min(start+batchSize, len(instanceIds))])
+	}
+
+	// each batch is paced and retried by the throttle of fleet.Run,
+	// and the requests of its halves by the same throttle
+	fleetOptions := options.Fleet
+	if fleetOptions.Throttle == nil {
+		fleetOptions.Throttle = fleet.NewThrottle(fleetOptions)
+	}
+	results, _ := fleet.Run(batches, fleetOptions, func(batch []string) (*report.Result, error) {
+		return tagBatch(ec2client, batch, changes, fleetOptions.Throttle, options)
+	})
+
+	// merges the results of the batches, in the order of the IDs
+	result := report.New(actionTag)
+	for _, batchResult := range results {
+		if batchResult.Err != nil {
+			result.Merge(batchError(batchResult.Item, batchResult.Err, options))
+		} else if batchResult.Value != nil {
+			result.Merge(batchResult.Value)
+		}
+	}
+	return result, result.Err()
+}
+
+// Changes the tags of the instances of IDs given in parameter with one CreateTags
+// request and one DeleteTags request.
+// If a request fails because of an invalid or unknown ID (the whole request fails),
+// the batch is split in two and each half is retried, until the invalid IDs are
+// alone in their request. Any other error is recorded for all the instances of the batch.
+// The requests are paced by the throttle of the caller: a throttled request is
+// returned as an error, for the caller to retry the batch.
+func tagBatch(ec2client *ec2.Client, instanceIds []string, changes changes, throttle *fleet.Throttle, options Options) (*report.Result, error) {
+	err := changeTags(ec2client, instanceIds, changes, options.DryRun)
+	if fleet.IsThrottled(err) {
+		return nil, err
+	}
+	if err != nil && (len(instanceIds) == 1 || !invalidInstance(err)) {
+		return batchError(instanceIds, err, options), nil
+	}
+	result := report.New(actionTag)
+	if err != nil {
+		half := len(instanceIds) / 2
+		for _, part := range [][]string{instanceIds[:half], instanceIds[half:]} {
+			var partResult *report.Result
+			err := throttle.Do(func() (err error) {
+				partResult, err = tagBatch(ec2client, part, changes, throttle, options)
+				return err
+			})
+			if err != nil {
+				partResult = batchError(part, err, options)
+			}
+			result.Merge(partResult)
+		}
+		return result, nil
+	}
+	for _, id := range instanceIds {
+		if options.DryRun {
+			result.AddDryRun(id)
+			fmt.Fprintf(options.messages(), "Dry run: instance %s would be tagged (%s).\n", id, changes.text)
+		} else {
+			result.AddDone(id, nil)
+			fmt.Fprintf(options.messages(), "Instance %s successfully tagged.\n", id)
+		}
+	}
+	return result, nil
+}
+
+// Sends the CreateTags and DeleteTags requests of the changes.
+func changeTags(ec2client *ec2.Client, instanceIds []string, changes changes, dryRun bool) error {
+	if len(changes.set) > 0 {
+		_, err := ec2client.CreateTags(context.TODO(), &ec2.CreateTagsInput{Resources: instanceIds, Tags: changes.set, DryRun: &dryRun})
+		if err = checkDryRun(err, dryRun); err != nil {
+			return err
+		}
+	}
+	if len(changes.remove) > 0 {
+		_, err := ec2client.DeleteTags(context.TODO(), &ec2.DeleteTagsInput{Resources: instanceIds, Tags: changes.remove, DryRun: &dryRun})
+		if err = checkDryRun(err, dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the result of a batch whose requests failed with the same error for all
+// its instances (ex: UnauthorizedOperation, or still throttled after the retries).
+func batchError(instanceIds []string, err error, options Options) *report.Result {
+	result := report.New(actionTag)
+	if len(instanceIds) > 1 {
+		fmt.Fprintf(options.messages(), "couldn't tag instances %s: %s\n", strings.Join(instanceIds, ", "), err)
+	}
+	for _, id := range instanceIds {
+		instanceErr := result.AddError(id, err)
+		if len(instanceIds) == 1 {
+			fmt.Fprintln(options.messages(), instanceErr)
+		}
+	}
+	return result
+}
+
+// Returns true if the error of a request is due to an invalid or unknown
+// instance ID (InvalidInstanceID.*), which fails the whole request.
+func invalidInstance(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && strings.HasPrefix(apiErr.ErrorCode(), "InvalidInstanceID.")
+}
+
+// In dry run mode, returns nil if the request would have succeeded.
+func checkDryRun(err error, dryRun bool) error {
+	if dryRun {
+		return dryrun.Check(err)
+	}
+	return err
+}
+
+// Returns the tag changes, as printed in dry run mode (ex: "set env=dev, remove owner").
+func describeChanges(keys []string, tags map[string]string, removeKeys []string) string {
+	var changes []string
+	for _, key := range keys {
+		changes = append(changes, "set "+key+"="+tags[key])
+	}
+	for _, key := range removeKeys {
+		changes = append(changes, "remove "+key)
+	}
+	return strings.Join(changes, ", ")
+}
@@ -0,0 +1,106 @@
+package tagEC2
+
+import (
+	"aws/pkg/fleet"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// Returns an EC2 client sending its requests to a fake EC2 API: handle
+// receives the action and the parameters of each request, and returns
+// the HTTP status and the XML body of the answer.
+func fakeEC2(t *testing.T, handle func(action string, params url.Values) (int, string)) *ec2.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		status, body := handle(r.Form.Get("Action"), r.Form)
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return ec2.New(ec2.Options{Region: "us-east-1", BaseEndpoint: &server.URL, RetryMaxAttempts: 1})
+}
+
+// Returns the answer of a request that failed with the AWS error code given in parameter.
+func failed(code string) (int, string) {
+	return http.StatusBadRequest, "<Response><Errors><Error><Code>" + code + "</Code><Message>" + code + "</Message></Error></Errors></Response>"
+}
+
+func TestTagInstances(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	ec2client := fakeEC2(t, func(action string, params url.Values) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		var ids []string
+		for i := 1; params.Has(fmt.Sprintf("ResourceId.%d", i)); i++ {
+			ids = append(ids, params.Get(fmt.Sprintf("ResourceId.%d", i)))
+		}
+		requests = append(requests, fmt.Sprint(action, ids))
+		for _, id := range ids {
+			switch id {
+			case "i-gone":
+				return failed("InvalidInstanceID.NotFound")
+			case "i-denied":
+				return failed("UnauthorizedOperation")
+			}
+		}
+		return http.StatusOK, "<" + action + "Response><return>true</return></" + action + "Response>"
+	})
+
+	tests := []struct {
+		name       string
+		ids        []string
+		removeKeys []string
+		batchSize  int
+		requests   []string // CreateTags and DeleteTags requests sent
+		failed     []string // instances that couldn't be tagged
+	}{
+		{
+			"batches", []string{"i-1", "i-2", "i-3"}, []string{"owner"}, 2,
+			[]string{"CreateTags[i-1 i-2]", "DeleteTags[i-1 i-2]", "CreateTags[i-3]", "DeleteTags[i-3]"}, nil,
+		},
+		{
+			// the batch is split until the unknown instance is alone in its request
+			"unknown instance", []string{"i-1", "i-gone", "i-3"}, nil, 0,
+			[]string{"CreateTags[i-1 i-gone i-3]", "CreateTags[i-1]", "CreateTags[i-gone i-3]", "CreateTags[i-gone]", "CreateTags[i-3]"},
+			[]string{"i-gone"},
+		},
+		{
+			// an error that isn't due to some instances is recorded for the whole batch
+			"batch error", []string{"i-denied", "i-2"}, nil, 0,
+			[]string{"CreateTags[i-denied i-2]"}, []string{"i-denied", "i-2"},
+		},
+	}
+	for _, test := range tests {
+		requests = nil
+		options := Options{BatchSize: test.batchSize, Fleet: fleet.Options{Concurrency: 1}, Output: io.Discard}
+		result, err := TagInstances(ec2client, test.ids, map[string]string{"env": "dev"}, test.removeKeys, options)
+		if !reflect.DeepEqual(requests, test.requests) {
+			t.Errorf("%s: requests %v, expected %v", test.name, requests, test.requests)
+		}
+		var failedIDs []string
+		for _, instance := range result.Instances {
+			if instance.Error != "" {
+				failedIDs = append(failedIDs, instance.InstanceID)
+			}
+		}
+		if !reflect.DeepEqual(failedIDs, test.failed) || result.Succeeded != len(test.ids)-len(test.failed) {
+			t.Errorf("%s: %d tagged, failed instances %v, expected %d and %v",
+				test.name, result.Succeeded, failedIDs, len(test.ids)-len(test.failed), test.failed)
+		}
+		if (err != nil) != (len(test.failed) > 0) {
+			t.Errorf("%s: TagInstances() = %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,599 @@
+package teardownEC2
+
+import (
+	"aws/pkg/confirm"
+	"aws/pkg/deleteEC2"
+	"aws/pkg/describeEC2"
+	"aws/pkg/dryrun"
+	"aws/pkg/eipEC2"
+	"aws/pkg/output"
+	"aws/pkg/selector"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// Types of the resources deleted by a teardown, in the order they're deleted.
+const (
+	Instance      = "instance"
+	ElasticIP     = "elastic-ip"
+	Volume        = "volume"
+	SecurityGroup = "security-group"
+	KeyPair       = "key-pair"
+	KeyFile       = "key-file" // local file of the private key of a key pair
+)
+
+// A resource found by FindResources.
+type Resource struct {
+	Type   string `json:"type" yaml:"type"`
+	ID     string `json:"id" yaml:"id"`                             // instance ID, allocation ID, group ID, key name, file path...
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`     // name or public IP
+	Reason string `json:"reason,omitempty" yaml:"reason,omitempty"` // why the resource is kept
+	// key pairs and key files: the instances of the plan using them
+	// (they're not deleted if one of these instances isn't terminated)
+	Instances []string `json:"instances,omitempty" yaml:"instances,omitempty"`
+}
+
+// Resources to delete, in the order they're deleted,
+// and the related resources that are kept (ex: a security group used by another instance).
+type Plan struct {
+	Resources []Resource `json:"resources" yaml:"resources"`
+	Kept      []Resource `json:"kept,omitempty" yaml:"kept,omitempty"`
+}
+
+func (p *Plan) Table() ([]string, [][]string) {
+	var rows [][]string
+	for _, r := range p.Resources {
+		rows = append(rows, []string{r.Type, r.ID, r.Name, "delete"})
+	}
+	for _, r := range p.Kept {
+		rows = append(rows, []string{r.Type, r.ID, r.Name, "keep: " + r.Reason})
+	}
+	return []string{"TYPE", "ID", "NAME", "ACTION"}, rows
+}
+
+func (p *Plan) IDs() []string {
+	var ids []string
+	for _, r := range p.Resources {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+// Options of a teardown. The zero value uses the defaults.
+type Options struct {
+	// deletion of the instances (Wait is always set: the other resources
+	// can only be deleted once the instances are terminated; DryRun and Output
+	// are set to those of Options)
+	Delete deleteEC2.DeleteOptions
+	// if true, the local files of the deleted key pairs (<key name>.pem in KeyDir) are removed too
+	RemoveKeyFiles bool
+	KeyDir         string // default: current directory
+	// if true, the deletion requests are sent with DryRun=true (Delete.DryRun is set too):
+	// AWS checks the permissions without deleting anything (see package dryrun)
+	DryRun bool
+	// writer where the progress messages are printed (default: os.Stdout; set it
+	// to os.Stderr to keep stdout for the results, or to io.Discard)
+	Output io.Writer
+}
+
+// Returns the writer of the progress messages.
+func (o Options) messages() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
+}
+
+// Finds the instances selected by the selector (ex: "name=web", see package selector)
+// and the resources they use: Elastic IPs, EBS volumes not deleted with the instance,
+// security groups and key pairs (and the local files of the keys, if options.RemoveKeyFiles).
+// The instances kept by the guardrails (see deleteEC2.Guardrails) and the resources
+// shared with other instances are kept.
+func FindResources(ec2client *ec2.Client, sel *selector.Selector, options Options) (*Plan, error) {
+	var instances []types.Instance
+	err := describeEC2.EachInstance(ec2client, &ec2.DescribeInstancesInput{Filters: sel.Filters}, func(instance types.Instance) error {
+		if sel.Match(instance) && !describeEC2.IsTerminated(instance) {
+			instances = append(instances, instance)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching info on instances selected by %q failed: %w", sel, err)
+	}
+	deletable, skipped, err := options.Delete.Guardrails.Filter(instances)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	for _, s := range skipped {
+		plan.Kept = append(plan.Kept, Resource{Type: Instance, ID: s.InstanceID, Reason: s.Reason})
+	}
+	selected := make(map[string]bool)
+	var instanceIDs []string
+	for _, instance := range deletable {
+		selected[*instance.InstanceId] = true
+		instanceIDs = append(instanceIDs, *instance.InstanceId)
+		plan.Resources = append(plan.Resources, Resource{Type: Instance, ID: *instance.InstanceId, Name: describeEC2.NewInstance(instance).Name})
+	}
+
+	// Elastic IPs associated to the instances, or allocated for them
+	addresses, keptAddresses, err := findAddresses(ec2client, instanceIDs)
+	if err != nil {
+		return nil, err
+	}
+	plan.Resources = append(plan.Resources, addresses...)
+	plan.Kept = append(plan.Kept, keptAddresses...)
+
+	// volumes that stay after the termination of the instances
+	seen := make(map[string]bool)
+	for _, instance := range deletable {
+		for _, mapping := range instance.BlockDeviceMappings {
+			if mapping.Ebs == nil || mapping.Ebs.VolumeId == nil || seen[*mapping.Ebs.VolumeId] {
+				continue
+			}
+			if mapping.Ebs.DeleteOnTermination != nil && *mapping.Ebs.DeleteOnTermination {
+				continue
+			}
+			seen[*mapping.Ebs.VolumeId] = true
+			plan.Resources = append(plan.Resources, Resource{Type: Volume, ID: *mapping.Ebs.VolumeId, Name: deref(mapping.DeviceName)})
+		}
+	}
+
+	// security groups and key pairs, if no other instance uses them
+	for _, instance := range deletable {
+		for _, group := range instance.SecurityGroups {
+			if group.GroupId == nil || seen[*group.GroupId] {
+				continue
+			}
+			seen[*group.GroupId] = true
+			resource := Resource{Type: SecurityGroup, ID: *group.GroupId, Name: deref(group.GroupName)}
+			if resource.Name == "default" {
+				resource.Reason = "default security group of the VPC"
+				plan.Kept = append(plan.Kept, resource)
+				continue
+			}
+			user, err := usedByOther(ec2client, "instance.group-id", resource.ID, selected)
+			if err != nil {
+				return nil, err
+			}
+			if user != "" {
+				resource.Reason = "used by instance " + user
+				plan.Kept = append(plan.Kept, resource)
+				continue
+			}
+			plan.Resources = append(plan.Resources, resource)
+		}
+	}
+	keyUsers := make(map[string][]string)
+	for _, instance := range deletable {
+		if instance.KeyName != nil {
+			keyUsers[*instance.KeyName] = append(keyUsers[*instance.KeyName], *instance.InstanceId)
+		}
+	}
+	var keyFiles []Resource
+	for _, instance := range deletable {
+		if instance.KeyName == nil || seen["key:"+*instance.KeyName] {
+			continue
+		}
+		seen["key:"+*instance.KeyName] = true
+		resource := Resource{Type: KeyPair, ID: *instance.KeyName, Instances: keyUsers[*instance.KeyName]}
+		user, err := usedByOther(ec2client, "key-name", resource.ID, selected)
+		if err != nil {
+			return nil, err
+		}
+		if user != "" {
+			resource.Reason = "used by instance " + user
+			plan.Kept = append(plan.Kept, resource)
+			continue
+		}
+		plan.Resources = append(plan.Resources, resource)
+		if options.RemoveKeyFiles {
+			path := filepath.Join(options.KeyDir, resource.ID+".pem")
+			if _, err := os.Stat(path); err == nil {
+				keyFiles = append(keyFiles, Resource{Type: KeyFile, ID: path, Name: resource.ID, Instances: resource.Instances})
+			}
+		}
+	}
+	plan.Resources = append(plan.Resources, keyFiles...)
+	return plan, nil
+}
+
+// Returns the Elastic IPs allocated for the instances of IDs given in parameter
+// (tagged eipEC2.InstanceTag by eipEC2.AllocateFor), even if disassociated.
+// The other addresses associated with the instances are kept (terminating the instances
+// disassociates them), and so are the addresses allocated for the instances but now
+// associated with other instances.
+func findAddresses(ec2client *ec2.Client, instanceIDs []string) (addresses []Resource, kept []Resource, err error) {
+	selected := make(map[string]bool)
+	for _, id := range instanceIDs {
+		selected[id] = true
+	}
+	seen := make(map[string]bool)
+	// at most 200 values per filter
+	for i := 0; i < len(instanceIDs); i += 200 {
+		for _, filterName := range []string{"instance-id", "tag:" + eipEC2.InstanceTag} {
+			describeOutput, err := ec2client.DescribeAddresses(context.TODO(), &ec2.DescribeAddressesInput{
+				Filters: []types.Filter{{Name: &filterName, Values: instanceIDs[i:min(i+200, len(instanceIDs))]}},
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("error fetching Elastic IPs info: %w", err)
+			}
+			for _, address := range describeOutput.Addresses {
+				if address.AllocationId == nil || seen[*address.AllocationId] {
+					continue
+				}
+				seen[*address.AllocationId] = true
+				if resource := addressResource(address, selected); resource.Reason != "" {
+					kept = append(kept, resource)
+				} else {
+					addresses = append(addresses, resource)
+				}
+			}
+		}
+	}
+	return addresses, kept, nil
+}
+
+// Returns the resource of an Elastic IP associated with or allocated for the selected
+// instances, with the reason why it's kept if it must not be deleted.
+func addressResource(address types.Address, selected map[string]bool) Resource {
+	resource := Resource{Type: ElasticIP, ID: *address.AllocationId, Name: deref(address.PublicIp)}
+	allocatedFor := ""
+	for _, tag := range address.Tags {
+		if deref(tag.Key) == eipEC2.InstanceTag {
+			allocatedFor = deref(tag.Value)
+		}
+	}
+	switch {
+	case address.InstanceId != nil && !selected[*address.InstanceId]:
+		resource.Reason = "associated with instance " + *address.InstanceId
+	case !selected[allocatedFor]:
+		resource.Reason = "not allocated for the instance (disassociated when it's terminated)"
+	}
+	return resource
+}
+
+// Returns the ID of a non-terminated instance that isn't selected and matches
+// the filter "name=value" (ex: the instances using a key pair), or "" if there is none.
+func usedByOther(ec2client *ec2.Client, filterName string, value string, selected map[string]bool) (string, error) {
+	var user string
+	input := &ec2.DescribeInstancesInput{Filters: []types.Filter{{Name: &filterName, Values: []string{value}}}}
+	err := describeEC2.EachInstance(ec2client, input, func(instance types.Instance) error {
+		if !selected[*instance.InstanceId] && !describeEC2.IsTerminated(instance) {
+			user = *instance.InstanceId
+			return describeEC2.StopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("couldn't check the instances using %s: %w", value, err)
+	}
+	return user, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Result of the deletion of one resource.
+type ResourceResult struct {
+	Type    string `json:"type" yaml:"type"`
+	ID      string `json:"id" yaml:"id"`
+	Name    string `json:"name,omitempty" yaml:"name,omitempty"`
+	Deleted bool   `json:"deleted" yaml:"deleted"`
+	DryRun  bool   `json:"dry_run,omitempty" yaml:"dry_run,omitempty"` // true if it would have been deleted
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Result of a teardown.
+type TeardownResult struct {
+	Succeeded int              `json:"succeeded" yaml:"succeeded"`
+	Failed    int              `json:"failed" yaml:"failed"`
+	Resources []ResourceResult `json:"resources" yaml:"resources"`
+	Kept      []Resource       `json:"kept,omitempty" yaml:"kept,omitempty"`
+
+	errs    []error
+	options Options // dry run mode, and writer of the progress messages
+}
+
+func (r *TeardownResult) Table() ([]string, [][]string) {
+	rows := make([][]string, len(r.Resources))
+	for i, resource := range r.Resources {
+		deleted := fmt.Sprint(resource.Deleted)
+		if resource.DryRun {
+			deleted = "dry run"
+		}
+		rows[i] = []string{resource.Type, resource.ID, resource.Name, deleted, resource.Error}
+	}
+	return []string{"TYPE", "ID", "NAME", "DELETED", "ERROR"}, rows
+}
+
+// Returns the IDs of the deleted resources.
+func (r *TeardownResult) IDs() []string {
+	var ids []string
+	for _, resource := range r.Resources {
+		if resource.Deleted {
+			ids = append(ids, resource.ID)
+		}
+	}
+	return ids
+}
+
+// Returns nil if all the resources were deleted,
+// else an error joining the errors of the resources that weren't.
+func (r *TeardownResult) Err() error {
+	if r.Failed == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d resources couldn't be deleted: %w", r.Failed, errors.Join(r.errs...))
+}
+
+// Records a resource that would be deleted, in dry run mode.
+func (r *TeardownResult) addDryRun(resource Resource) {
+	r.Succeeded++
+	r.Resources = append(r.Resources, ResourceResult{Type: resource.Type, ID: resource.ID, Name: resource.Name, DryRun: true})
+	fmt.Fprintf(r.options.messages(), "Dry run: %s %s would be deleted.\n", resource.Type, resource.ID)
+}
+
+// Records the result of the deletion of a resource (err is the error of the deletion request).
+func (r *TeardownResult) add(resource Resource, err error) {
+	if r.options.DryRun && dryrun.Succeeded(err) {
+		r.addDryRun(resource)
+		return
+	}
+	result := ResourceResult{Type: resource.Type, ID: resource.ID, Name: resource.Name}
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+		r.Failed++
+		r.errs = append(r.errs, fmt.Errorf("%s %s: %w", resource.Type, resource.ID, err))
+		fmt.Fprintf(r.options.messages(), "Couldn't delete %s %s: %s\n", resource.Type, resource.ID, err)
+	default:
+		result.Deleted = true
+		r.Succeeded++
+		fmt.Fprintf(r.options.messages(), "%s %s deleted.\n", resource.Type, resource.ID)
+	}
+	r.Resources = append(r.Resources, result)
+}
+
+// Deletes the instances selected by the selector and the resources they use
+// (see FindResources and DeleteResources).
+// If the action is aborted, the result is nil.
+func Teardown(ec2client *ec2.Client, sel *selector.Selector, confirmer confirm.Confirmer, options Options) (*TeardownResult, error) {
+	plan, err := FindResources(ec2client, sel, options)
+	if err != nil {
+		return nil, err
+	}
+	return DeleteResources(ec2client, plan, confirmer, options)
+}
+
+// Order in which the types of resources are deleted.
+var deletionOrder = []string{Instance, ElasticIP, Volume, SecurityGroup, KeyPair, KeyFile}
+
+// Deletes the resources of the plan in dependency order: the instances first
+// (waiting until they're terminated), then the Elastic IPs, volumes, security groups,
+// key pairs and key files. The plan is first printed, and the confirmer is asked
+// to confirm by typing the number of resources (or the account ID, see deleteEC2.Guardrails).
+// If the action is aborted, the result is nil.
+// If some instances aren't terminated, the resources depending on them are not deleted:
+// none of the Elastic IPs, volumes and security groups, and not the key pairs and key
+// files of these instances (which would still be running without their keys).
+// In dry run mode, nothing is asked and the requests are sent with DryRun=true.
+func DeleteResources(ec2client *ec2.Client, plan *Plan, confirmer confirm.Confirmer, options Options) (*TeardownResult, error) {
+	sort.SliceStable(plan.Resources, func(i, j int) bool {
+		return slices.Index(deletionOrder, plan.Resources[i].Type) < slices.Index(deletionOrder, plan.Resources[j].Type)
+	})
+	if err := applyGuardrails(ec2client, plan, options.Delete.Guardrails); err != nil {
+		return nil, err
+	}
+	result := &TeardownResult{Resources: []ResourceResult{}, Kept: plan.Kept, options: options}
+	header, rows := plan.Table()
+	if len(rows) > 0 {
+		output.PrintTable(options.messages(), header, rows)
+	}
+	if len(plan.Resources) == 0 {
+		fmt.Fprintln(options.messages(), "No resource found.")
+		return result, nil
+	}
+
+	var instanceIDs []string
+	for _, resource := range plan.Resources {
+		if resource.Type == Instance {
+			instanceIDs = append(instanceIDs, resource.ID)
+		}
+	}
+	guardrails := options.Delete.Guardrails
+	if err := guardrails.CheckThreshold(len(instanceIDs)); err != nil {
+		return nil, err
+	}
+	if options.DryRun {
+		confirmer = confirm.AutoYes{}
+	}
+	expected, err := guardrails.ExpectedAnswer(ec2client, len(plan.Resources))
+	if err != nil {
+		return nil, err
+	}
+	proceed, err := confirm.Typed(confirmer, confirm.ActionTeardown, fmt.Sprintf("You asked to delete %d resources (%d instances). This action is non-reversible.", len(plan.Resources), len(instanceIDs)), expected)
+	if err != nil {
+		return nil, err
+	}
+	if !proceed {
+		fmt.Fprintln(options.messages(), "Action aborted.")
+		return nil, nil
+	}
+
+	// the instances must be terminated before their resources can be deleted
+	terminated := true
+	notTerminated := make(map[string]bool)
+	if len(instanceIDs) > 0 {
+		deleteOptions := options.Delete
+		deleteOptions.Wait = true
+		deleteOptions.DryRun = options.DryRun
+		deleteOptions.Output = options.Output
+		deleted, err := deleteEC2.DeleteInstances(ec2client, instanceIDs, deleteOptions)
+		terminated = err == nil
+		for _, id := range instanceIDs {
+			notTerminated[id] = true
+		}
+		for _, instance := range deleted.Instances {
+			resource := Resource{Type: Instance, ID: instance.InstanceID}
+			switch {
+			case instance.DryRun:
+				result.addDryRun(resource)
+				delete(notTerminated, instance.InstanceID)
+			case instance.Error != "":
+				result.add(resource, errors.New(instance.Error))
+			case !options.DryRun && instance.FinalState != string(types.InstanceStateNameTerminated):
+				result.add(resource, fmt.Errorf("not terminated (state: %s)", instance.FinalState))
+			default:
+				result.add(resource, nil)
+				delete(notTerminated, instance.InstanceID)
+			}
+		}
+	}
+
+	for _, resource := range plan.Resources {
+		if resource.Type == Instance {
+			continue
+		}
+		if !terminated && resource.Type != KeyPair && resource.Type != KeyFile {
+			result.add(resource, errors.New("not deleted: some instances weren't terminated"))
+			continue
+		}
+		if resource.Type == KeyPair || resource.Type == KeyFile {
+			if user := keyUser(resource, terminated, notTerminated); user != "" {
+				result.add(resource, fmt.Errorf("not deleted: used by %s, which wasn't terminated", user))
+				continue
+			}
+		}
+		if options.DryRun && resource.Type == KeyFile {
+			result.addDryRun(resource)
+			continue
+		}
+		// the security groups of the instances just terminated may still be used
+		// by their network interfaces for a moment
+		result.add(resource, deleteResource(ec2client, resource, len(instanceIDs) > 0, options))
+	}
+
+	if result.Failed > 0 {
+		fmt.Fprintf(options.messages(), "%d resources deleted, %d failed\n", result.Succeeded, result.Failed)
+		return result, result.Err()
+	}
+	fmt.Fprintln(options.messages(), "Done")
+	return result, nil
+}
+
+// Returns the instance using the key pair or key file that wasn't terminated, or ""
+// if there is none. If the plan doesn't give the instances using the key (it wasn't
+// made by FindResources), the key is kept as soon as an instance wasn't terminated.
+func keyUser(resource Resource, terminated bool, notTerminated map[string]bool) string {
+	if len(resource.Instances) == 0 && !terminated {
+		return "an instance"
+	}
+	for _, id := range resource.Instances {
+		if notTerminated[id] {
+			return "instance " + id
+		}
+	}
+	return ""
+}
+
+// Moves the instances of the plan kept by the guardrails (protection tag, policy)
+// to the kept resources (for the plans that weren't made by FindResources).
+func applyGuardrails(ec2client *ec2.Client, plan *Plan, guardrails deleteEC2.Guardrails) error {
+	var instanceIDs []string
+	for _, resource := range plan.Resources {
+		if resource.Type == Instance {
+			instanceIDs = append(instanceIDs, resource.ID)
+		}
+	}
+	var instances []types.Instance
+	for i := 0; i < len(instanceIDs); i += 200 {
+		filterName := "instance-id"
+		input := &ec2.DescribeInstancesInput{Filters: []types.Filter{{Name: &filterName, Values: instanceIDs[i:min(i+200, len(instanceIDs))]}}}
+		err := describeEC2.EachInstance(ec2client, input, func(instance types.Instance) error {
+			instances = append(instances, instance)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("fetching info on instances failed: %w", err)
+		}
+	}
+	_, skipped, err := guardrails.Filter(instances)
+	if err != nil || len(skipped) == 0 {
+		return err
+	}
+	reasons := make(map[string]string)
+	for _, s := range skipped {
+		reasons[s.InstanceID] = s.Reason
+	}
+	var resources []Resource
+	for _, resource := range plan.Resources {
+		if reason, ok := reasons[resource.ID]; ok && resource.Type == Instance {
+			resource.Reason = reason
+			plan.Kept = append(plan.Kept, resource)
+			continue
+		}
+		resources = append(resources, resource)
+	}
+	plan.Resources = resources
+	return nil
+}
+
+// Time waited for a volume to be detached from its terminated instance,
+// and for the network interfaces using a security group to be deleted.
+const detachTimeout = 5 * time.Minute
+
+// Deletes a resource that isn't an instance. If instancesTerminated (instances were
+// just terminated), the deletion of a security group still in use (DependencyViolation)
+// is retried until the network interfaces of the instances are deleted (at most
+// detachTimeout); otherwise the group is used by other resources, and it fails at once.
+func deleteResource(ec2client *ec2.Client, resource Resource, instancesTerminated bool, options Options) error {
+	switch resource.Type {
+	case ElasticIP:
+		_, err := ec2client.ReleaseAddress(context.TODO(), &ec2.ReleaseAddressInput{AllocationId: &resource.ID, DryRun: &options.DryRun})
+		return err
+	case Volume:
+		if !options.DryRun {
+			waiter := ec2.NewVolumeAvailableWaiter(ec2client)
+			err := waiter.Wait(context.TODO(), &ec2.DescribeVolumesInput{VolumeIds: []string{resource.ID}}, detachTimeout)
+			if err != nil {
+				return fmt.Errorf("volume still attached: %w", err)
+			}
+		}
+		_, err := ec2client.DeleteVolume(context.TODO(), &ec2.DeleteVolumeInput{VolumeId: &resource.ID, DryRun: &options.DryRun})
+		return err
+	case SecurityGroup:
+		// the network interfaces of the terminated instances can take a moment to be deleted
+		start := time.Now()
+		for {
+			_, err := ec2client.DeleteSecurityGroup(context.TODO(), &ec2.DeleteSecurityGroupInput{GroupId: &resource.ID, DryRun: &options.DryRun})
+			var apiErr smithy.APIError
+			if !instancesTerminated || options.DryRun || !errors.As(err, &apiErr) || apiErr.ErrorCode() != "DependencyViolation" || time.Since(start) > detachTimeout {
+				return err
+			}
+			time.Sleep(10 * time.Second)
+		}
+	case KeyPair:
+		_, err := ec2client.DeleteKeyPair(context.TODO(), &ec2.DeleteKeyPairInput{KeyName: &resource.ID, DryRun: &options.DryRun})
+		return err
+	case KeyFile:
+		return os.Remove(resource.ID)
+	}
+	return fmt.Errorf("unknown resource type %q", resource.Type)
+}
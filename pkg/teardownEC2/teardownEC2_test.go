@@ -0,0 +1,79 @@
+package teardownEC2
+
+import (
+	"aws/pkg/eipEC2"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestKeyUser(t *testing.T) {
+	notTerminated := map[string]bool{"i-running": true}
+	tests := []struct {
+		resource   Resource
+		terminated bool
+		user       string
+	}{
+		{Resource{Type: KeyPair, ID: "a", Instances: []string{"i-gone"}}, false, ""},
+		{Resource{Type: KeyPair, ID: "b", Instances: []string{"i-gone", "i-running"}}, false, "instance i-running"},
+		{Resource{Type: KeyFile, ID: "b.pem", Instances: []string{"i-running"}}, false, "instance i-running"},
+		// plan without the instances using the key
+		{Resource{Type: KeyPair, ID: "c"}, false, "an instance"},
+		{Resource{Type: KeyPair, ID: "c"}, true, ""},
+	}
+	for _, test := range tests {
+		if user := keyUser(test.resource, test.terminated, notTerminated); user != test.user {
+			t.Errorf("keyUser(%s %s, terminated: %v) = %q, expected %q", test.resource.Type, test.resource.ID, test.terminated, user, test.user)
+		}
+	}
+}
+
+func TestAddressResource(t *testing.T) {
+	selected := map[string]bool{"i-1": true}
+	allocatedFor := func(id string) []types.Tag {
+		return []types.Tag{{Key: aws.String(eipEC2.InstanceTag), Value: aws.String(id)}}
+	}
+	tests := []struct {
+		address types.Address
+		reason  string
+	}{
+		// allocated for the instance by launch --eip, associated or not
+		{types.Address{InstanceId: aws.String("i-1"), Tags: allocatedFor("i-1")}, ""},
+		{types.Address{Tags: allocatedFor("i-1")}, ""},
+		// allocated for the instance, but now associated with another one
+		{types.Address{InstanceId: aws.String("i-2"), Tags: allocatedFor("i-1")}, "associated with instance i-2"},
+		// associated with the instance, but not allocated for it
+		{types.Address{InstanceId: aws.String("i-1")}, "not allocated for the instance (disassociated when it's terminated)"},
+		{types.Address{InstanceId: aws.String("i-1"), Tags: allocatedFor("i-3")}, "not allocated for the instance (disassociated when it's terminated)"},
+	}
+	for i, test := range tests {
+		test.address.AllocationId = aws.String("eipalloc-1")
+		if resource := addressResource(test.address, selected); resource.Reason != test.reason {
+			t.Errorf("test %d: addressResource() kept for %q, expected %q", i, resource.Reason, test.reason)
+		}
+	}
+}
+
+func TestDeleteSecurityGroupInUse(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "<Response><Errors><Error><Code>DependencyViolation</Code><Message>in use</Message></Error></Errors></Response>")
+	}))
+	defer server.Close()
+	ec2client := ec2.New(ec2.Options{Region: "us-east-1", BaseEndpoint: &server.URL, RetryMaxAttempts: 1})
+
+	// without instances just terminated (ex: audit --delete), a group
+	// still in use isn't waited for
+	group := Resource{Type: SecurityGroup, ID: "sg-1"}
+	if err := deleteResource(ec2client, group, false, Options{Output: io.Discard}); err == nil || requests != 1 {
+		t.Errorf("deleteResource(sg-1) = %v after %d requests, expected DependencyViolation after 1 request", err, requests)
+	}
+}